@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 
-	_ "github.com/newrelic/nr-entity-tag-sync/internal/provider/servicenow"
-
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/newrelic/nr-entity-tag-sync/internal/sync"
-	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/tagsync"
 )
 
 type TagSyncResult struct {
@@ -16,22 +14,34 @@ type TagSyncResult struct {
   Message           error
 }
 
-func HandleRequest(ctx context.Context) (TagSyncResult, error) {
-  i, err := interop.NewInteroperability()
+// TagSyncEvent is the optional input event used to run a subset of the
+// mappings. Mappings are selected by name or index and labels select all
+// enabled mappings with the label. If neither is set, all enabled mappings are
+// run.
+type TagSyncEvent struct {
+  Mappings          []string        `json:"mappings"`
+  Labels            []string        `json:"labels"`
+}
+
+func HandleRequest(ctx context.Context, event TagSyncEvent) (TagSyncResult, error) {
+  syncer, err := tagsync.Load("")
   if err != nil {
-    retErr := fmt.Errorf("failed to create interop: %s", err)
-    return TagSyncResult{false, retErr}, retErr
+    return TagSyncResult{false, err}, err
   }
 
-  defer i.Shutdown()
+  defer syncer.Close()
+
+  if lc, ok := lambdacontext.FromContext(ctx); ok {
+    syncer.SetRequestId(lc.AwsRequestID)
+  }
 
-  syncer, err := sync.New(i)
+  indices, err := syncer.SelectMappings(event.Mappings, event.Labels)
   if err != nil {
-    retErr := fmt.Errorf("failed to create syncer: %s", err)
+    retErr := fmt.Errorf("invalid mapping selection: %s", err)
     return TagSyncResult{false, retErr}, retErr
   }
 
-  err = syncer.Sync()
+  _, err = syncer.Sync(ctx, indices)
   if err != nil {
     retErr := fmt.Errorf("sync failed: %s", err)
     return TagSyncResult{false, retErr}, retErr
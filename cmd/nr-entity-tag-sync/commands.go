@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/version"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/tagsync"
+)
+
+// load creates the syncer and, if it could not be created, returns the exit
+// code for the step that failed along with the error.
+func load(flags *commonFlags) (*tagsync.Syncer, int, error) {
+  syncer, err := tagsync.Load(flags.configFile)
+  if err == nil {
+    return syncer, EXIT_OK, nil
+  }
+
+  var setupErr *tagsync.SetupError
+  if errors.As(err, &setupErr) && setupErr.Step == tagsync.SETUP_SYNCER {
+    return nil, EXIT_SYNCER_FAILED, err
+  }
+
+  return nil, EXIT_INTEROP_FAILED, err
+}
+
+func setup(flags *commonFlags) (*tagsync.Syncer, int) {
+  syncer, code, err := load(flags)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%s\n", err)
+  }
+
+  return syncer, code
+}
+
+func selectMappings(syncer *tagsync.Syncer, flags *commonFlags) ([]int, int) {
+  indices, err := syncer.SelectMappings(flags.mappings, flags.labels)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "invalid mapping selection: %s\n", err)
+    return nil, EXIT_USAGE
+  }
+
+  return indices, EXIT_OK
+}
+
+func runSync(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("sync", flags)
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  syncer, code := setup(flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  defer syncer.Close()
+
+  indices, code := selectMappings(syncer, flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  result, err := syncer.Sync(context.Background(), indices)
+
+  writeSyncResult(flags.output, result)
+
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "sync failed: %s\n", err)
+    return EXIT_SYNC_FAILED
+  }
+
+  return EXIT_OK
+}
+
+func runPlan(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("plan", flags)
+  detailedExitCode := fs.Bool(
+    "detailed-exitcode",
+    false,
+    fmt.Sprintf("exit with code %d if there are pending changes", EXIT_PLAN_CHANGES),
+  )
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  syncer, code := setup(flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  defer syncer.Close()
+
+  indices, code := selectMappings(syncer, flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  result, err := syncer.Plan(context.Background(), indices)
+
+  writePlanResult(flags.output, result)
+
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "plan failed: %s\n", err)
+    return EXIT_SYNC_FAILED
+  }
+
+  if *detailedExitCode && hasChanges(result) {
+    return EXIT_PLAN_CHANGES
+  }
+
+  return EXIT_OK
+}
+
+func runValidate(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("validate", flags)
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  syncer, code, err := load(flags)
+  if err != nil {
+    writeValidationResult(flags.output, errors.Unwrap(err))
+    return code
+  }
+
+  defer syncer.Close()
+
+  if _, err := syncer.SelectMappings(flags.mappings, flags.labels); err != nil {
+    writeValidationResult(flags.output, err)
+    return EXIT_USAGE
+  }
+
+  writeValidationResult(flags.output, nil)
+
+  return EXIT_OK
+}
+
+func runExplain(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("explain", flags)
+  entity := fs.String("entity", "", "GUID or name of the New Relic entity to explain")
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  if *entity == "" && fs.NArg() > 0 {
+    *entity = fs.Arg(0)
+  }
+
+  if *entity == "" {
+    fmt.Fprintf(os.Stderr, "missing entity GUID or name\n")
+    return EXIT_USAGE
+  }
+
+  syncer, code := setup(flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  defer syncer.Close()
+
+  indices, code := selectMappings(syncer, flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  result, err := syncer.Explain(context.Background(), indices, *entity)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "explain failed: %s\n", err)
+    return EXIT_SYNC_FAILED
+  }
+
+  writeExplainResult(flags.output, result)
+
+  return EXIT_OK
+}
+
+func runServe(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("serve", flags)
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  syncer, code := setup(flags)
+  if code != EXIT_OK {
+    return code
+  }
+
+  defer syncer.Close()
+
+  ctx, stop := signal.NotifyContext(
+    context.Background(),
+    os.Interrupt,
+    syscall.SIGTERM,
+  )
+  defer stop()
+
+  err := syncer.Serve(ctx)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "server failed: %s\n", err)
+    return EXIT_SERVER_FAILED
+  }
+
+  return EXIT_OK
+}
+
+func runProviders(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("providers", flags)
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  writeProviders(flags.output, tagsync.RegisteredProviders())
+
+  return EXIT_OK
+}
+
+func runVersion(args []string) int {
+  flags := &commonFlags{}
+  fs := newFlagSet("version", flags)
+
+  if !parseFlags(fs, flags, args) {
+    return EXIT_USAGE
+  }
+
+  writeVersion(flags.output, version.Version, version.Commit, version.Date)
+
+  return EXIT_OK
+}
+
+func hasChanges(result *tagsync.SyncResult) bool {
+  if result == nil {
+    return false
+  }
+
+  for _, mapping := range result.Mappings {
+    if len(mapping.Changes) > 0 {
+      return true
+    }
+  }
+
+  return false
+}
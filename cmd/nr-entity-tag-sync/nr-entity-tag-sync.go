@@ -1,33 +1,156 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+)
 
-	_ "github.com/newrelic/nr-entity-tag-sync/internal/provider/servicenow"
+// Exit codes
+const (
+  EXIT_OK                   = 0
+  EXIT_INTEROP_FAILED       = 1
+  EXIT_SYNCER_FAILED        = 2
+  EXIT_SYNC_FAILED          = 3
+  EXIT_SERVER_FAILED        = 4
+  EXIT_USAGE                = 5
+  EXIT_PLAN_CHANGES         = 6
+)
 
-	"github.com/newrelic/nr-entity-tag-sync/internal/sync"
-	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+const (
+  OUTPUT_TEXT = "text"
+  OUTPUT_JSON = "json"
 )
 
-func main() {
-  i, err := interop.NewInteroperability()
-  if err != nil {
-    fmt.Printf("failed to create interop: %s\n", err)
-    os.Exit(1)
+type command struct {
+  name              string
+  description       string
+  run               func(args []string) int
+}
+
+var commands []command
+
+func init() {
+  commands = []command{
+    { "sync", "Run the selected mappings and update tags (default)", runSync },
+    { "plan", "Show the tag changes that sync would apply without applying them", runPlan },
+    { "validate", "Validate the configuration", runValidate },
+    { "explain", "Explain how each mapping applies to a New Relic entity", runExplain },
+    { "serve", "Run mappings on a schedule in a long-running process", runServe },
+    { "providers", "List the available providers", runProviders },
+    { "version", "Print version information", runVersion },
+  }
+}
+
+// stringSlice is a flag.Value that collects repeated flag values. Each value
+// may also be a comma separated list.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+  return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+  for _, v := range strings.Split(value, ",") {
+    if v = strings.TrimSpace(v); v != "" {
+      *s = append(*s, v)
+    }
+  }
+
+  return nil
+}
+
+type commonFlags struct {
+  configFile        string
+  mappings          stringSlice
+  labels            stringSlice
+  output            string
+}
+
+func newFlagSet(name string, flags *commonFlags) *flag.FlagSet {
+  fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+  fs.StringVar(
+    &flags.configFile,
+    "config",
+    "",
+    "path of the configuration file (default: config.yml in ./configs or .)",
+  )
+  fs.Var(
+    &flags.mappings,
+    "mapping",
+    "name or index of a mapping to run; may be repeated (default: all enabled mappings)",
+  )
+  fs.Var(
+    &flags.labels,
+    "label",
+    "label of the enabled mappings to run; may be repeated",
+  )
+  fs.StringVar(
+    &flags.output,
+    "output",
+    OUTPUT_TEXT,
+    "output format (text or json)",
+  )
+
+  return fs
+}
+
+func parseFlags(fs *flag.FlagSet, flags *commonFlags, args []string) bool {
+  if err := fs.Parse(args); err != nil {
+    return false
+  }
+
+  if flags.output != OUTPUT_TEXT && flags.output != OUTPUT_JSON {
+    fmt.Fprintf(os.Stderr, "invalid output format: %s\n", flags.output)
+    return false
   }
 
-  defer i.Shutdown()
+  return true
+}
+
+func usage(w io.Writer) {
+  fmt.Fprintf(w, "Usage: nr-entity-tag-sync [command] [flags]\n\nCommands:\n")
 
-  syncer, err := sync.New(i)
-  if err != nil {
-    fmt.Printf("failed to create syncer: %s\n", err)
-    os.Exit(2)
+  for _, c := range commands {
+    fmt.Fprintf(w, "  %-12s %s\n", c.name, c.description)
   }
 
-  err = syncer.Sync()
-  if err != nil {
-    fmt.Printf("sync failed: %s\n", err)
-    os.Exit(3)
+  fmt.Fprintf(
+    w,
+    "\nRun 'nr-entity-tag-sync <command> -h' for the flags of a command.\n",
+  )
+}
+
+func run(args []string) int {
+  if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+    if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+      usage(os.Stdout)
+      return EXIT_OK
+    }
+
+    return runSync(args)
   }
+
+  if args[0] == "help" {
+    usage(os.Stdout)
+    return EXIT_OK
+  }
+
+  for _, c := range commands {
+    if c.name == args[0] {
+      return c.run(args[1:])
+    }
+  }
+
+  fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
+  usage(os.Stderr)
+
+  return EXIT_USAGE
+}
+
+func main() {
+  os.Exit(run(os.Args[1:]))
 }
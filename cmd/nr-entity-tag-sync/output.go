@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/pkg/tagsync"
+)
+
+func writeJSON(v interface{}) {
+  enc := json.NewEncoder(os.Stdout)
+  enc.SetIndent("", "  ")
+
+  if err := enc.Encode(v); err != nil {
+    fmt.Fprintf(os.Stderr, "failed to write output: %s\n", err)
+  }
+}
+
+func mappingDisplayName(index int, name string) string {
+  if name != "" {
+    return fmt.Sprintf("mapping %d (%s)", index, name)
+  }
+
+  return fmt.Sprintf("mapping %d", index)
+}
+
+func writeSyncResult(output string, result *tagsync.SyncResult) {
+  if result == nil {
+    return
+  }
+
+  if output == OUTPUT_JSON {
+    writeJSON(result)
+    return
+  }
+
+  for _, mapping := range result.Mappings {
+    status := "ok"
+    if mapping.Error != "" {
+      status = "error: " + mapping.Error
+    }
+
+    fmt.Printf(
+      "%s: %s; %d external entities, %d scanned, %d matched, %d skipped, %d updated, %d with errors\n",
+      mappingDisplayName(mapping.Index, mapping.Name),
+      status,
+      mapping.ExtEntityCount,
+      mapping.TotalEntitiesScanned,
+      mapping.TotalEntitiesMatched,
+      mapping.TotalEntitiesSkipped,
+      mapping.TotalEntitiesUpdated,
+      mapping.TotalEntitiesWithErrors,
+    )
+  }
+}
+
+func writePlanResult(output string, result *tagsync.SyncResult) {
+  if result == nil {
+    return
+  }
+
+  if output == OUTPUT_JSON {
+    writeJSON(result)
+    return
+  }
+
+  for _, mapping := range result.Mappings {
+    if mapping.Error != "" {
+      fmt.Printf(
+        "%s: error: %s\n",
+        mappingDisplayName(mapping.Index, mapping.Name),
+        mapping.Error,
+      )
+      continue
+    }
+
+    fmt.Printf(
+      "%s: %d entities to update\n",
+      mappingDisplayName(mapping.Index, mapping.Name),
+      len(mapping.Changes),
+    )
+
+    for _, change := range mapping.Changes {
+      writeEntityChange("  ", &change)
+    }
+  }
+}
+
+func writeEntityChange(indent string, change *tagsync.EntityChange) {
+  fmt.Printf(
+    "%s~ %s (%s) from external entity %s\n",
+    indent,
+    change.EntityName,
+    change.EntityGuid,
+    change.ExtEntityId,
+  )
+
+  for _, tag := range change.TagsToDelete {
+    fmt.Printf("%s    - %s\n", indent, tag)
+  }
+
+  for _, tag := range change.TagsToAdd {
+    fmt.Printf("%s    + %s = %s\n", indent, tag.Key, strings.Join(tag.Values, ","))
+  }
+}
+
+func writeValidationResult(output string, err error) {
+  problems := []tagsync.Problem{}
+
+  var validationErr *tagsync.ValidationError
+
+  if errors.As(err, &validationErr) {
+    problems = validationErr.Problems
+  } else if err != nil {
+    problems = append(problems, tagsync.Problem{ Message: err.Error() })
+  }
+
+  if output == OUTPUT_JSON {
+    writeJSON(map[string]interface{}{
+      "valid": err == nil,
+      "problems": problems,
+    })
+    return
+  }
+
+  if err == nil {
+    fmt.Println("configuration is valid")
+    return
+  }
+
+  fmt.Fprintf(os.Stderr, "configuration is invalid: %d problems found\n", len(problems))
+
+  for _, problem := range problems {
+    if problem.Path == "" {
+      fmt.Fprintf(os.Stderr, "  %s\n", problem.Message)
+      continue
+    }
+
+    fmt.Fprintf(os.Stderr, "  %s: %s\n", problem.Path, problem.Message)
+  }
+}
+
+func writeExplainResult(output string, result *tagsync.ExplainResult) {
+  if output == OUTPUT_JSON {
+    writeJSON(result)
+    return
+  }
+
+  for _, mapping := range result.Mappings {
+    fmt.Printf("%s:\n", mappingDisplayName(mapping.Index, mapping.Name))
+
+    if mapping.Error != "" {
+      fmt.Printf("  error: %s\n", mapping.Error)
+      continue
+    }
+
+    fmt.Printf("  external entities: %d\n", mapping.ExtEntityCount)
+
+    if !mapping.InEntityQuery {
+      fmt.Printf("  %s is not selected by the entity query\n", result.Entity)
+      continue
+    }
+
+    fmt.Printf(
+      "  %s (%s) is selected by the entity query\n",
+      mapping.EntityName,
+      mapping.EntityGuid,
+    )
+
+    if !mapping.HasEntityKey {
+      fmt.Printf("  entity has no value for the match key %s\n", mapping.EntityKey)
+      continue
+    }
+
+    fmt.Printf(
+      "  match key %s = %q, compared using %s with external entity key %s\n",
+      mapping.EntityKey,
+      mapping.EntityKeyValue,
+      mapping.Operator,
+      mapping.ExtEntityKey,
+    )
+
+    for _, candidate := range mapping.Candidates {
+      writeExplainCandidate("    ", &candidate)
+    }
+
+    if mapping.MatchedExtEntityId == "" {
+      fmt.Printf("  no external entity matches\n")
+      continue
+    }
+
+    fmt.Printf("  matches external entity %s\n", mapping.MatchedExtEntityId)
+
+    if mapping.Change == nil {
+      fmt.Printf("  tags are up to date\n")
+      continue
+    }
+
+    writeEntityChange("  ", mapping.Change)
+  }
+}
+
+func writeExplainCandidate(indent string, candidate *tagsync.ExplainCandidate) {
+  if !candidate.HasExtEntityKey {
+    fmt.Printf("%s%s: no value for the match key\n", indent, candidate.ExtEntityId)
+    return
+  }
+
+  result := "no match"
+  if candidate.Matched {
+    result = "match"
+  }
+
+  fmt.Printf(
+    "%s%s: %q: %s\n",
+    indent,
+    candidate.ExtEntityId,
+    candidate.ExtEntityKeyValue,
+    result,
+  )
+}
+
+func writeProviders(output string, providers []string) {
+  if output == OUTPUT_JSON {
+    writeJSON(providers)
+    return
+  }
+
+  for _, p := range providers {
+    fmt.Println(p)
+  }
+}
+
+func writeVersion(output string, version, commit, date string) {
+  if output == OUTPUT_JSON {
+    writeJSON(map[string]string{
+      "version": version,
+      "commit": commit,
+      "date": date,
+    })
+    return
+  }
+
+  fmt.Printf("nr-entity-tag-sync %s (commit %s, built %s)\n", version, commit, date)
+}
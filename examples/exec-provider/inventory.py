@@ -0,0 +1,122 @@
+#!/usr/bin/env python3
+"""Reference plugin for the exec provider.
+
+Reads external entities from a JSON file containing an array of objects. The
+path of the file is set with the `file` provider setting. Each object must have
+an `id` field. If the extEntityQuery of a mapping has a `type` field, only
+objects with the same `type` are returned. Objects with an `updatedAt` field
+holding an ISO 8601 timestamp older than the last update time are skipped
+during delta synchronization.
+
+See the exec provider section of the README for the protocol.
+"""
+
+import json
+import sys
+from datetime import datetime
+
+PROTOCOL_VERSION = 1
+
+
+def send(message):
+    sys.stdout.write(json.dumps(message) + "\n")
+    sys.stdout.flush()
+
+
+def log(message):
+    # Anything written to stderr is logged by the sync at the debug level
+    sys.stderr.write(message + "\n")
+
+
+def parse_time(value):
+    return datetime.fromisoformat(value.replace("Z", "+00:00"))
+
+
+def load(settings):
+    with open(settings["file"]) as f:
+        return json.load(f)
+
+
+def matches(item, query, last_update):
+    entity_type = query.get("type")
+    if entity_type and item.get("type") != entity_type:
+        return False
+
+    if last_update and "updatedAt" in item:
+        return parse_time(item["updatedAt"]) >= last_update
+
+    return True
+
+
+def send_entity(item, tags):
+    values = {tag: item[tag] for tag in tags if tag in item}
+    send({"type": "entity", "id": str(item["id"]), "tags": values})
+
+
+def main():
+    settings = {}
+
+    for line in sys.stdin:
+        request = json.loads(line)
+        kind = request["type"]
+
+        try:
+            if kind == "init":
+                if request["protocolVersion"] != PROTOCOL_VERSION:
+                    send({
+                        "type": "error",
+                        "message": "unsupported protocol version %d"
+                        % request["protocolVersion"],
+                    })
+                    continue
+
+                settings = request.get("settings", {})
+                if "file" not in settings:
+                    send({"type": "error", "message": "missing file setting"})
+                    continue
+
+                send({
+                    "type": "init",
+                    "protocolVersion": PROTOCOL_VERSION,
+                    "capabilities": {"delta": True, "entityLookup": True},
+                })
+
+            elif kind == "get_entities":
+                query = request.get("query", {})
+                tags = [tag.split(".")[0] for tag in request.get("tags", [])]
+                last_update = None
+                if request.get("lastUpdate"):
+                    last_update = parse_time(request["lastUpdate"])
+
+                count = 0
+                for item in load(settings):
+                    if matches(item, query, last_update):
+                        send_entity(item, tags)
+                        count += 1
+
+                log("returned %d entities" % count)
+                send({"type": "end"})
+
+            elif kind == "get_entity":
+                query = request.get("query", {})
+                tags = [tag.split(".")[0] for tag in request.get("tags", [])]
+
+                for item in load(settings):
+                    if str(item.get("id")) == request["id"] and matches(item, query, None):
+                        send_entity(item, tags)
+                        break
+                else:
+                    send({"type": "end"})
+
+            elif kind == "shutdown":
+                return
+
+            else:
+                send({"type": "error", "message": "unknown request type %s" % kind})
+
+        except Exception as e:
+            send({"type": "error", "message": str(e)})
+
+
+if __name__ == "__main__":
+    main()
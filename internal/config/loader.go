@@ -0,0 +1,336 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var envVarPattern = regexp.MustCompile(
+  `\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`,
+)
+
+// Tree is a configuration tree loaded by Load along with the file that each
+// mapping was loaded from.
+type Tree struct {
+  Values            map[string]interface{}
+  MappingSources    []string
+  // Files holds the main configuration file followed by all included files
+  Files             []string
+  // Dirs holds the mappings directory, if one is set
+  Dirs              []string
+}
+
+// IsSupportedFile returns true if the given configuration file can be loaded
+// by Load. Only YAML and JSON files are supported since other formats do not
+// preserve the case of keys.
+func IsSupportedFile(path string) bool {
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".yml", ".yaml", ".json":
+    return true
+  }
+
+  return false
+}
+
+// Load reads the configuration file at the given path and returns the merged
+// configuration tree. Environment variable references of the form ${NAME} and
+// ${NAME:-default} in string values are replaced; references to unset
+// variables without a default are left as is. Mappings are loaded from the
+// files listed in "include" and from the files in "mappingsDir" and appended
+// to the mappings of the main file. The "defaults" section of the main file,
+// followed by the "defaults" section of the file a mapping was loaded from,
+// is merged into each mapping.
+func Load(path string) (*Tree, error) {
+  values, err := readFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  tree := &Tree{ Values: values, Files: []string{ path } }
+  baseDir := filepath.Dir(path)
+  defaults, _ := values["defaults"].(map[string]interface{})
+
+  mappings, err := toMappings(values["mappings"], path)
+  if err != nil {
+    return nil, err
+  }
+
+  allMappings := []interface{}{}
+  tree.addMappings(&allMappings, mappings, defaults, nil, path)
+
+  files, dirs, err := includedFiles(values, baseDir)
+  if err != nil {
+    return nil, err
+  }
+
+  tree.Files = append(tree.Files, files...)
+  tree.Dirs = dirs
+
+  for _, file := range files {
+    if err := tree.include(&allMappings, file, defaults); err != nil {
+      return nil, err
+    }
+  }
+
+  values["mappings"] = allMappings
+
+  return tree, nil
+}
+
+// MappingSource returns the file that the mapping at the given index was
+// loaded from.
+func (t *Tree) MappingSource(index int) string {
+  if index < 0 || index >= len(t.MappingSources) {
+    return ""
+  }
+
+  return t.MappingSources[index]
+}
+
+func (t *Tree) include(
+  allMappings       *[]interface{},
+  file              string,
+  defaults          map[string]interface{},
+) error {
+  values, err := readFile(file)
+  if err != nil {
+    return err
+  }
+
+  for key := range values {
+    if key != "defaults" && key != "mappings" {
+      return fmt.Errorf(
+        "unknown key %q in included file %s; only defaults and mappings are supported",
+        key,
+        file,
+      )
+    }
+  }
+
+  fileDefaults, _ := values["defaults"].(map[string]interface{})
+
+  mappings, err := toMappings(values["mappings"], file)
+  if err != nil {
+    return err
+  }
+
+  t.addMappings(allMappings, mappings, defaults, fileDefaults, file)
+
+  return nil
+}
+
+func (t *Tree) addMappings(
+  allMappings       *[]interface{},
+  mappings          []interface{},
+  defaults          map[string]interface{},
+  fileDefaults      map[string]interface{},
+  source            string,
+) {
+  for _, mapping := range mappings {
+    if m, ok := mapping.(map[string]interface{}); ok {
+      mapping = mergeDefaults(mergeDefaults(m, fileDefaults), defaults)
+    }
+
+    *allMappings = append(*allMappings, mapping)
+    t.MappingSources = append(t.MappingSources, source)
+  }
+}
+
+func readFile(path string) (map[string]interface{}, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read config file: %v", err)
+  }
+
+  values := map[string]interface{}{}
+
+  if err := yaml.Unmarshal(data, &values); err != nil {
+    return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+  }
+
+  interpolated, _ := Interpolate(values).(map[string]interface{})
+
+  return interpolated, nil
+}
+
+func toMappings(value interface{}, file string) ([]interface{}, error) {
+  if value == nil {
+    return nil, nil
+  }
+
+  mappings, ok := value.([]interface{})
+  if !ok {
+    return nil, fmt.Errorf("mappings in %s must be a list", file)
+  }
+
+  return mappings, nil
+}
+
+// includedFiles returns the files listed in "include", which may contain glob
+// patterns, followed by the YAML and JSON files in "mappingsDir", along with
+// the mappings directory. Relative paths are resolved against the directory of
+// the main configuration file.
+func includedFiles(
+  values            map[string]interface{},
+  baseDir           string,
+) ([]string, []string, error) {
+  files := []string{}
+  dirs := []string{}
+
+  includes := []interface{}{}
+  switch u := values["include"].(type) {
+  case nil:
+  case string:
+    includes = append(includes, u)
+  case []interface{}:
+    includes = u
+  default:
+    return nil, nil, fmt.Errorf("include must be a list of file names")
+  }
+
+  for _, include := range includes {
+    pattern, ok := include.(string)
+    if !ok {
+      return nil, nil, fmt.Errorf("include must be a list of file names")
+    }
+
+    pattern = resolvePath(baseDir, pattern)
+
+    matches, err := filepath.Glob(pattern)
+    if err != nil {
+      return nil, nil, fmt.Errorf("invalid include pattern %s: %v", pattern, err)
+    }
+
+    if len(matches) == 0 {
+      return nil, nil, fmt.Errorf("no files found for include %s", pattern)
+    }
+
+    sort.Strings(matches)
+    files = append(files, matches...)
+  }
+
+  if dir, ok := values["mappingsDir"].(string); ok && dir != "" {
+    dir = resolvePath(baseDir, dir)
+    dirs = append(dirs, dir)
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+      return nil, nil, fmt.Errorf("failed to read mappings directory: %v", err)
+    }
+
+    for _, entry := range entries {
+      if entry.IsDir() || !IsSupportedFile(entry.Name()) {
+        continue
+      }
+
+      files = append(files, filepath.Join(dir, entry.Name()))
+    }
+  }
+
+  return files, dirs, nil
+}
+
+func resolvePath(baseDir, path string) string {
+  if filepath.IsAbs(path) {
+    return path
+  }
+
+  return filepath.Join(baseDir, path)
+}
+
+// Interpolate replaces environment variable references in all string values
+// in the given value.
+func Interpolate(value interface{}) interface{} {
+  switch u := value.(type) {
+  case string:
+    return interpolateString(u)
+
+  case map[string]interface{}:
+    for k, v := range u {
+      u[k] = Interpolate(v)
+    }
+    return u
+
+  case []interface{}:
+    for i, v := range u {
+      u[i] = Interpolate(v)
+    }
+    return u
+  }
+
+  return value
+}
+
+func interpolateString(s string) string {
+  return envVarPattern.ReplaceAllStringFunc(s, func(ref string) string {
+    groups := envVarPattern.FindStringSubmatch(ref)
+
+    if v, ok := os.LookupEnv(groups[1]); ok && (v != "" || groups[2] == "") {
+      return v
+    }
+
+    if groups[2] != "" {
+      return groups[3]
+    }
+
+    // Leave references to unset variables untouched so that placeholders
+    // used by providers are preserved
+    return ref
+  })
+}
+
+// mergeDefaults returns a copy of m with the keys in defaults that are not
+// set in m added. Nested objects are merged recursively; all other values in m,
+// including lists, take precedence over the defaults.
+func mergeDefaults(
+  m                 map[string]interface{},
+  defaults          map[string]interface{},
+) map[string]interface{} {
+  result := make(map[string]interface{}, len(m))
+  for k, v := range m {
+    result[k] = v
+  }
+
+  for k, dv := range defaults {
+    v, ok := result[k]
+    if !ok {
+      result[k] = deepCopy(dv)
+      continue
+    }
+
+    vm, vok := v.(map[string]interface{})
+    dm, dok := dv.(map[string]interface{})
+
+    if vok && dok {
+      result[k] = mergeDefaults(vm, dm)
+    }
+  }
+
+  return result
+}
+
+func deepCopy(value interface{}) interface{} {
+  switch u := value.(type) {
+  case map[string]interface{}:
+    result := make(map[string]interface{}, len(u))
+    for k, v := range u {
+      result[k] = deepCopy(v)
+    }
+    return result
+
+  case []interface{}:
+    result := make([]interface{}, len(u))
+    for i, v := range u {
+      result[i] = deepCopy(v)
+    }
+    return result
+  }
+
+  return value
+}
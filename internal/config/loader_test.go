@@ -0,0 +1,94 @@
+package config
+
+import (
+  "os"
+  "reflect"
+  "testing"
+)
+
+func TestInterpolateString(t *testing.T) {
+  t.Setenv("TAG_SYNC_TEST_SET", "value")
+  t.Setenv("TAG_SYNC_TEST_EMPTY", "")
+  os.Unsetenv("TAG_SYNC_TEST_UNSET")
+
+  tests := []struct {
+    s               string
+    want            string
+  }{
+    { "${TAG_SYNC_TEST_SET}", "value" },
+    { "${TAG_SYNC_TEST_SET:-default}", "value" },
+    { "${TAG_SYNC_TEST_SET:-}", "value" },
+    { "${TAG_SYNC_TEST_EMPTY}", "" },
+    { "${TAG_SYNC_TEST_EMPTY:-default}", "default" },
+    { "${TAG_SYNC_TEST_EMPTY:-}", "" },
+    { "${TAG_SYNC_TEST_UNSET}", "${TAG_SYNC_TEST_UNSET}" },
+    { "${TAG_SYNC_TEST_UNSET:-default}", "default" },
+    { "${TAG_SYNC_TEST_UNSET:-}", "" },
+    { "${TAG_SYNC_TEST_UNSET:-a b:c}", "a b:c" },
+    { "a-${TAG_SYNC_TEST_SET}-${TAG_SYNC_TEST_UNSET:-b}-c", "a-value-b-c" },
+    { "$TAG_SYNC_TEST_SET", "$TAG_SYNC_TEST_SET" },
+    { "${lastUpdateDate}", "${lastUpdateDate}" },
+    { "${1INVALID:-default}", "${1INVALID:-default}" },
+  }
+
+  for _, test := range tests {
+    if got := interpolateString(test.s); got != test.want {
+      t.Errorf("interpolateString(%q) = %q; want %q", test.s, got, test.want)
+    }
+  }
+}
+
+func TestMergeDefaults(t *testing.T) {
+  t.Setenv("TAG_SYNC_TEST_EMPTY", "")
+  os.Unsetenv("TAG_SYNC_TEST_UNSET")
+
+  defaults := Interpolate(map[string]interface{}{
+    "profile": "${TAG_SYNC_TEST_UNSET:-}",
+    "schedule": "${TAG_SYNC_TEST_EMPTY:-@hourly}",
+    "tags": []interface{}{ "owner" },
+    "extEntityQuery": map[string]interface{}{
+      "type": "cmdb_ci_server",
+      "query": "${TAG_SYNC_TEST_EMPTY:-}",
+    },
+  }).(map[string]interface{})
+
+  mapping := map[string]interface{}{
+    "profile": "",
+    "tags": []interface{}{ "team" },
+    "extEntityQuery": map[string]interface{}{
+      "query": "active=true",
+    },
+  }
+
+  want := map[string]interface{}{
+    "profile": "",
+    "schedule": "@hourly",
+    "tags": []interface{}{ "team" },
+    "extEntityQuery": map[string]interface{}{
+      "type": "cmdb_ci_server",
+      "query": "active=true",
+    },
+  }
+
+  got := mergeDefaults(mapping, defaults)
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("mergeDefaults() = %v; want %v", got, want)
+  }
+
+  // The defaults are copied so that changes to one mapping do not affect
+  // the others
+  got["extEntityQuery"].(map[string]interface{})["type"] = "changed"
+  if defaults["extEntityQuery"].(map[string]interface{})["type"] != "cmdb_ci_server" {
+    t.Errorf("mergeDefaults() did not copy the defaults")
+  }
+
+  if _, ok := mapping["schedule"]; ok {
+    t.Errorf("mergeDefaults() modified the mapping")
+  }
+
+  // A default that interpolates to an empty string is still set
+  got = mergeDefaults(map[string]interface{}{}, defaults)
+  if v, ok := got["profile"]; !ok || v != "" {
+    t.Errorf("mergeDefaults() profile = %v, %v; want \"\", true", v, ok)
+  }
+}
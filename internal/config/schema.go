@@ -0,0 +1,464 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+  KIND_ANY          Kind = iota
+  KIND_STRING
+  KIND_INT
+  KIND_BOOL
+  KIND_DURATION
+  KIND_LIST
+  KIND_MAP
+  KIND_OBJECT
+)
+
+// Node describes the expected shape of a value in the configuration tree.
+type Node struct {
+  Kind              Kind
+  Required          bool
+  Enum              []string
+  // Fields holds the known keys of an object, keyed by their canonical name
+  Fields            map[string]*Node
+  // AllowUnknown allows keys in an object that are not listed in Fields
+  AllowUnknown      bool
+  // Elem describes the elements of a list or the values of a map
+  Elem              *Node
+  // Check is an optional function that performs additional validation
+  Check             func(value interface{}) error
+}
+
+// Problem describes a single validation problem at a key path.
+type Problem struct {
+  Path              string          `json:"path"`
+  Message           string          `json:"message"`
+}
+
+// ValidationError is returned when the configuration tree has one or more
+// problems.
+type ValidationError struct {
+  Problems          []Problem
+}
+
+func (e *ValidationError) Error() string {
+  messages := make([]string, len(e.Problems))
+
+  for i, p := range e.Problems {
+    messages[i] = fmt.Sprintf("%s: %s", p.Path, p.Message)
+  }
+
+  return fmt.Sprintf(
+    "invalid configuration: %d problems found: %s",
+    len(e.Problems),
+    strings.Join(messages, "; "),
+  )
+}
+
+func Any() *Node {
+  return &Node{ Kind: KIND_ANY }
+}
+
+func String() *Node {
+  return &Node{ Kind: KIND_STRING }
+}
+
+func Int() *Node {
+  return &Node{ Kind: KIND_INT }
+}
+
+func Bool() *Node {
+  return &Node{ Kind: KIND_BOOL }
+}
+
+func Duration() *Node {
+  return &Node{ Kind: KIND_DURATION }
+}
+
+func ListOf(elem *Node) *Node {
+  return &Node{ Kind: KIND_LIST, Elem: elem }
+}
+
+func MapOf(elem *Node) *Node {
+  return &Node{ Kind: KIND_MAP, Elem: elem }
+}
+
+func Object(fields map[string]*Node) *Node {
+  return &Node{ Kind: KIND_OBJECT, Fields: fields }
+}
+
+// Require marks the node as required in its parent object.
+func (n *Node) Require() *Node {
+  n.Required = true
+  return n
+}
+
+// OneOf restricts the value of a string node to the given values.
+func (n *Node) OneOf(values ...string) *Node {
+  n.Enum = values
+  return n
+}
+
+// Open allows keys in an object node that are not listed in its fields.
+func (n *Node) Open() *Node {
+  n.AllowUnknown = true
+  return n
+}
+
+// WithCheck adds a function that performs additional validation of the value.
+func (n *Node) WithCheck(check func(value interface{}) error) *Node {
+  n.Check = check
+  return n
+}
+
+// Validate validates the given value against the node and returns all
+// problems found. Keys are matched against the canonical field names exactly;
+// keys that only differ in case are reported.
+func Validate(node *Node, value interface{}, path string) []Problem {
+  v := &validator{}
+  v.validate(node, value, path)
+  return v.problems
+}
+
+type validator struct {
+  problems          []Problem
+}
+
+func (v *validator) addf(path string, format string, args ...interface{}) {
+  v.problems = append(v.problems, Problem{ path, fmt.Sprintf(format, args...) })
+}
+
+func (v *validator) validate(node *Node, value interface{}, path string) {
+  if node == nil || value == nil {
+    return
+  }
+
+  switch node.Kind {
+  case KIND_STRING:
+    s, ok := toScalarString(value)
+    if !ok {
+      v.addf(path, "expected a string but found %s", describe(value))
+      return
+    }
+
+    if len(node.Enum) > 0 && !contains(node.Enum, s) {
+      v.addf(
+        path,
+        "invalid value %q; must be one of %s",
+        s,
+        strings.Join(node.Enum, ", "),
+      )
+      return
+    }
+
+  case KIND_INT:
+    if !isInt(value) {
+      v.addf(path, "expected an integer but found %s", describe(value))
+      return
+    }
+
+  case KIND_BOOL:
+    if !isBool(value) {
+      v.addf(path, "expected a boolean but found %s", describe(value))
+      return
+    }
+
+  case KIND_DURATION:
+    if !isDuration(value) {
+      v.addf(path, "expected a duration (e.g. 30s, 5m) but found %s", describe(value))
+      return
+    }
+
+  case KIND_LIST:
+    list, ok := value.([]interface{})
+    if !ok {
+      // Single scalar values are accepted for lists of scalars
+      if isScalarKind(node.Elem) && isScalar(value) {
+        v.validate(node.Elem, value, path)
+        return
+      }
+
+      v.addf(path, "expected a list but found %s", describe(value))
+      return
+    }
+
+    for i, elem := range list {
+      v.validate(node.Elem, elem, fmt.Sprintf("%s[%d]", path, i))
+    }
+
+  case KIND_MAP:
+    m, ok := toMap(value)
+    if !ok {
+      v.addf(path, "expected a map but found %s", describe(value))
+      return
+    }
+
+    for _, key := range sortedKeys(m) {
+      v.validate(node.Elem, m[key], joinPath(path, key))
+    }
+
+  case KIND_OBJECT:
+    m, ok := toMap(value)
+    if !ok {
+      v.addf(path, "expected an object but found %s", describe(value))
+      return
+    }
+
+    v.validateObject(node, m, path)
+  }
+
+  if node.Check != nil {
+    if err := node.Check(value); err != nil {
+      v.addf(path, "%s", err)
+    }
+  }
+}
+
+func (v *validator) validateObject(
+  node              *Node,
+  m                 map[string]interface{},
+  path              string,
+) {
+  seen := map[string]bool{}
+
+  for _, key := range sortedKeys(m) {
+    keyPath := joinPath(path, key)
+
+    if field, ok := node.Fields[key]; ok {
+      seen[key] = true
+      v.validate(field, m[key], keyPath)
+      continue
+    }
+
+    if canonical, ok := findFold(node.Fields, key); ok {
+      seen[canonical] = true
+      v.addf(keyPath, "unknown key %q; did you mean %q?", key, canonical)
+      v.validate(node.Fields[canonical], m[key], keyPath)
+      continue
+    }
+
+    if node.AllowUnknown {
+      continue
+    }
+
+    if suggestion, ok := suggest(node.Fields, key); ok {
+      v.addf(keyPath, "unknown key %q; did you mean %q?", key, suggestion)
+      continue
+    }
+
+    v.addf(keyPath, "unknown key %q", key)
+  }
+
+  for _, name := range sortedNodeKeys(node.Fields) {
+    if node.Fields[name].Required && !seen[name] {
+      v.addf(joinPath(path, name), "missing required key %q", name)
+    }
+  }
+}
+
+func joinPath(path, key string) string {
+  if path == "" {
+    return key
+  }
+
+  return path + "." + key
+}
+
+func describe(value interface{}) string {
+  switch value.(type) {
+  case []interface{}:
+    return "a list"
+
+  case map[string]interface{}, map[interface{}]interface{}:
+    return "an object"
+  }
+
+  return fmt.Sprintf("%q", fmt.Sprint(value))
+}
+
+func toMap(value interface{}) (map[string]interface{}, bool) {
+  switch m := value.(type) {
+  case map[string]interface{}:
+    return m, true
+
+  case map[interface{}]interface{}:
+    result := make(map[string]interface{}, len(m))
+    for k, v := range m {
+      result[fmt.Sprint(k)] = v
+    }
+    return result, true
+  }
+
+  return nil, false
+}
+
+func isScalar(value interface{}) bool {
+  _, ok := toScalarString(value)
+  return ok
+}
+
+func isScalarKind(node *Node) bool {
+  if node == nil {
+    return false
+  }
+
+  switch node.Kind {
+  case KIND_STRING, KIND_INT, KIND_BOOL, KIND_DURATION:
+    return true
+  }
+
+  return false
+}
+
+func toScalarString(value interface{}) (string, bool) {
+  switch u := value.(type) {
+  case string:
+    return u, true
+
+  case int, int64, uint64, float64, bool:
+    return fmt.Sprint(u), true
+  }
+
+  return "", false
+}
+
+func isInt(value interface{}) bool {
+  switch u := value.(type) {
+  case int, int64, uint64:
+    return true
+
+  case string:
+    _, err := strconv.Atoi(u)
+    return err == nil
+  }
+
+  return false
+}
+
+func isBool(value interface{}) bool {
+  switch u := value.(type) {
+  case bool:
+    return true
+
+  case string:
+    _, err := strconv.ParseBool(u)
+    return err == nil
+  }
+
+  return false
+}
+
+func isDuration(value interface{}) bool {
+  switch u := value.(type) {
+  case int, int64, uint64:
+    return true
+
+  case string:
+    _, err := time.ParseDuration(u)
+    return err == nil
+  }
+
+  return false
+}
+
+func contains(values []string, s string) bool {
+  for _, v := range values {
+    if v == s {
+      return true
+    }
+  }
+
+  return false
+}
+
+func findFold(fields map[string]*Node, key string) (string, bool) {
+  for name := range fields {
+    if strings.EqualFold(name, key) {
+      return name, true
+    }
+  }
+
+  return "", false
+}
+
+// suggest returns the field name closest to key if it is within a small edit
+// distance.
+func suggest(fields map[string]*Node, key string) (string, bool) {
+  best := ""
+  bestDistance := 3
+
+  for _, name := range sortedNodeKeys(fields) {
+    d := editDistance(strings.ToLower(name), strings.ToLower(key))
+    if d < bestDistance {
+      best = name
+      bestDistance = d
+    }
+  }
+
+  return best, best != ""
+}
+
+func editDistance(a, b string) int {
+  prev := make([]int, len(b) + 1)
+  curr := make([]int, len(b) + 1)
+
+  for j := range prev {
+    prev[j] = j
+  }
+
+  for i := 1; i <= len(a); i++ {
+    curr[0] = i
+
+    for j := 1; j <= len(b); j++ {
+      cost := 1
+      if a[i - 1] == b[j - 1] {
+        cost = 0
+      }
+
+      curr[j] = minInt(prev[j] + 1, curr[j - 1] + 1, prev[j - 1] + cost)
+    }
+
+    prev, curr = curr, prev
+  }
+
+  return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+  m := values[0]
+  for _, v := range values[1:] {
+    if v < m {
+      m = v
+    }
+  }
+  return m
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+  keys := make([]string, 0, len(m))
+  for k := range m {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  return keys
+}
+
+func sortedNodeKeys(m map[string]*Node) []string {
+  keys := make([]string, 0, len(m))
+  for k := range m {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  return keys
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "entity_tag_sync"
+
+var (
+  registry = prometheus.NewRegistry()
+
+  RunsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+      Namespace: namespace,
+      Name: "runs_total",
+      Help: "Total number of sync runs by result.",
+    },
+    []string{"result"},
+  )
+
+  RunDuration = prometheus.NewHistogram(
+    prometheus.HistogramOpts{
+      Namespace: namespace,
+      Name: "run_duration_seconds",
+      Help: "Duration of sync runs in seconds.",
+      Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+    },
+  )
+
+  MappingEntitiesTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+      Namespace: namespace,
+      Name: "mapping_entities_total",
+      Help: "Total number of New Relic entities processed per mapping by outcome.",
+    },
+    []string{"mapping", "outcome"},
+  )
+
+  MappingDuration = prometheus.NewHistogramVec(
+    prometheus.HistogramOpts{
+      Namespace: namespace,
+      Name: "mapping_duration_seconds",
+      Help: "Duration of mapping processing in seconds.",
+      Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+    },
+    []string{"mapping"},
+  )
+
+  ProviderRequestDuration = prometheus.NewHistogramVec(
+    prometheus.HistogramOpts{
+      Namespace: namespace,
+      Name: "provider_request_duration_seconds",
+      Help: "Latency of requests made by providers to external systems in seconds.",
+      Buckets: prometheus.DefBuckets,
+    },
+    []string{"provider"},
+  )
+
+  ProviderPagesTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+      Namespace: namespace,
+      Name: "provider_pages_total",
+      Help: "Total number of pages of external entities fetched from the provider per mapping.",
+    },
+    []string{"provider", "mapping"},
+  )
+
+  NerdGraphErrorsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+      Namespace: namespace,
+      Name: "nerdgraph_errors_total",
+      Help: "Total number of NerdGraph errors by operation type.",
+    },
+    []string{"type"},
+  )
+
+  LastSuccessTimestamp = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+      Namespace: namespace,
+      Name: "last_success_timestamp_seconds",
+      Help: "Unix timestamp of the last successful sync per mapping.",
+    },
+    []string{"mapping"},
+  )
+)
+
+func init() {
+  registry.MustRegister(
+    collectors.NewGoCollector(),
+    collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+    RunsTotal,
+    RunDuration,
+    MappingEntitiesTotal,
+    MappingDuration,
+    ProviderRequestDuration,
+    ProviderPagesTotal,
+    NerdGraphErrorsTotal,
+    LastSuccessTimestamp,
+  )
+}
+
+// Handler returns an http.Handler that serves all metrics in the Prometheus
+// text or OpenMetrics exposition format.
+func Handler() http.Handler {
+  return promhttp.HandlerFor(
+    registry,
+    promhttp.HandlerOpts{ EnableOpenMetrics: true },
+  )
+}
+
+// MappingLabel returns the value of the mapping label for a mapping, which is
+// the mapping name if one is set or the mapping index otherwise.
+func MappingLabel(index int, name string) string {
+  if name != "" {
+    return name
+  }
+
+  return strconv.Itoa(index)
+}
@@ -0,0 +1,319 @@
+// Package nerdgraph holds the NerdGraph queries shared by the synchronization
+// engine and the providers that read New Relic entities.
+package nerdgraph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nrClient "github.com/newrelic/newrelic-client-go/newrelic"
+	"github.com/newrelic/newrelic-client-go/pkg/common"
+	"github.com/newrelic/newrelic-client-go/pkg/entities"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+)
+
+type Tag struct {
+  Key               string          `json:"key"`
+  Values            []string        `json:"values"`
+}
+
+type EntityQuery struct {
+  Type              []string
+  Domain            []string
+  Name              string
+  AccountId         int
+  Tags              []Tag
+  Query             string
+  Profile           string
+}
+
+type EntityOutline struct {
+  Guid              common.EntityGUID
+  Name              string
+  AccountID         int
+  EntityType        entities.EntityType
+  Domain            string
+  Type              string
+  Tags              []entities.EntityTag
+}
+
+const (
+  entitySearchResultQuery = `
+    entities {` + entityOutlineFields + `}
+    nextCursor
+  `
+  entityOutlineFields = `
+      guid
+      name
+      accountId
+      entityType
+      domain
+      type
+      tags {
+        key
+        values
+      }
+  `
+  getEntitySearchByQuery = `query(
+    $query: String,
+  ) { actor { entitySearch(
+    query: $query,
+  ) {
+    count
+    results {` + entitySearchResultQuery + "} } } }"
+  getEntitySearchByQueryWithCursor = `query(
+    $query: String,
+    $cursor: String,
+  ) { actor { entitySearch(
+    query: $query,
+  ) {
+    count
+    results(
+      cursor: $cursor,
+    ) {` + entitySearchResultQuery + "} } } }"
+  getRelatedEntitiesByGuid = `query(
+    $guid: EntityGuid!,
+    $filter: EntityRelationshipEdgeFilter,
+    $cursor: String,
+  ) { actor { entity(
+    guid: $guid,
+  ) { relatedEntities(
+    filter: $filter,
+    cursor: $cursor,
+  ) {
+    results {
+      type
+      source {
+        guid
+        entity {` + entityOutlineFields + `}
+      }
+      target {
+        guid
+        entity {` + entityOutlineFields + `}
+      }
+    }
+    nextCursor
+  } } } }`
+  getEntitiesByGuids = `query(
+    $guids: [EntityGuid]!,
+  ) { actor { entities(
+    guids: $guids,
+  ) {` + entityOutlineFields + "} } }"
+)
+
+type EntitySearchResponse struct {
+  Actor struct {
+    EntitySearch struct {
+      Count int
+      Results struct {
+        Entities []EntityOutline
+        NextCursor string
+      }
+    }
+  }
+}
+
+// RelationshipVertex is the source or target of a relationship. The entity is
+// nil if it is not visible to the user of the API key.
+type RelationshipVertex struct {
+  Guid              common.EntityGUID
+  Entity            *EntityOutline
+}
+
+type Relationship struct {
+  Type              string
+  Source            RelationshipVertex
+  Target            RelationshipVertex
+}
+
+type RelatedEntities struct {
+  Results           []Relationship
+  NextCursor        string
+}
+
+type relatedEntitiesResponse struct {
+  Actor struct {
+    Entity *struct {
+      RelatedEntities RelatedEntities
+    }
+  }
+}
+
+type entitiesResponse struct {
+  Actor struct {
+    Entities []EntityOutline
+  }
+}
+
+// BuildQuery returns the entity search query for the given entity query
+// criteria.
+func BuildQuery(entityQuery *EntityQuery) string {
+  if entityQuery.Query != "" {
+    return entityQuery.Query
+  }
+
+  var parts []string
+
+  if len(entityQuery.Domain) > 0 {
+    parts = append(
+      parts,
+      fmt.Sprintf("domain IN ('%s')", strings.Join(entityQuery.Domain, "','")),
+    )
+  }
+
+  if len(entityQuery.Type) > 0 {
+    parts = append(
+      parts,
+      fmt.Sprintf("type IN ('%s')", strings.Join(entityQuery.Type, "','")),
+    )
+  }
+
+  if entityQuery.Name != "" {
+    parts = append(
+      parts,
+      fmt.Sprintf("name LIKE '%s'", entityQuery.Name),
+    )
+  }
+
+  if entityQuery.AccountId != 0 {
+    parts = append(
+      parts,
+      fmt.Sprintf("tags.`accountId` = %d", entityQuery.AccountId),
+    )
+  }
+
+  if len(entityQuery.Tags) > 0 {
+    var tags []string
+
+    for _, tag := range entityQuery.Tags {
+      tags = append(
+        tags,
+        fmt.Sprintf(
+          "tags.`%s` IN ('%s')",
+          tag.Key,
+          strings.Join(tag.Values, "','"),
+        ),
+      )
+    }
+
+    parts = append(
+      parts,
+      strings.Join(tags, " AND "),
+    )
+  }
+
+  return strings.Join(parts, " AND ")
+}
+
+// SearchEntities returns one page of the entities matching the given entity
+// search query. The first page is returned when the cursor is empty.
+func SearchEntities(
+  ctx               context.Context,
+  i                 *interop.Interop,
+  client            *nrClient.NewRelic,
+  query             string,
+  cursor            string,
+) (*EntitySearchResponse, error) {
+  var resp EntitySearchResponse
+
+  vars := map[string]interface{}{
+    "query":   query,
+  }
+
+  if cursor != "" {
+    vars["cursor"] = cursor
+
+    i.Logger.Tracef("running query using cursor: %s", cursor)
+
+    if err := client.NerdGraph.QueryWithResponseAndContext(
+      ctx,
+      getEntitySearchByQueryWithCursor,
+      vars,
+      &resp,
+    ); err != nil {
+      return nil, err
+    }
+
+    return &resp, nil
+  }
+
+  if err := client.NerdGraph.QueryWithResponseAndContext(
+    ctx,
+    getEntitySearchByQuery,
+    vars,
+    &resp,
+  ); err != nil {
+    return nil, err
+  }
+
+  return &resp, nil
+}
+
+// GetEntities returns the entities with the given GUIDs. GUIDs of entities that
+// do not exist are ignored.
+func GetEntities(
+  ctx               context.Context,
+  client            *nrClient.NewRelic,
+  guids             []string,
+) ([]EntityOutline, error) {
+  var resp entitiesResponse
+
+  vars := map[string]interface{}{
+    "guids":   guids,
+  }
+
+  if err := client.NerdGraph.QueryWithResponseAndContext(
+    ctx,
+    getEntitiesByGuids,
+    vars,
+    &resp,
+  ); err != nil {
+    return nil, err
+  }
+
+  return resp.Actor.Entities, nil
+}
+
+// GetRelatedEntities returns one page of the relationships of the given type
+// and direction (INBOUND, OUTBOUND or BOTH) of the entity with the given GUID.
+// The first page is returned when the cursor is empty.
+func GetRelatedEntities(
+  ctx               context.Context,
+  client            *nrClient.NewRelic,
+  guid              string,
+  relationshipType  string,
+  direction         string,
+  cursor            string,
+) (*RelatedEntities, error) {
+  var resp relatedEntitiesResponse
+
+  vars := map[string]interface{}{
+    "guid":    guid,
+    "filter":  map[string]interface{}{
+      "direction": direction,
+      "relationshipTypes": map[string]interface{}{
+        "include": []string{ relationshipType },
+      },
+    },
+  }
+
+  if cursor != "" {
+    vars["cursor"] = cursor
+  }
+
+  if err := client.NerdGraph.QueryWithResponseAndContext(
+    ctx,
+    getRelatedEntitiesByGuid,
+    vars,
+    &resp,
+  ); err != nil {
+    return nil, err
+  }
+
+  if resp.Actor.Entity == nil {
+    return &RelatedEntities{}, nil
+  }
+
+  return &resp.Actor.Entity.RelatedEntities, nil
+}
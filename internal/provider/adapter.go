@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"io"
+)
+
+// v1Provider adapts a Provider to ProviderV2. The entities returned by the
+// provider are returned as a single page.
+type v1Provider struct {
+  provider          Provider
+}
+
+type v1Iterator struct {
+  entities          []Entity
+  pageCount         int
+  done              bool
+}
+
+// FromV1 adapts a provider implementing the original Provider interface to
+// ProviderV2. The adapted provider reports the Delta capability because the
+// last update time is passed to the provider, and the EntityLookup capability
+// if the provider implements EntityGetter. If the provider implements
+// io.Closer, it is closed when the adapted provider is closed.
+func FromV1(p Provider) ProviderV2 {
+  return &v1Provider{ p }
+}
+
+func (p *v1Provider) Capabilities() Capabilities {
+  _, entityLookup := p.provider.(EntityGetter)
+  _, writeBack := p.provider.(EntityWriter)
+
+  return Capabilities{
+    Delta: true,
+    WriteBack: writeBack,
+    EntityLookup: entityLookup,
+  }
+}
+
+// ValidateQuery accepts all queries. The queries of v1 providers are only
+// checked against the registered Schema.
+func (p *v1Provider) ValidateQuery(config map[string]interface{}) error {
+  return nil
+}
+
+func (p *v1Provider) Entities(
+  ctx               context.Context,
+  query             *Query,
+) (EntityIterator, error) {
+  var (
+    entities        []Entity
+    err             error
+  )
+
+  if cp, ok := p.provider.(ContextProvider); ok {
+    entities, err = cp.GetEntitiesWithContext(
+      ctx,
+      query.Config,
+      query.Tags,
+      query.LastUpdate,
+    )
+  } else {
+    entities, err = p.provider.GetEntities(
+      query.Config,
+      query.Tags,
+      query.LastUpdate,
+    )
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  pageCount := 1
+  if pc, ok := p.provider.(PageCounter); ok {
+    pageCount = pc.PageCount()
+  }
+
+  return &v1Iterator{ entities: entities, pageCount: pageCount }, nil
+}
+
+func (p *v1Provider) GetEntity(
+  ctx               context.Context,
+  config            map[string]interface{},
+  tags              []string,
+  id                string,
+) (*Entity, error) {
+  return p.provider.(EntityGetter).GetEntity(ctx, config, tags, id)
+}
+
+func (p *v1Provider) WriteTags(
+  ctx               context.Context,
+  config            map[string]interface{},
+  id                string,
+  tags              map[string]interface{},
+) error {
+  return p.provider.(EntityWriter).WriteTags(ctx, config, id, tags)
+}
+
+func (p *v1Provider) Close() error {
+  if closer, ok := p.provider.(io.Closer); ok {
+    return closer.Close()
+  }
+
+  return nil
+}
+
+func (it *v1Iterator) Next(ctx context.Context) ([]Entity, error) {
+  if it.done {
+    return nil, io.EOF
+  }
+
+  it.done = true
+  entities := it.entities
+  it.entities = nil
+
+  return entities, nil
+}
+
+func (it *v1Iterator) Close() error {
+  it.done = true
+  it.entities = nil
+
+  return nil
+}
+
+func (it *v1Iterator) PageCount() int {
+  return it.pageCount
+}
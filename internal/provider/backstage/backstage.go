@@ -0,0 +1,288 @@
+// Package backstage implements a provider that reads external entities from a
+// Backstage software catalog, either through the catalog API or from a
+// directory tree of catalog-info.yaml files.
+package backstage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	DEFAULT_NAMESPACE = "default"
+	DEFAULT_FILE_NAME = "catalog-info.yaml"
+	DEFAULT_PAGE_SIZE = 500
+)
+
+type BackstageProvider struct {
+	Interop      *interop.Interop
+	BaseURL      string
+	BaseDir      string
+	ClientConfig *httpclient.Config
+	PageSize     int
+}
+
+// catalogQuery is the parsed extEntityQuery of a mapping.
+type catalogQuery struct {
+	kinds    []string
+	types    []string
+	path     string
+	fileName string
+}
+
+func init() {
+	provider.RegisterProviderV2("backstage", New)
+	provider.RegisterSchema("backstage", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"baseUrl":  config.String(),
+			"token":    config.String(),
+			"baseDir":  config.String(),
+			"pageSize": config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"kind":     config.ListOf(config.String()),
+			"type":     config.ListOf(config.String()),
+			"path":     config.String(),
+			"fileName": config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	clientConfig := &httpclient.Config{
+		AuthType: httpclient.AUTH_TYPE_NONE,
+	}
+
+	if token := v.GetString("token"); token != "" {
+		clientConfig.AuthType = httpclient.AUTH_TYPE_BEARER
+		clientConfig.BearerToken = token
+	}
+
+	baseDir := v.GetString("baseDir")
+	if baseDir == "" {
+		// Relative paths are resolved against the directory of the
+		// configuration file, as with the file provider
+		if configFile := i.ConfigFileUsed(); configFile != "" {
+			baseDir = filepath.Dir(configFile)
+		} else {
+			baseDir = "."
+		}
+	}
+
+	pageSize := v.GetInt("pageSize")
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+
+	return &BackstageProvider{
+		Interop:      i,
+		BaseURL:      strings.TrimRight(v.GetString("baseUrl"), "/"),
+		BaseDir:      baseDir,
+		ClientConfig: clientConfig,
+		PageSize:     pageSize,
+	}, nil
+}
+
+func (bp *BackstageProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		EntityLookup: true,
+	}
+}
+
+func (bp *BackstageProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := bp.parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator over the catalog entities of the given kinds
+// and types. Catalog files are read when the query has a path and the catalog
+// API is used otherwise.
+func (bp *BackstageProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	cq, err := bp.parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cq.path != "" {
+		return bp.fileEntities(cq, query.Tags)
+	}
+
+	return bp.apiEntities(ctx, cq, query.Tags)
+}
+
+// GetEntity returns the catalog entity with the given entity reference, for
+// example component:default/billing.
+func (bp *BackstageProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	cq, err := bp.parseQuery(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cq.path != "" {
+		return bp.getFileEntity(ctx, cq, tags, id)
+	}
+
+	return bp.getApiEntity(ctx, cq, tags, id)
+}
+
+func (bp *BackstageProvider) Close() error {
+	return nil
+}
+
+func (bp *BackstageProvider) parseQuery(config map[string]interface{}) (*catalogQuery, error) {
+	cq := &catalogQuery{
+		kinds:    cast.ToStringSlice(config["kind"]),
+		types:    cast.ToStringSlice(config["type"]),
+		path:     cast.ToString(config["path"]),
+		fileName: cast.ToString(config["filename"]),
+	}
+
+	if cq.path == "" && bp.BaseURL == "" {
+		return nil, fmt.Errorf("missing backstage baseUrl or catalog path")
+	}
+
+	if cq.fileName == "" {
+		cq.fileName = DEFAULT_FILE_NAME
+	}
+
+	if _, err := path.Match(cq.fileName, ""); err != nil {
+		return nil, fmt.Errorf("invalid catalog file name %s: %v", cq.fileName, err)
+	}
+
+	return cq, nil
+}
+
+// matches returns true if the catalog entity has one of the kinds and one of
+// the types of the query. As in the catalog API, kinds and types are not case
+// sensitive.
+func (cq *catalogQuery) matches(entity map[string]interface{}) bool {
+	spec, _ := entity["spec"].(map[string]interface{})
+
+	return containsFold(cq.kinds, cast.ToString(entity["kind"])) &&
+		containsFold(cq.types, cast.ToString(spec["type"]))
+}
+
+func containsFold(values []string, s string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// entityRef returns the entity reference of a catalog entity, such as
+// component:default/billing. References are compared case insensitively by
+// Backstage, so they are returned in lower case.
+func entityRef(kind, namespace, name string) string {
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+
+	return strings.ToLower(fmt.Sprintf("%s:%s/%s", kind, namespace, name))
+}
+
+// parseEntityRef returns the kind, namespace and name of an entity reference.
+func parseEntityRef(ref string) (string, string, string, error) {
+	kind, rest, ok := strings.Cut(ref, ":")
+	if !ok || kind == "" {
+		return "", "", "", fmt.Errorf("invalid entity reference %s; missing kind", ref)
+	}
+
+	namespace, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		namespace, name = DEFAULT_NAMESPACE, rest
+	}
+
+	if name == "" {
+		return "", "", "", fmt.Errorf("invalid entity reference %s; missing name", ref)
+	}
+
+	return kind, namespace, name, nil
+}
+
+// toEntity flattens a catalog entity into an external entity, or returns nil if
+// it has no kind or name. The ID of the external entity is the entity
+// reference. Annotations and labels are nested under the
+// annotations and labels keys. Annotation and label keys with dots, such as
+// backstage.io/techdocs-ref, are nested further so that they can be referenced
+// as annotations.backstage.io/techdocs-ref.
+func (bp *BackstageProvider) toEntity(
+	entity map[string]interface{},
+	tags []string,
+) *provider.Entity {
+	metadata, _ := entity["metadata"].(map[string]interface{})
+	spec, _ := entity["spec"].(map[string]interface{})
+
+	kind := cast.ToString(entity["kind"])
+	name := cast.ToString(metadata["name"])
+	if kind == "" || name == "" {
+		bp.Interop.Logger.Warn("skipping catalog entity with no kind or name")
+		return nil
+	}
+
+	namespace := cast.ToString(metadata["namespace"])
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+
+	ref := entityRef(kind, namespace, name)
+
+	values := map[string]interface{}{
+		"ref":         ref,
+		"kind":        kind,
+		"name":        name,
+		"namespace":   namespace,
+		"title":       cast.ToString(metadata["title"]),
+		"description": cast.ToString(metadata["description"]),
+		"type":        cast.ToString(spec["type"]),
+		"owner":       cast.ToString(spec["owner"]),
+		"lifecycle":   cast.ToString(spec["lifecycle"]),
+		"system":      cast.ToString(spec["system"]),
+		"tags":        strings.Join(cast.ToStringSlice(metadata["tags"]), ","),
+		"annotations": provider.NestKeys(cast.ToStringMap(metadata["annotations"])),
+		"labels":      provider.NestKeys(cast.ToStringMap(metadata["labels"])),
+	}
+
+	// Fields the entity does not have are left out rather than set to empty
+	// strings so that the tags they are mapped to are removed
+	for key, value := range values {
+		if value == "" {
+			delete(values, key)
+		}
+	}
+
+	return &provider.Entity{
+		ID:   ref,
+		Tags: provider.StringifyValues(provider.SelectFields(values, tags)),
+	}
+}
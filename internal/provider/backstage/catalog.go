@@ -0,0 +1,233 @@
+package backstage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
+)
+
+// queryResponse is a page of entities returned by the by-query endpoint of the
+// catalog API.
+type queryResponse struct {
+	Items    []map[string]interface{} `json:"items"`
+	PageInfo struct {
+		NextCursor string `json:"nextCursor"`
+	} `json:"pageInfo"`
+}
+
+func (bp *BackstageProvider) apiEntities(
+	ctx context.Context,
+	cq *catalogQuery,
+	tags []string,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	client, err := httpclient.New(ctx, bp.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiIterator{
+		bp:     bp,
+		query:  cq,
+		client: client,
+		tags:   tags,
+	}, nil
+}
+
+func (bp *BackstageProvider) getApiEntity(
+	ctx context.Context,
+	cq *catalogQuery,
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	kind, namespace, name, err := parseEntityRef(id)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := httpclient.New(ctx, bp.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	entityUrl := fmt.Sprintf(
+		"%s/api/catalog/entities/by-name/%s/%s/%s",
+		bp.BaseURL,
+		url.PathEscape(kind),
+		url.PathEscape(namespace),
+		url.PathEscape(name),
+	)
+
+	var entity map[string]interface{}
+
+	resp, err := bp.get(ctx, client, entityUrl, &entity)
+	if resp != nil && resp.StatusCode == nethttp.StatusNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !cq.matches(entity) {
+		return nil, nil
+	}
+
+	return bp.toEntity(entity, tags), nil
+}
+
+// get fetches the JSON document at the given URL and decodes it into the given
+// value. The response is returned so that its status can be inspected.
+func (bp *BackstageProvider) get(
+	ctx context.Context,
+	client *nethttp.Client,
+	u string,
+	v interface{},
+) (
+	*nethttp.Response,
+	error,
+) {
+	bp.Interop.Logger.Debugf("making backstage request using URL %s...", u)
+
+	req, err := nethttp.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+
+	metrics.ProviderRequestDuration.WithLabelValues("backstage").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusOK {
+		return resp, fmt.Errorf("fetch catalog entities failed: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp, fmt.Errorf("invalid JSON response: %v", err)
+	}
+
+	return resp, nil
+}
+
+// filters returns the values of the filter parameters for the kinds and types
+// of the query. The catalog API matches entities that match any of the filter
+// parameters and all of the conditions of a filter parameter.
+func (cq *catalogQuery) filters() []string {
+	filters := []string{}
+
+	switch {
+	case len(cq.kinds) > 0 && len(cq.types) > 0:
+		for _, kind := range cq.kinds {
+			for _, t := range cq.types {
+				filters = append(filters, "kind="+kind+",spec.type="+t)
+			}
+		}
+
+	case len(cq.kinds) > 0:
+		for _, kind := range cq.kinds {
+			filters = append(filters, "kind="+kind)
+		}
+
+	case len(cq.types) > 0:
+		for _, t := range cq.types {
+			filters = append(filters, "spec.type="+t)
+		}
+	}
+
+	return filters
+}
+
+// apiIterator fetches one page of entities from the catalog API per call to
+// Next.
+type apiIterator struct {
+	bp      *BackstageProvider
+	query   *catalogQuery
+	client  *nethttp.Client
+	tags    []string
+	cursor  string
+	started bool
+	done    bool
+}
+
+func (it *apiIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(it.bp.PageSize))
+
+	// The cursor encodes the filters of the first request
+	if it.started {
+		params.Set("cursor", it.cursor)
+	} else {
+		for _, filter := range it.query.filters() {
+			params.Add("filter", filter)
+		}
+	}
+
+	it.started = true
+
+	var resp queryResponse
+
+	if _, err := it.bp.get(
+		ctx,
+		it.client,
+		it.bp.BaseURL+"/api/catalog/entities/by-query?"+params.Encode(),
+		&resp,
+	); err != nil {
+		it.done = true
+		return nil, err
+	}
+
+	entities := []provider.Entity{}
+
+	for _, item := range resp.Items {
+		if !it.query.matches(item) {
+			continue
+		}
+
+		if entity := it.bp.toEntity(item, it.tags); entity != nil {
+			entities = append(entities, *entity)
+		}
+	}
+
+	next := resp.PageInfo.NextCursor
+	if next == "" || next == it.cursor {
+		it.done = true
+	}
+
+	it.cursor = next
+
+	return entities, nil
+}
+
+func (it *apiIterator) Close() error {
+	it.done = true
+	return nil
+}
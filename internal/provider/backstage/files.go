@@ -0,0 +1,209 @@
+package backstage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"gopkg.in/yaml.v3"
+)
+
+func (bp *BackstageProvider) fileEntities(
+	cq *catalogQuery,
+	tags []string,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	files, err := bp.findFiles(cq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileIterator{
+		bp:    bp,
+		query: cq,
+		files: files,
+		tags:  tags,
+	}, nil
+}
+
+// getFileEntity scans the catalog files for the entity with the given entity
+// reference.
+func (bp *BackstageProvider) getFileEntity(
+	ctx context.Context,
+	cq *catalogQuery,
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	if _, _, _, err := parseEntityRef(id); err != nil {
+		return nil, err
+	}
+
+	it, err := bp.fileEntities(cq, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	defer it.Close()
+
+	for {
+		entities, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range entities {
+			if strings.EqualFold(entities[index].ID, id) {
+				return &entities[index], nil
+			}
+		}
+	}
+}
+
+// findFiles returns the files under the path of the query whose names match
+// the file name pattern, in lexical order. The path may also be a single
+// file. Hidden directories, such as .git, are not searched.
+func (bp *BackstageProvider) findFiles(cq *catalogQuery) ([]string, error) {
+	root := cq.path
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(bp.BaseDir, root)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog path %s: %v", root, err)
+	}
+
+	if !info.IsDir() {
+		return []string{root}, nil
+	}
+
+	files := []string{}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if ok, _ := filepath.Match(cq.fileName, d.Name()); ok {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %v", root, err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files named %s found in %s", cq.fileName, root)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// readCatalogFile returns the entities of a catalog file. Catalog files may
+// hold several entities separated by YAML document markers.
+func readCatalogFile(path string) ([]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	entities := []map[string]interface{}{}
+	decoder := yaml.NewDecoder(f)
+
+	for {
+		var entity map[string]interface{}
+
+		err := decoder.Decode(&entity)
+		if errors.Is(err, io.EOF) {
+			return entities, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Empty documents are decoded as nil maps
+		if entity != nil {
+			entities = append(entities, entity)
+		}
+	}
+}
+
+// fileIterator returns the entities of one catalog file per call to Next.
+type fileIterator struct {
+	bp    *BackstageProvider
+	query *catalogQuery
+	files []string
+	tags  []string
+}
+
+func (it *fileIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	for len(it.files) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		file := it.files[0]
+		it.files = it.files[1:]
+
+		it.bp.Interop.Logger.Debugf("reading catalog entities from %s", file)
+
+		items, err := readCatalogFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+
+		entities := []provider.Entity{}
+
+		for _, item := range items {
+			if !it.query.matches(item) {
+				continue
+			}
+
+			if entity := it.bp.toEntity(item, it.tags); entity != nil {
+				entities = append(entities, *entity)
+			}
+		}
+
+		if len(entities) > 0 {
+			return entities, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (it *fileIterator) Close() error {
+	it.files = nil
+	return nil
+}
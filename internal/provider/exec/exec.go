@@ -0,0 +1,373 @@
+// Package exec implements a provider that reads external entities from an
+// out-of-process plugin. The plugin is an executable that exchanges JSON
+// messages with the provider over its standard input and output.
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	DEFAULT_TIMEOUT    = 60 * time.Second
+	DEFAULT_BATCH_SIZE = 1000
+)
+
+type ExecProvider struct {
+	Interop    *interop.Interop
+	Command    []string
+	Env        []string
+	WorkingDir string
+	Settings   map[string]interface{}
+	Timeout    time.Duration
+	BatchSize  int
+	// capabilities are those reported by the plugin when the provider is
+	// created. They are not changed when the plugin is restarted so that they
+	// can be read without the lock.
+	capabilities provider.Capabilities
+	// lock is held for the duration of a request, including while the
+	// entities of a get_entities request are streamed, since the plugin
+	// handles one request at a time
+	lock    sync.Mutex
+	proc    *process
+	started bool
+}
+
+func init() {
+	provider.RegisterProviderV2("exec", New)
+	provider.RegisterSchema("exec", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"command": config.ListOf(config.String()).Require(),
+			"env": config.ListOf(config.Object(map[string]*config.Node{
+				"name":  config.String().Require(),
+				"value": config.String(),
+			})),
+			"workingDir": config.String(),
+			"settings":   config.Object(nil).Open(),
+			"timeout":    config.Duration(),
+			"batchSize":  config.Int(),
+		},
+	})
+}
+
+// New launches the plugin and initializes it. The plugin process is kept
+// running until the provider is closed.
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	command := v.GetStringSlice("command")
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("missing exec plugin command")
+	}
+
+	env := []string{}
+
+	for _, item := range cast.ToSlice(v.Get("env")) {
+		variable := cast.ToStringMapString(item)
+
+		name := variable["name"]
+		if name == "" || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("invalid exec plugin environment variable name: %s", name)
+		}
+
+		env = append(env, name+"="+variable["value"])
+	}
+
+	timeout := DEFAULT_TIMEOUT
+	if v.IsSet("timeout") {
+		timeout = v.GetDuration("timeout")
+		if timeout <= 0 {
+			return nil, fmt.Errorf("invalid exec plugin timeout: %s", v.GetString("timeout"))
+		}
+	}
+
+	batchSize := v.GetInt("batchSize")
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BATCH_SIZE
+	}
+
+	ep := &ExecProvider{
+		Interop:    i,
+		Command:    command,
+		Env:        env,
+		WorkingDir: v.GetString("workingDir"),
+		Settings:   v.GetStringMap("settings"),
+		Timeout:    timeout,
+		BatchSize:  batchSize,
+	}
+
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	if _, err := ep.start(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return ep, nil
+}
+
+func (ep *ExecProvider) Capabilities() provider.Capabilities {
+	return ep.capabilities
+}
+
+// ValidateQuery accepts all queries. Queries are validated by the plugin when
+// entities are requested.
+func (ep *ExecProvider) ValidateQuery(config map[string]interface{}) error {
+	return nil
+}
+
+// Entities sends a get_entities request to the plugin and returns an iterator
+// over the entities streamed back, in batches of BatchSize entities. The
+// provider is locked until the iterator is closed.
+func (ep *ExecProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	ep.lock.Lock()
+
+	proc, err := ep.start(ctx)
+	if err != nil {
+		ep.lock.Unlock()
+		return nil, err
+	}
+
+	err = proc.send(&request{
+		Type:       MESSAGE_GET_ENTITIES,
+		Query:      query.Config,
+		Tags:       query.Tags,
+		LastUpdate: query.LastUpdate,
+	})
+	if err != nil {
+		ep.kill()
+		ep.lock.Unlock()
+		return nil, err
+	}
+
+	return &entityIterator{ep: ep, proc: proc}, nil
+}
+
+func (ep *ExecProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	if !ep.capabilities.EntityLookup {
+		return nil, fmt.Errorf("exec plugin does not support fetching single entities")
+	}
+
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	proc, err := ep.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = proc.send(&request{
+		Type:  MESSAGE_GET_ENTITY,
+		Query: config,
+		Tags:  tags,
+		Id:    id,
+	})
+	if err != nil {
+		ep.kill()
+		return nil, err
+	}
+
+	resp, err := proc.receive(ctx, ep.Timeout)
+	if err != nil {
+		ep.kill()
+		return nil, err
+	}
+
+	switch resp.Type {
+	case MESSAGE_ENTITY:
+		return resp.entity()
+
+	case MESSAGE_END:
+		return nil, nil
+
+	case MESSAGE_ERROR:
+		return nil, fmt.Errorf("exec plugin failed: %s", resp.Message)
+	}
+
+	ep.kill()
+
+	return nil, fmt.Errorf("unexpected exec plugin message type: %s", resp.Type)
+}
+
+// Close sends a shutdown request to the plugin and waits for it to exit. The
+// plugin is killed if it does not exit within the timeout.
+func (ep *ExecProvider) Close() error {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+
+	if ep.proc == nil {
+		return nil
+	}
+
+	proc := ep.proc
+	ep.proc = nil
+
+	if err := proc.send(&request{Type: MESSAGE_SHUTDOWN}); err != nil {
+		proc.kill()
+		return nil
+	}
+
+	if !proc.wait(ep.Timeout) {
+		ep.Interop.Logger.Warnf("exec plugin did not exit after shutdown; killing it")
+		proc.kill()
+	}
+
+	return nil
+}
+
+// start launches and initializes the plugin if it is not running. The caller
+// must hold the lock.
+func (ep *ExecProvider) start(ctx context.Context) (*process, error) {
+	if ep.proc != nil {
+		return ep.proc, nil
+	}
+
+	ep.Interop.Logger.Debugf("starting exec plugin %s", ep.Command[0])
+
+	proc, err := startProcess(ep.Interop, ep.Command, ep.Env, ep.WorkingDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec plugin: %v", err)
+	}
+
+	capabilities, err := proc.initialize(ctx, ep.Settings, ep.Timeout)
+	if err != nil {
+		proc.kill()
+		return nil, err
+	}
+
+	reported := provider.Capabilities{
+		Delta:        capabilities.Delta,
+		EntityLookup: capabilities.EntityLookup,
+	}
+
+	if !ep.started {
+		ep.capabilities = reported
+		ep.started = true
+	} else if reported != ep.capabilities {
+		ep.Interop.Logger.Warnf(
+			"exec plugin %s reported different capabilities after a restart; using the capabilities reported at startup",
+			ep.Command[0],
+		)
+	}
+
+	ep.proc = proc
+
+	return proc, nil
+}
+
+// kill kills the plugin after a protocol error. It is started again by the
+// next request. The caller must hold the lock.
+func (ep *ExecProvider) kill() {
+	if ep.proc != nil {
+		ep.proc.kill()
+		ep.proc = nil
+	}
+}
+
+// entityIterator reads the entities streamed by the plugin in response to a
+// get_entities request.
+type entityIterator struct {
+	ep        *ExecProvider
+	proc      *process
+	pageCount int
+	done      bool
+	closed    bool
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	entities := []provider.Entity{}
+
+	for len(entities) < it.ep.BatchSize {
+		resp, err := it.proc.receive(ctx, it.ep.Timeout)
+		if err != nil {
+			it.fail()
+			return nil, err
+		}
+
+		switch resp.Type {
+		case MESSAGE_ENTITY:
+			entity, err := resp.entity()
+			if err != nil {
+				it.fail()
+				return nil, err
+			}
+
+			entities = append(entities, *entity)
+			continue
+
+		case MESSAGE_END:
+			it.done = true
+
+		case MESSAGE_ERROR:
+			it.done = true
+			return nil, fmt.Errorf("exec plugin failed: %s", resp.Message)
+
+		default:
+			it.fail()
+			return nil, fmt.Errorf("unexpected exec plugin message type: %s", resp.Type)
+		}
+
+		break
+	}
+
+	if len(entities) == 0 {
+		return nil, io.EOF
+	}
+
+	it.pageCount += 1
+
+	return entities, nil
+}
+
+// Close releases the provider. If the stream was not read to the end, the
+// plugin is killed since the remaining entities can not be skipped.
+func (it *entityIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+
+	it.closed = true
+
+	if !it.done {
+		it.ep.kill()
+	}
+
+	it.ep.lock.Unlock()
+
+	return nil
+}
+
+func (it *entityIterator) PageCount() int {
+	return it.pageCount
+}
+
+func (it *entityIterator) fail() {
+	it.done = true
+	it.ep.kill()
+}
@@ -0,0 +1,260 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	log "github.com/sirupsen/logrus"
+)
+
+// PROTOCOL_VERSION is the version of the plugin protocol. Plugins must reply
+// to the init request with the same version.
+const PROTOCOL_VERSION = 1
+
+// Message types of the plugin protocol. Requests are sent to the plugin on its
+// standard input and responses are read from its standard output, one JSON
+// object per line.
+const (
+	MESSAGE_INIT         = "init"
+	MESSAGE_GET_ENTITIES = "get_entities"
+	MESSAGE_GET_ENTITY   = "get_entity"
+	MESSAGE_SHUTDOWN     = "shutdown"
+	MESSAGE_ENTITY       = "entity"
+	MESSAGE_END          = "end"
+	MESSAGE_ERROR        = "error"
+)
+
+type request struct {
+	Type            string                 `json:"type"`
+	ProtocolVersion int                    `json:"protocolVersion,omitempty"`
+	Settings        map[string]interface{} `json:"settings,omitempty"`
+	Query           map[string]interface{} `json:"query,omitempty"`
+	Tags            []string               `json:"tags,omitempty"`
+	LastUpdate      *time.Time             `json:"lastUpdate,omitempty"`
+	Id              string                 `json:"id,omitempty"`
+}
+
+type capabilities struct {
+	Delta        bool `json:"delta"`
+	EntityLookup bool `json:"entityLookup"`
+}
+
+type response struct {
+	Type            string                 `json:"type"`
+	ProtocolVersion int                    `json:"protocolVersion"`
+	Capabilities    capabilities           `json:"capabilities"`
+	Id              string                 `json:"id"`
+	Tags            map[string]interface{} `json:"tags"`
+	Message         string                 `json:"message"`
+}
+
+func (r *response) entity() (*provider.Entity, error) {
+	if r.Id == "" {
+		return nil, fmt.Errorf("exec plugin returned an entity with no id")
+	}
+
+	tags := r.Tags
+	if tags == nil {
+		tags = map[string]interface{}{}
+	}
+
+	return &provider.Entity{ID: r.Id, Tags: tags}, nil
+}
+
+// process is a running plugin. Lines written by the plugin to its standard
+// output are read by a goroutine so that reads can be abandoned when the
+// context is canceled or the timeout expires.
+type process struct {
+	cmd   *osexec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte
+}
+
+func startProcess(
+	i *interop.Interop,
+	command []string,
+	env []string,
+	workingDir string,
+) (*process, error) {
+	cmd := osexec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Dir = workingDir
+
+	// Anything the plugin writes to its standard error is logged
+	stderr := i.Logger.WriterLevel(log.DebugLevel)
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		stderr.Close()
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stderr.Close()
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, err
+	}
+
+	proc := &process{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan []byte),
+	}
+
+	go func() {
+		reader := bufio.NewReader(stdout)
+
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				proc.lines <- line
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		if err := cmd.Wait(); err != nil {
+			i.Logger.Debugf("exec plugin exited: %v", err)
+		}
+
+		stderr.Close()
+		close(proc.lines)
+	}()
+
+	return proc, nil
+}
+
+// initialize sends the init request and checks the protocol version of the
+// reply.
+func (p *process) initialize(
+	ctx context.Context,
+	settings map[string]interface{},
+	timeout time.Duration,
+) (*capabilities, error) {
+	err := p.send(&request{
+		Type:            MESSAGE_INIT,
+		ProtocolVersion: PROTOCOL_VERSION,
+		Settings:        settings,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.receive(ctx, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("exec plugin initialization failed: %v", err)
+	}
+
+	if resp.Type == MESSAGE_ERROR {
+		return nil, fmt.Errorf("exec plugin initialization failed: %s", resp.Message)
+	}
+
+	if resp.Type != MESSAGE_INIT {
+		return nil, fmt.Errorf(
+			"exec plugin initialization failed: unexpected message type: %s",
+			resp.Type,
+		)
+	}
+
+	if resp.ProtocolVersion != PROTOCOL_VERSION {
+		return nil, fmt.Errorf(
+			"exec plugin uses protocol version %d; expected %d",
+			resp.ProtocolVersion,
+			PROTOCOL_VERSION,
+		)
+	}
+
+	return &resp.Capabilities, nil
+}
+
+func (p *process) send(req *request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode exec plugin request: %v", err)
+	}
+
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to exec plugin: %v", err)
+	}
+
+	return nil
+}
+
+// receive waits for the next message from the plugin for at most the given
+// timeout.
+func (p *process) receive(
+	ctx context.Context,
+	timeout time.Duration,
+) (*response, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return nil, fmt.Errorf("exec plugin exited unexpectedly")
+		}
+
+		resp := &response{}
+		if err := json.Unmarshal(line, resp); err != nil {
+			return nil, fmt.Errorf("invalid exec plugin message: %v", err)
+		}
+
+		return resp, nil
+
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out waiting for exec plugin after %s", timeout)
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait closes the standard input of the plugin and waits for at most the
+// given timeout for it to exit. Remaining output is discarded.
+func (p *process) wait(timeout time.Duration) bool {
+	p.stdin.Close()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case _, ok := <-p.lines:
+			if !ok {
+				return true
+			}
+
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (p *process) kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+
+	// Unblock the reader if it is waiting to deliver a line
+	go func() {
+		for range p.lines {
+		}
+	}()
+}
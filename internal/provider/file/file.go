@@ -0,0 +1,400 @@
+// Package file implements a provider that reads external entities from local
+// CSV, JSON and YAML files.
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+
+	DEFAULT_ID_FIELD   = "id"
+	DEFAULT_BATCH_SIZE = 1000
+)
+
+type FileProvider struct {
+	Interop   *interop.Interop
+	BaseDir   string
+	BatchSize int
+}
+
+// fileQuery is the parsed extEntityQuery of a mapping.
+type fileQuery struct {
+	path            string
+	format          string
+	idField         string
+	timestampField  string
+	timestampFormat string
+	delimiter       rune
+}
+
+func init() {
+	provider.RegisterProviderV2("file", New)
+	provider.RegisterSchema("file", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"baseDir":   config.String(),
+			"batchSize": config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"path": config.String().Require(),
+			"format": config.String().OneOf(
+				FORMAT_CSV,
+				FORMAT_JSON,
+				FORMAT_YAML,
+			),
+			"idField":         config.String(),
+			"timestampField":  config.String(),
+			"timestampFormat": config.String(),
+			"delimiter":       config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	baseDir := v.GetString("baseDir")
+	if baseDir == "" {
+		// Relative paths are resolved against the directory of the
+		// configuration file so that data files can be kept alongside it
+		if configFile := i.ConfigFileUsed(); configFile != "" {
+			baseDir = filepath.Dir(configFile)
+		} else {
+			baseDir = "."
+		}
+	}
+
+	batchSize := v.GetInt("batchSize")
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BATCH_SIZE
+	}
+
+	return &FileProvider{
+		Interop:   i,
+		BaseDir:   baseDir,
+		BatchSize: batchSize,
+	}, nil
+}
+
+func (fp *FileProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Delta:        true,
+		EntityLookup: true,
+	}
+}
+
+func (fp *FileProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator over the rows of the files matching the path of
+// the query, in lexical order of the file names. Each call to Next returns at
+// most BatchSize rows.
+func (fp *FileProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	fq, err := parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := provider.FindFiles(fp.BaseDir, fq.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entityIterator{
+		fp:         fp,
+		query:      fq,
+		files:      files,
+		tags:       query.Tags,
+		lastUpdate: query.LastUpdate,
+	}, nil
+}
+
+// GetEntity scans the files matching the path of the query for the row with
+// the given ID.
+func (fp *FileProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	it, err := fp.Entities(ctx, &provider.Query{Config: config, Tags: tags})
+	if err != nil {
+		return nil, err
+	}
+
+	defer it.Close()
+
+	for {
+		entities, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range entities {
+			if entities[index].ID == id {
+				return &entities[index], nil
+			}
+		}
+	}
+}
+
+func (fp *FileProvider) Close() error {
+	return nil
+}
+
+func parseQuery(config map[string]interface{}) (*fileQuery, error) {
+	fq := &fileQuery{
+		path:            cast.ToString(config["path"]),
+		format:          strings.ToLower(cast.ToString(config["format"])),
+		idField:         cast.ToString(config["idfield"]),
+		timestampField:  cast.ToString(config["timestampfield"]),
+		timestampFormat: cast.ToString(config["timestampformat"]),
+		delimiter:       ',',
+	}
+
+	if fq.path == "" {
+		return nil, fmt.Errorf("missing file path")
+	}
+
+	if _, err := filepath.Match(fq.path, ""); err != nil {
+		return nil, fmt.Errorf("invalid file path %s: %v", fq.path, err)
+	}
+
+	if fq.format != "" &&
+		fq.format != FORMAT_CSV &&
+		fq.format != FORMAT_JSON &&
+		fq.format != FORMAT_YAML {
+		return nil, fmt.Errorf("invalid file format: %s", fq.format)
+	}
+
+	if fq.idField == "" {
+		fq.idField = DEFAULT_ID_FIELD
+	}
+
+	if delimiter := cast.ToString(config["delimiter"]); delimiter != "" {
+		if utf8.RuneCountInString(delimiter) != 1 {
+			return nil, fmt.Errorf("invalid csv delimiter: %s", delimiter)
+		}
+
+		fq.delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	}
+
+	return fq, nil
+}
+
+func formatFromExtension(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FORMAT_CSV
+
+	case ".json":
+		return FORMAT_JSON
+
+	case ".yaml", ".yml":
+		return FORMAT_YAML
+	}
+
+	return ""
+}
+
+type entityIterator struct {
+	fp         *FileProvider
+	query      *fileQuery
+	files      []string
+	tags       []string
+	lastUpdate *time.Time
+	path       string
+	reader     rowReader
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	entities := []provider.Entity{}
+
+	for len(entities) < it.fp.BatchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if it.reader == nil {
+			if len(it.files) == 0 {
+				break
+			}
+
+			if err := it.openNext(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		row, err := it.reader.next()
+		if err == io.EOF {
+			it.reader.close()
+			it.reader = nil
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", it.path, err)
+		}
+
+		entity, err := it.toEntity(row)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", it.path, err)
+		}
+
+		if entity != nil {
+			entities = append(entities, *entity)
+		}
+	}
+
+	if len(entities) == 0 {
+		return nil, io.EOF
+	}
+
+	return entities, nil
+}
+
+// openNext opens the next file. During delta synchronization without a
+// timestamp field, files that were not modified since the last update are
+// skipped.
+func (it *entityIterator) openNext() error {
+	path := it.files[0]
+	it.files = it.files[1:]
+
+	if it.lastUpdate != nil && it.query.timestampField == "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.ModTime().Before(*it.lastUpdate) {
+			it.fp.Interop.Logger.Debugf(
+				"skipping %s; not modified since %s",
+				path,
+				it.lastUpdate.Format(time.RFC3339),
+			)
+			return nil
+		}
+	}
+
+	it.fp.Interop.Logger.Debugf("reading entities from %s", path)
+
+	// Without an explicit format, the format of each file is determined by its
+	// extension
+	format := it.query.format
+	if format == "" {
+		format = formatFromExtension(path)
+		if format == "" {
+			return fmt.Errorf("unable to determine the format of %s", path)
+		}
+	}
+
+	reader, err := openRowReader(path, format, it.query.delimiter)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	it.path = path
+	it.reader = reader
+
+	return nil
+}
+
+// toEntity returns the entity for the given row, or nil if the row has no ID
+// or, during delta synchronization, was not updated since the last update.
+func (it *entityIterator) toEntity(row map[string]interface{}) (*provider.Entity, error) {
+	value, ok := lookup(row, it.query.idField)
+	id := cast.ToString(value)
+	if !ok || id == "" {
+		it.fp.Interop.Logger.Warnf(
+			"skipping row in %s with no %s field",
+			it.path,
+			it.query.idField,
+		)
+		return nil, nil
+	}
+
+	if it.lastUpdate != nil && it.query.timestampField != "" {
+		updated, err := it.timestamp(row)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp for %s: %v", id, err)
+		}
+
+		if updated != nil && updated.Before(*it.lastUpdate) {
+			return nil, nil
+		}
+	}
+
+	return &provider.Entity{
+		ID:   id,
+		Tags: provider.SelectFields(row, it.tags),
+	}, nil
+}
+
+// timestamp returns the value of the timestamp field of the row, or nil if the
+// row has none.
+func (it *entityIterator) timestamp(row map[string]interface{}) (*time.Time, error) {
+	value, ok := lookup(row, it.query.timestampField)
+	s := cast.ToString(value)
+	if !ok || s == "" {
+		return nil, nil
+	}
+
+	var (
+		t   time.Time
+		err error
+	)
+
+	if it.query.timestampFormat != "" {
+		t, err = time.Parse(it.query.timestampFormat, s)
+	} else {
+		t, err = cast.ToTimeE(s)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (it *entityIterator) Close() error {
+	it.files = nil
+
+	if it.reader != nil {
+		it.reader.close()
+		it.reader = nil
+	}
+
+	return nil
+}
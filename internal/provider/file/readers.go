@@ -0,0 +1,202 @@
+package file
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"gopkg.in/yaml.v3"
+)
+
+// rowReader reads the rows of a file one at a time. next returns io.EOF once
+// all rows have been read.
+type rowReader interface {
+	next() (map[string]interface{}, error)
+	close() error
+}
+
+func openRowReader(path string, format string, delimiter rune) (rowReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FORMAT_CSV:
+		return newCsvReader(f, delimiter)
+
+	case FORMAT_JSON:
+		return newJsonReader(f)
+
+	case FORMAT_YAML:
+		return newYamlReader(f)
+	}
+
+	f.Close()
+
+	return nil, fmt.Errorf("unsupported file format: %s", format)
+}
+
+// csvReader reads the records of a CSV file with a header row. Columns with
+// dots in their names are read into nested fields so that they can be
+// referenced by dot paths.
+type csvReader struct {
+	f      *os.File
+	reader *csv.Reader
+	header []string
+}
+
+func newCsvReader(f *os.File, delimiter rune) (rowReader, error) {
+	reader := csv.NewReader(f)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		header = []string{}
+	} else if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+
+	for index := range header {
+		header[index] = strings.TrimSpace(header[index])
+	}
+
+	return &csvReader{f, reader, header}, nil
+}
+
+func (r *csvReader) next() (map[string]interface{}, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	row := map[string]interface{}{}
+
+	for index, value := range record {
+		if index >= len(r.header) || r.header[index] == "" {
+			continue
+		}
+
+		setNested(row, strings.Split(r.header[index], "."), value)
+	}
+
+	return row, nil
+}
+
+func (r *csvReader) close() error {
+	return r.f.Close()
+}
+
+// jsonReader streams the objects of a JSON array.
+type jsonReader struct {
+	f       *os.File
+	decoder *json.Decoder
+}
+
+func newJsonReader(f *os.File) (rowReader, error) {
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+
+	token, err := decoder.Token()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read JSON array: %v", err)
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		f.Close()
+		return nil, fmt.Errorf("file does not contain a JSON array")
+	}
+
+	return &jsonReader{f, decoder}, nil
+}
+
+func (r *jsonReader) next() (map[string]interface{}, error) {
+	if !r.decoder.More() {
+		return nil, io.EOF
+	}
+
+	row := map[string]interface{}{}
+	if err := r.decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %v", err)
+	}
+
+	return provider.StringifyValues(row), nil
+}
+
+func (r *jsonReader) close() error {
+	return r.f.Close()
+}
+
+// yamlReader reads the items of a YAML sequence. The whole file is decoded at
+// once.
+type yamlReader struct {
+	f     *os.File
+	rows  []map[string]interface{}
+	index int
+}
+
+func newYamlReader(f *os.File) (rowReader, error) {
+	rows := []map[string]interface{}{}
+
+	if err := yaml.NewDecoder(f).Decode(&rows); err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("file does not contain a YAML sequence of mappings: %v", err)
+	}
+
+	return &yamlReader{f: f, rows: rows}, nil
+}
+
+func (r *yamlReader) next() (map[string]interface{}, error) {
+	if r.index >= len(r.rows) {
+		return nil, io.EOF
+	}
+
+	row := r.rows[r.index]
+	r.rows[r.index] = nil
+	r.index += 1
+
+	return provider.StringifyValues(row), nil
+}
+
+func (r *yamlReader) close() error {
+	return r.f.Close()
+}
+
+func setNested(m map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+
+		m = child
+	}
+
+	m[path[len(path)-1]] = value
+}
+
+// lookup returns the value at the given dot path.
+func lookup(m map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		m = child
+	}
+
+	value, ok := m[keys[len(keys)-1]]
+
+	return value, ok
+}
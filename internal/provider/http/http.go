@@ -0,0 +1,619 @@
+// Package http implements a provider that reads external entities from REST
+// APIs that return JSON.
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	PAGINATION_NONE   = "none"
+	PAGINATION_LINK   = "link"
+	PAGINATION_CURSOR = "cursor"
+	PAGINATION_OFFSET = "offset"
+
+	DEFAULT_PAGE_SIZE = 100
+)
+
+var (
+	lastUpdateRE = regexp.MustCompile(`(?i)\${lastUpdate}`)
+	idRE         = regexp.MustCompile(`(?i)\${id}`)
+	linkRE       = regexp.MustCompile(`<([^>]+)>\s*;\s*rel\s*=\s*"([^"]+)"`)
+)
+
+type HttpProvider struct {
+	Interop      *interop.Interop
+	BaseURL      string
+	ClientConfig *httpclient.Config
+	PageSize     int
+}
+
+// httpQuery is the parsed extEntityQuery of a mapping.
+type httpQuery struct {
+	url              string
+	recordsPath      jsonPath
+	idField          jsonPath
+	pagination       string
+	cursorPath       jsonPath
+	cursorParam      string
+	offsetParam      string
+	limitParam       string
+	pageSize         int
+	lastUpdateFormat string
+	entityUrl        string
+	entityPath       jsonPath
+}
+
+func init() {
+	provider.RegisterProviderV2("http", New)
+	provider.RegisterSchema("http", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"baseUrl": config.String(),
+			"authType": config.String().OneOf(
+				string(httpclient.AUTH_TYPE_NONE),
+				string(httpclient.AUTH_TYPE_BASIC),
+				string(httpclient.AUTH_TYPE_BEARER),
+				string(httpclient.AUTH_TYPE_OAUTH),
+			),
+			"username":      config.String(),
+			"password":      config.String(),
+			"token":         config.String(),
+			"oauthTokenUrl": config.String(),
+			"oauthGrantType": config.String().OneOf(
+				string(httpclient.OAUTH_GRANT_TYPE_PASSWORD),
+				string(httpclient.OAUTH_GRANT_TYPE_CLIENT_CREDENTIALS),
+			),
+			"oauthClientId":     config.String(),
+			"oauthClientSecret": config.String(),
+			"oauthClientScopes": config.ListOf(config.String()),
+			"headers": config.ListOf(config.Object(map[string]*config.Node{
+				"name":  config.String().Require(),
+				"value": config.String(),
+			})),
+			"pageSize": config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"url":         config.String().Require(),
+			"recordsPath": config.String(),
+			"idField":     config.String(),
+			"pagination": config.String().OneOf(
+				PAGINATION_NONE,
+				PAGINATION_LINK,
+				PAGINATION_CURSOR,
+				PAGINATION_OFFSET,
+			),
+			"cursorPath":       config.String(),
+			"cursorParam":      config.String(),
+			"offsetParam":      config.String(),
+			"limitParam":       config.String(),
+			"pageSize":         config.Int(),
+			"lastUpdateFormat": config.String(),
+			"entityUrl":        config.String(),
+			"entityPath":       config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	authType := httpclient.AuthType(strings.ToLower(v.GetString("authType")))
+	if authType == "" {
+		authType = httpclient.AUTH_TYPE_NONE
+	}
+
+	clientConfig := &httpclient.Config{
+		AuthType: authType,
+		Headers:  map[string]string{},
+	}
+
+	for _, item := range cast.ToSlice(v.Get("headers")) {
+		header := cast.ToStringMapString(item)
+		if header["name"] == "" {
+			return nil, fmt.Errorf("missing http header name")
+		}
+
+		clientConfig.Headers[header["name"]] = header["value"]
+	}
+
+	switch authType {
+	case httpclient.AUTH_TYPE_NONE:
+
+	case httpclient.AUTH_TYPE_BASIC:
+		clientConfig.Username = v.GetString("username")
+		clientConfig.Password = v.GetString("password")
+
+		if clientConfig.Username == "" {
+			return nil, fmt.Errorf("missing http username")
+		}
+
+	case httpclient.AUTH_TYPE_BEARER:
+		clientConfig.BearerToken = v.GetString("token")
+
+		if clientConfig.BearerToken == "" {
+			return nil, fmt.Errorf("missing http bearer token")
+		}
+
+	case httpclient.AUTH_TYPE_OAUTH:
+		grantType := httpclient.OAuthGrantType(
+			strings.ToLower(v.GetString("oauthGrantType")),
+		)
+		if grantType == "" {
+			grantType = httpclient.OAUTH_GRANT_TYPE_CLIENT_CREDENTIALS
+		}
+
+		clientConfig.OAuthGrantType = grantType
+		clientConfig.OAuthTokenURL = v.GetString("oauthTokenUrl")
+		clientConfig.OAuthClientID = v.GetString("oauthClientId")
+		clientConfig.OAuthClientSecret = v.GetString("oauthClientSecret")
+		clientConfig.OAuthScopes = v.GetStringSlice("oauthClientScopes")
+		clientConfig.Username = v.GetString("username")
+		clientConfig.Password = v.GetString("password")
+
+		if clientConfig.OAuthTokenURL == "" {
+			return nil, fmt.Errorf("missing http oauth token url")
+		}
+
+		if clientConfig.OAuthClientID == "" {
+			return nil, fmt.Errorf("missing http oauth client ID")
+		}
+
+		if clientConfig.OAuthClientSecret == "" {
+			return nil, fmt.Errorf("missing http oauth client secret")
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid authentication type: %s", authType)
+	}
+
+	pageSize := v.GetInt("pageSize")
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+
+	return &HttpProvider{
+		Interop:      i,
+		BaseURL:      v.GetString("baseUrl"),
+		ClientConfig: clientConfig,
+		PageSize:     pageSize,
+	}, nil
+}
+
+func (hp *HttpProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Delta:        true,
+		EntityLookup: true,
+	}
+}
+
+func (hp *HttpProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := hp.parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator that fetches one page of records per call to
+// Next.
+func (hp *HttpProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	hq, err := hp.parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := httpclient.New(ctx, hp.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lastUpdate := time.Unix(0, 0).UTC()
+	if query.LastUpdate != nil {
+		lastUpdate = *query.LastUpdate
+	}
+
+	pageUrl := lastUpdateRE.ReplaceAllLiteralString(
+		hq.url,
+		url.QueryEscape(lastUpdate.Format(hq.lastUpdateFormat)),
+	)
+
+	return &entityIterator{
+		hp:      hp,
+		query:   hq,
+		client:  client,
+		tags:    query.Tags,
+		baseUrl: hp.resolveUrl(pageUrl),
+	}, nil
+}
+
+// GetEntity fetches the record with the given ID from the entityUrl of the
+// query.
+func (hp *HttpProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	hq, err := hp.parseQuery(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if hq.entityUrl == "" {
+		return nil, fmt.Errorf("missing entityUrl for fetching single entities")
+	}
+
+	client, err := httpclient.New(ctx, hp.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	entityUrl := hp.resolveUrl(
+		idRE.ReplaceAllLiteralString(hq.entityUrl, url.PathEscape(id)),
+	)
+
+	body, resp, err := hp.get(ctx, client, entityUrl)
+	if resp != nil && resp.StatusCode == nethttp.StatusNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := hq.entityPath.first(body)
+	if !ok {
+		return nil, nil
+	}
+
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("entity at %s is not a JSON object", entityUrl)
+	}
+
+	return hp.toEntity(hq, record, tags), nil
+}
+
+func (hp *HttpProvider) Close() error {
+	return nil
+}
+
+func (hp *HttpProvider) parseQuery(config map[string]interface{}) (*httpQuery, error) {
+	var err error
+
+	hq := &httpQuery{
+		url:              cast.ToString(config["url"]),
+		pagination:       strings.ToLower(cast.ToString(config["pagination"])),
+		cursorParam:      cast.ToString(config["cursorparam"]),
+		offsetParam:      cast.ToString(config["offsetparam"]),
+		limitParam:       cast.ToString(config["limitparam"]),
+		pageSize:         cast.ToInt(config["pagesize"]),
+		lastUpdateFormat: cast.ToString(config["lastupdateformat"]),
+		entityUrl:        cast.ToString(config["entityurl"]),
+	}
+
+	if hq.url == "" {
+		return nil, fmt.Errorf("missing http url")
+	}
+
+	if hq.recordsPath, err = compileJsonPath(
+		defaultString(cast.ToString(config["recordspath"]), "$"),
+	); err != nil {
+		return nil, err
+	}
+
+	if hq.idField, err = compileJsonPath(
+		defaultString(cast.ToString(config["idfield"]), "$.id"),
+	); err != nil {
+		return nil, err
+	}
+
+	if hq.entityPath, err = compileJsonPath(
+		defaultString(cast.ToString(config["entitypath"]), "$"),
+	); err != nil {
+		return nil, err
+	}
+
+	switch hq.pagination {
+	case "":
+		hq.pagination = PAGINATION_NONE
+
+	case PAGINATION_NONE, PAGINATION_LINK, PAGINATION_OFFSET:
+
+	case PAGINATION_CURSOR:
+		cursorPath := cast.ToString(config["cursorpath"])
+		if cursorPath == "" {
+			return nil, fmt.Errorf("missing cursorPath for cursor pagination")
+		}
+
+		if hq.cursorPath, err = compileJsonPath(cursorPath); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid pagination: %s", hq.pagination)
+	}
+
+	hq.cursorParam = defaultString(hq.cursorParam, "cursor")
+	hq.offsetParam = defaultString(hq.offsetParam, "offset")
+	hq.limitParam = defaultString(hq.limitParam, "limit")
+	hq.lastUpdateFormat = defaultString(hq.lastUpdateFormat, time.RFC3339)
+
+	if hq.pageSize <= 0 {
+		hq.pageSize = hp.PageSize
+	}
+
+	return hq, nil
+}
+
+// resolveUrl prefixes relative URLs with the base URL.
+func (hp *HttpProvider) resolveUrl(u string) string {
+	if hp.BaseURL == "" || strings.Contains(u, "://") {
+		return u
+	}
+
+	return strings.TrimRight(hp.BaseURL, "/") + "/" + strings.TrimLeft(u, "/")
+}
+
+// get fetches and decodes the JSON document at the given URL. The response is
+// returned so that its headers and status can be inspected.
+func (hp *HttpProvider) get(
+	ctx context.Context,
+	client *nethttp.Client,
+	u string,
+) (
+	interface{},
+	*nethttp.Response,
+	error,
+) {
+	hp.Interop.Logger.Debugf("making http request using URL %s...", u)
+
+	req, err := nethttp.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+
+	metrics.ProviderRequestDuration.WithLabelValues("http").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusOK {
+		return nil, resp, fmt.Errorf("fetch results failed: %s", resp.Status)
+	}
+
+	var body interface{}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&body); err != nil {
+		return nil, resp, fmt.Errorf("invalid JSON response: %v", err)
+	}
+
+	return body, resp, nil
+}
+
+func (hp *HttpProvider) toEntity(
+	hq *httpQuery,
+	record map[string]interface{},
+	tags []string,
+) *provider.Entity {
+	value, ok := hq.idField.first(record)
+	id := cast.ToString(value)
+	if !ok || id == "" {
+		hp.Interop.Logger.Warn("skipping record with no id")
+		return nil
+	}
+
+	return &provider.Entity{
+		ID:   id,
+		Tags: provider.StringifyValues(provider.SelectFields(record, tags)),
+	}
+}
+
+type entityIterator struct {
+	hp      *HttpProvider
+	query   *httpQuery
+	client  *nethttp.Client
+	tags    []string
+	baseUrl string
+	nextUrl string
+	cursor  string
+	offset  int
+	started bool
+	done    bool
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	pageUrl, err := it.pageUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	it.started = true
+
+	body, resp, err := it.hp.get(ctx, it.client, pageUrl)
+	if err != nil {
+		it.done = true
+		return nil, err
+	}
+
+	records := it.query.recordsPath.eval(body)
+	if len(records) == 1 {
+		if array, ok := records[0].([]interface{}); ok {
+			records = array
+		}
+	}
+
+	entities := []provider.Entity{}
+
+	for _, value := range records {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			it.hp.Interop.Logger.Warn("skipping record that is not a JSON object")
+			continue
+		}
+
+		if entity := it.hp.toEntity(it.query, record, it.tags); entity != nil {
+			entities = append(entities, *entity)
+		}
+	}
+
+	it.advance(pageUrl, body, resp, len(records))
+
+	return entities, nil
+}
+
+// pageUrl returns the URL of the next page.
+func (it *entityIterator) pageUrl() (string, error) {
+	switch it.query.pagination {
+	case PAGINATION_LINK:
+		if it.started {
+			return it.nextUrl, nil
+		}
+
+		return it.baseUrl, nil
+
+	case PAGINATION_CURSOR:
+		params := map[string]string{it.query.limitParam: cast.ToString(it.query.pageSize)}
+		if it.started {
+			params[it.query.cursorParam] = it.cursor
+		}
+
+		return setParams(it.baseUrl, params)
+
+	case PAGINATION_OFFSET:
+		return setParams(it.baseUrl, map[string]string{
+			it.query.offsetParam: cast.ToString(it.offset),
+			it.query.limitParam:  cast.ToString(it.query.pageSize),
+		})
+	}
+
+	return it.baseUrl, nil
+}
+
+// advance determines whether there is another page after the given page.
+func (it *entityIterator) advance(
+	pageUrl string,
+	body interface{},
+	resp *nethttp.Response,
+	recordCount int,
+) {
+	it.done = true
+
+	switch it.query.pagination {
+	case PAGINATION_LINK:
+		for _, link := range linkRE.FindAllStringSubmatch(resp.Header.Get("Link"), -1) {
+			if link[2] != "next" {
+				continue
+			}
+
+			next, err := resolveReference(pageUrl, link[1])
+			if err != nil {
+				it.hp.Interop.Logger.Warnf("ignoring invalid next link %s: %v", link[1], err)
+				return
+			}
+
+			// Guard against APIs that link a page to itself
+			if next != pageUrl {
+				it.nextUrl = next
+				it.done = false
+			}
+
+			return
+		}
+
+	case PAGINATION_CURSOR:
+		value, _ := it.query.cursorPath.first(body)
+
+		cursor := cast.ToString(value)
+		if cursor != "" && cursor != it.cursor {
+			it.cursor = cursor
+			it.done = false
+		}
+
+	case PAGINATION_OFFSET:
+		if recordCount >= it.query.pageSize {
+			it.offset += recordCount
+			it.done = false
+		}
+	}
+}
+
+func (it *entityIterator) Close() error {
+	it.done = true
+	return nil
+}
+
+func setParams(u string, params map[string]string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %s: %v", u, err)
+	}
+
+	values := parsed.Query()
+	for name, value := range params {
+		values.Set(name, value)
+	}
+
+	parsed.RawQuery = values.Encode()
+
+	return parsed.String(), nil
+}
+
+func resolveReference(base string, ref string) (string, error) {
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return baseUrl.ResolveReference(refUrl).String(), nil
+}
+
+func defaultString(s string, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
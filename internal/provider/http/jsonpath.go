@@ -0,0 +1,140 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression. The supported subset is the
+// root ($), child names (.name or ['name']), array indices ([0]) and wildcards
+// (.* or [*]). Expressions that do not start with $ are relative to the root,
+// so that id is equivalent to $.id.
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func compileJsonPath(expr string) (jsonPath, error) {
+	path := jsonPath{}
+	s := strings.TrimSpace(expr)
+
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	} else if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+
+			name := s[:end]
+			s = s[end:]
+
+			if name == "" {
+				return nil, fmt.Errorf("invalid JSONPath %s: empty name", expr)
+			}
+
+			if name == "*" {
+				path = append(path, pathSegment{wildcard: true})
+				continue
+			}
+
+			path = append(path, pathSegment{name: name})
+
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %s: missing ]", expr)
+			}
+
+			selector := s[1:end]
+			s = s[end+1:]
+
+			if selector == "*" {
+				path = append(path, pathSegment{wildcard: true})
+				continue
+			}
+
+			if len(selector) >= 2 &&
+				(selector[0] == '\'' || selector[0] == '"') &&
+				selector[len(selector)-1] == selector[0] {
+				path = append(path, pathSegment{name: selector[1 : len(selector)-1]})
+				continue
+			}
+
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSONPath %s: invalid selector %s", expr, selector)
+			}
+
+			path = append(path, pathSegment{index: index, isIndex: true})
+
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %s", expr)
+		}
+	}
+
+	return path, nil
+}
+
+// eval returns the values selected by the path.
+func (p jsonPath) eval(value interface{}) []interface{} {
+	values := []interface{}{value}
+
+	for _, segment := range p {
+		selected := []interface{}{}
+
+		for _, v := range values {
+			switch u := v.(type) {
+			case map[string]interface{}:
+				if segment.wildcard {
+					for _, child := range u {
+						selected = append(selected, child)
+					}
+				} else if child, ok := u[segment.name]; ok && !segment.isIndex {
+					selected = append(selected, child)
+				}
+
+			case []interface{}:
+				if segment.wildcard {
+					selected = append(selected, u...)
+				} else if segment.isIndex {
+					index := segment.index
+					if index < 0 {
+						index += len(u)
+					}
+
+					if index >= 0 && index < len(u) {
+						selected = append(selected, u[index])
+					}
+				}
+			}
+		}
+
+		values = selected
+	}
+
+	return values
+}
+
+// first returns the first value selected by the path.
+func (p jsonPath) first(value interface{}) (interface{}, bool) {
+	values := p.eval(value)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	return values[0], true
+}
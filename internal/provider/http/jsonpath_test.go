@@ -0,0 +1,84 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileJsonPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		path jsonPath
+		ok   bool
+	}{
+		{"$", jsonPath{}, true},
+		{"", jsonPath{}, true},
+		{"id", jsonPath{{name: "id"}}, true},
+		{"$.id", jsonPath{{name: "id"}}, true},
+		{" $.a.b ", jsonPath{{name: "a"}, {name: "b"}}, true},
+		{"$.items[0]", jsonPath{{name: "items"}, {index: 0, isIndex: true}}, true},
+		{"$.items[-1]", jsonPath{{name: "items"}, {index: -1, isIndex: true}}, true},
+		{"items[*].id", jsonPath{{name: "items"}, {wildcard: true}, {name: "id"}}, true},
+		{"$.*", jsonPath{{wildcard: true}}, true},
+		{"$['a.b']", jsonPath{{name: "a.b"}}, true},
+		{`$["a b"].c`, jsonPath{{name: "a b"}, {name: "c"}}, true},
+		{"['*']", jsonPath{{name: "*"}}, true},
+		{"[0]", jsonPath{{index: 0, isIndex: true}}, true},
+		{"$.", nil, false},
+		{"$..a", nil, false},
+		{"$.a[", nil, false},
+		{"$.a[x]", nil, false},
+		{"$['a\"]", nil, false},
+		{"$a", nil, false},
+	}
+
+	for _, test := range tests {
+		path, err := compileJsonPath(test.expr)
+		if (err == nil) != test.ok {
+			t.Errorf("compileJsonPath(%q) error = %v; want ok %v", test.expr, err, test.ok)
+			continue
+		}
+
+		if test.ok && !reflect.DeepEqual(path, test.path) {
+			t.Errorf("compileJsonPath(%q) = %+v; want %+v", test.expr, path, test.path)
+		}
+	}
+}
+
+func TestJsonPathEval(t *testing.T) {
+	value := map[string]interface{}{
+		"a.b": "dotted",
+		"items": []interface{}{
+			map[string]interface{}{"id": "first"},
+			map[string]interface{}{"id": "second"},
+			map[string]interface{}{"id": "third"},
+		},
+	}
+
+	tests := []struct {
+		expr   string
+		values []interface{}
+	}{
+		{"$['a.b']", []interface{}{"dotted"}},
+		{"$.a.b", []interface{}{}},
+		{"$.items[0].id", []interface{}{"first"}},
+		{"$.items[-1].id", []interface{}{"third"}},
+		{"$.items[-3].id", []interface{}{"first"}},
+		{"$.items[-4].id", []interface{}{}},
+		{"$.items[3].id", []interface{}{}},
+		{"$.items[*].id", []interface{}{"first", "second", "third"}},
+		{"$.items.id", []interface{}{}},
+	}
+
+	for _, test := range tests {
+		path, err := compileJsonPath(test.expr)
+		if err != nil {
+			t.Errorf("compileJsonPath(%q) failed: %v", test.expr, err)
+			continue
+		}
+
+		if values := path.eval(value); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("eval(%q) = %v; want %v", test.expr, values, test.values)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package httpclient creates the HTTP clients used by providers that read
+// external entities from HTTP APIs, handling authentication and the New Relic
+// instrumentation of requests.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+type AuthType string
+type OAuthGrantType string
+
+const (
+	AUTH_TYPE_NONE                      AuthType       = "none"
+	AUTH_TYPE_BASIC                     AuthType       = "basic"
+	AUTH_TYPE_BEARER                    AuthType       = "bearer"
+	AUTH_TYPE_OAUTH                     AuthType       = "oauth"
+	OAUTH_GRANT_TYPE_PASSWORD           OAuthGrantType = "password"
+	OAUTH_GRANT_TYPE_CLIENT_CREDENTIALS OAuthGrantType = "client_credentials"
+)
+
+type Config struct {
+	AuthType AuthType
+	// Username and Password are used for basic authentication and for the
+	// OAuth password grant
+	Username          string
+	Password          string
+	BearerToken       string
+	OAuthTokenURL     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthGrantType    OAuthGrantType
+	OAuthScopes       []string
+	// Headers are added to every request
+	Headers map[string]string
+	// TLSConfig replaces the default TLS configuration when set, for example
+	// to trust a private certificate authority
+	TLSConfig *tls.Config
+}
+
+// New creates an HTTP client that authenticates requests as configured. For
+// the OAuth password grant, a token is requested before the client is
+// returned.
+func New(ctx context.Context, config *Config) (*http.Client, error) {
+	var transport http.RoundTripper
+
+	if config.TLSConfig != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config.TLSConfig
+		transport = t
+	}
+
+	// The round tripper creates external segments for requests made with a
+	// context that carries a New Relic transaction.
+	baseClient := &http.Client{
+		Transport: &headerTransport{
+			base:   newrelic.NewRoundTripper(transport),
+			config: config,
+		},
+	}
+
+	switch config.AuthType {
+	case AUTH_TYPE_NONE, AUTH_TYPE_BASIC, AUTH_TYPE_BEARER, "":
+		return baseClient, nil
+
+	case AUTH_TYPE_OAUTH:
+
+	default:
+		return nil, fmt.Errorf("invalid authentication type: %s", config.AuthType)
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, baseClient)
+
+	if config.OAuthGrantType == OAUTH_GRANT_TYPE_PASSWORD {
+		oauthConfig := &oauth2.Config{
+			ClientID:     config.OAuthClientID,
+			ClientSecret: config.OAuthClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "",
+				TokenURL:  config.OAuthTokenURL,
+				AuthStyle: oauth2.AuthStyleAutoDetect,
+			},
+			Scopes: config.OAuthScopes,
+		}
+
+		token, err := oauthConfig.PasswordCredentialsToken(
+			ctx,
+			config.Username,
+			config.Password,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return oauthConfig.Client(ctx, token), nil
+	}
+
+	oauthConfig := &clientcredentials.Config{
+		ClientID:     config.OAuthClientID,
+		ClientSecret: config.OAuthClientSecret,
+		TokenURL:     config.OAuthTokenURL,
+		Scopes:       config.OAuthScopes,
+	}
+
+	return oauthConfig.Client(ctx), nil
+}
+
+// headerTransport adds the configured headers and the basic or bearer
+// credentials to each request.
+type headerTransport struct {
+	base   http.RoundTripper
+	config *Config
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	for name, value := range t.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	switch t.config.AuthType {
+	case AUTH_TYPE_BASIC:
+		req.SetBasicAuth(t.config.Username, t.config.Password)
+
+	case AUTH_TYPE_BEARER:
+		req.Header.Set("Authorization", "Bearer "+t.config.BearerToken)
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,298 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+)
+
+// listResponse is a page of objects returned by a list request.
+type listResponse struct {
+	Items    []map[string]interface{} `json:"items"`
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+}
+
+// resourceListResponse is the response of a discovery request for the
+// resources of an API group version.
+type resourceListResponse struct {
+	Resources []struct {
+		Name       string `json:"name"`
+		Kind       string `json:"kind"`
+		Namespaced bool   `json:"namespaced"`
+	} `json:"resources"`
+}
+
+func (kp *KubernetesProvider) apiEntities(
+	ctx context.Context,
+	oq *objectQuery,
+	tags []string,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	client, c, err := kp.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := kp.resolveResource(ctx, client, c, oq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cluster scoped objects and objects in all namespaces are listed with a
+	// single request per page
+	namespaces := []string{""}
+	if r.namespaced && len(oq.namespaces) > 0 {
+		namespaces = oq.namespaces
+	}
+
+	return &apiIterator{
+		kp:         kp,
+		query:      oq,
+		client:     client,
+		cluster:    c,
+		resource:   r,
+		namespaces: namespaces,
+		tags:       tags,
+	}, nil
+}
+
+func (kp *KubernetesProvider) getApiEntity(
+	ctx context.Context,
+	oq *objectQuery,
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	client, c, err := kp.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := kp.resolveResource(ctx, client, c, oq)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, name := parseId(id)
+	if r.namespaced && namespace == "" {
+		return nil, fmt.Errorf("invalid %s id %s; missing namespace", oq.kind, id)
+	}
+
+	var object map[string]interface{}
+
+	resp, err := kp.get(
+		ctx,
+		client,
+		c.resourceUrl(r, namespace)+"/"+url.PathEscape(name),
+		&object,
+	)
+	if resp != nil && resp.StatusCode == nethttp.StatusNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !oq.matches(object) {
+		return nil, nil
+	}
+
+	return kp.toEntity(object, oq.kind, r.apiVersion, tags), nil
+}
+
+// resolveResource returns the API resource for the kind of the query. The
+// resources of common kinds are known and the resources of other kinds are
+// discovered from the API group version of the query.
+func (kp *KubernetesProvider) resolveResource(
+	ctx context.Context,
+	client *nethttp.Client,
+	c *cluster,
+	oq *objectQuery,
+) (
+	*resource,
+	error,
+) {
+	if oq.apiVersion == "" {
+		r, ok := kinds[strings.ToLower(oq.kind)]
+		if !ok {
+			return nil, fmt.Errorf("missing apiVersion for kubernetes kind %s", oq.kind)
+		}
+
+		return &r, nil
+	}
+
+	var resp resourceListResponse
+
+	if _, err := kp.get(ctx, client, c.server+apiPath(oq.apiVersion), &resp); err != nil {
+		return nil, fmt.Errorf(
+			"failed to discover resources of %s: %v",
+			oq.apiVersion,
+			err,
+		)
+	}
+
+	for _, item := range resp.Resources {
+		// Subresources, such as deployments/scale, have the kind of the
+		// object they belong to
+		if strings.EqualFold(item.Kind, oq.kind) && !strings.Contains(item.Name, "/") {
+			return &resource{oq.apiVersion, item.Name, item.Namespaced}, nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"unknown kubernetes kind %s in %s",
+		oq.kind,
+		oq.apiVersion,
+	)
+}
+
+// apiPath returns the path of an API group version, /api/v1 for the core
+// group and /apis/<group>/<version> otherwise.
+func apiPath(apiVersion string) string {
+	if apiVersion == "v1" {
+		return "/api/v1"
+	}
+
+	return "/apis/" + apiVersion
+}
+
+// resourceUrl returns the URL of the objects of a resource in the given
+// namespace, or in all namespaces if the namespace is empty.
+func (c *cluster) resourceUrl(r *resource, namespace string) string {
+	u := c.server + apiPath(r.apiVersion)
+
+	if r.namespaced && namespace != "" {
+		u += "/namespaces/" + url.PathEscape(namespace)
+	}
+
+	return u + "/" + r.name
+}
+
+// get fetches the JSON document at the given URL and decodes it into the given
+// value. The response is returned so that its status can be inspected.
+func (kp *KubernetesProvider) get(
+	ctx context.Context,
+	client *nethttp.Client,
+	u string,
+	v interface{},
+) (
+	*nethttp.Response,
+	error,
+) {
+	kp.Interop.Logger.Debugf("making kubernetes request using URL %s...", u)
+
+	req, err := nethttp.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+
+	metrics.ProviderRequestDuration.WithLabelValues("kubernetes").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusOK {
+		return resp, fmt.Errorf("kubernetes request failed: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp, fmt.Errorf("invalid JSON response: %v", err)
+	}
+
+	return resp, nil
+}
+
+// apiIterator fetches one page of objects per call to Next, from each of the
+// namespaces of the query in turn.
+type apiIterator struct {
+	kp         *KubernetesProvider
+	query      *objectQuery
+	client     *nethttp.Client
+	cluster    *cluster
+	resource   *resource
+	namespaces []string
+	tags       []string
+	cursor     string
+}
+
+func (it *apiIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if len(it.namespaces) == 0 {
+		return nil, io.EOF
+	}
+
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(it.kp.PageSize))
+
+	if it.query.labelSelector != "" {
+		params.Set("labelSelector", it.query.labelSelector)
+	}
+
+	if it.cursor != "" {
+		params.Set("continue", it.cursor)
+	}
+
+	var resp listResponse
+
+	if _, err := it.kp.get(
+		ctx,
+		it.client,
+		it.cluster.resourceUrl(it.resource, it.namespaces[0])+"?"+params.Encode(),
+		&resp,
+	); err != nil {
+		it.namespaces = nil
+		return nil, err
+	}
+
+	entities := []provider.Entity{}
+
+	for _, item := range resp.Items {
+		if !it.query.matches(item) {
+			continue
+		}
+
+		entity := it.kp.toEntity(item, it.query.kind, it.resource.apiVersion, it.tags)
+		if entity != nil {
+			entities = append(entities, *entity)
+		}
+	}
+
+	if next := resp.Metadata.Continue; next != "" && next != it.cursor {
+		it.cursor = next
+	} else {
+		it.cursor = ""
+		it.namespaces = it.namespaces[1:]
+	}
+
+	return entities, nil
+}
+
+func (it *apiIterator) Close() error {
+	it.namespaces = nil
+	return nil
+}
@@ -0,0 +1,391 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
+	"gopkg.in/yaml.v3"
+)
+
+// EXEC_CREDENTIAL_API_VERSION is the version of the ExecCredential sent to
+// exec credential plugins that do not specify one.
+const EXEC_CREDENTIAL_API_VERSION = "client.authentication.k8s.io/v1"
+
+// kubeconfig is the subset of a kubeconfig file read by the provider.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string      `yaml:"name"`
+		Cluster kubeCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User kubeUser `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string      `yaml:"name"`
+		Context kubeContext `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type kubeUser struct {
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  *kubeExec   `yaml:"exec"`
+	AuthProvider          interface{} `yaml:"auth-provider"`
+}
+
+type kubeContext struct {
+	Cluster string `yaml:"cluster"`
+	User    string `yaml:"user"`
+}
+
+// kubeExec is the configuration of an exec credential plugin, such as
+// aws eks get-token or gke-gcloud-auth-plugin.
+type kubeExec struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// execCredential is the subset of the ExecCredential returned by an exec
+// credential plugin read by the provider.
+type execCredential struct {
+	Status struct {
+		Token               string     `json:"token"`
+		ExpirationTimestamp *time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+// cluster is the API server and credentials of a kubeconfig context.
+type cluster struct {
+	server       string
+	clientConfig *httpclient.Config
+	exec         *kubeExec
+	dir          string
+}
+
+// kubeconfigPaths returns the kubeconfig files to read, in the same order of
+// precedence as kubectl.
+func kubeconfigPaths(path string) []string {
+	if path != "" {
+		return []string{path}
+	}
+
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return []string{}
+	}
+
+	return []string{filepath.Join(home, ".kube", "config")}
+}
+
+// loadCluster reads the API server and credentials of the given context, or of
+// the current context if none is given, from the given kubeconfig files. As
+// with kubectl, the first file to define a cluster, user, context or current
+// context wins. Relative paths of certificates, keys and commands are resolved
+// against the directory of the file that defines them.
+func loadCluster(paths []string, contextName string) (*cluster, error) {
+	clusters := map[string]*kubeCluster{}
+	users := map[string]*kubeUser{}
+	contexts := map[string]*kubeContext{}
+	clusterDirs := map[*kubeCluster]string{}
+	userDirs := map[*kubeUser]string{}
+	currentContext := ""
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) && len(paths) > 1 {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig: %v", err)
+		}
+
+		config := &kubeconfig{}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %v", path, err)
+		}
+
+		dir := filepath.Dir(path)
+
+		for index := range config.Clusters {
+			item := &config.Clusters[index]
+			if _, ok := clusters[item.Name]; !ok {
+				clusters[item.Name] = &item.Cluster
+				clusterDirs[&item.Cluster] = dir
+			}
+		}
+
+		for index := range config.Users {
+			item := &config.Users[index]
+			if _, ok := users[item.Name]; !ok {
+				users[item.Name] = &item.User
+				userDirs[&item.User] = dir
+			}
+		}
+
+		for index := range config.Contexts {
+			item := &config.Contexts[index]
+			if _, ok := contexts[item.Name]; !ok {
+				contexts[item.Name] = &item.Context
+			}
+		}
+
+		if currentContext == "" {
+			currentContext = config.CurrentContext
+		}
+	}
+
+	if contextName == "" {
+		contextName = currentContext
+	}
+
+	if contextName == "" {
+		return nil, fmt.Errorf("missing kubeconfig context")
+	}
+
+	contextConfig, ok := contexts[contextName]
+	if !ok {
+		return nil, fmt.Errorf("unknown kubeconfig context %s", contextName)
+	}
+
+	clusterConfig, ok := clusters[contextConfig.Cluster]
+	if !ok || clusterConfig.Server == "" {
+		return nil, fmt.Errorf("unknown kubeconfig cluster %s", contextConfig.Cluster)
+	}
+
+	// Contexts without a user connect anonymously
+	user := &kubeUser{}
+	if contextConfig.User != "" {
+		if user, ok = users[contextConfig.User]; !ok {
+			return nil, fmt.Errorf("unknown kubeconfig user %s", contextConfig.User)
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(
+		clusterConfig,
+		clusterDirs[clusterConfig],
+		user,
+		userDirs[user],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cluster{
+		server: strings.TrimRight(clusterConfig.Server, "/"),
+		clientConfig: &httpclient.Config{
+			AuthType:  httpclient.AUTH_TYPE_NONE,
+			TLSConfig: tlsConfig,
+		},
+		dir: userDirs[user],
+	}
+
+	switch {
+	case user.AuthProvider != nil:
+		return nil, fmt.Errorf(
+			"unsupported kubeconfig auth-provider for user %s; use an exec credential plugin",
+			contextConfig.User,
+		)
+
+	case user.Exec != nil:
+		c.clientConfig.AuthType = httpclient.AUTH_TYPE_BEARER
+		c.exec = user.Exec
+
+	case user.Token != "":
+		c.clientConfig.AuthType = httpclient.AUTH_TYPE_BEARER
+		c.clientConfig.BearerToken = user.Token
+
+	case user.TokenFile != "":
+		token, err := os.ReadFile(resolvePath(userDirs[user], user.TokenFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig token file: %v", err)
+		}
+
+		c.clientConfig.AuthType = httpclient.AUTH_TYPE_BEARER
+		c.clientConfig.BearerToken = strings.TrimSpace(string(token))
+
+	case user.Username != "":
+		c.clientConfig.AuthType = httpclient.AUTH_TYPE_BASIC
+		c.clientConfig.Username = user.Username
+		c.clientConfig.Password = user.Password
+	}
+
+	return c, nil
+}
+
+func newTLSConfig(
+	clusterConfig *kubeCluster,
+	clusterDir string,
+	user *kubeUser,
+	userDir string,
+) (
+	*tls.Config,
+	error,
+) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: clusterConfig.InsecureSkipTLSVerify,
+		ServerName:         clusterConfig.TLSServerName,
+	}
+
+	ca, err := readData(
+		clusterConfig.CertificateAuthorityData,
+		clusterConfig.CertificateAuthority,
+		clusterDir,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig certificate authority: %v", err)
+	}
+
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid kubeconfig certificate authority")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	cert, err := readData(user.ClientCertificateData, user.ClientCertificate, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig client certificate: %v", err)
+	}
+
+	key, err := readData(user.ClientKeyData, user.ClientKey, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig client key: %v", err)
+	}
+
+	if cert != nil || key != nil {
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// readData returns the base64 decoded data if it is set, the contents of the
+// file otherwise, or nil if neither is set.
+func readData(data string, file string, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if file != "" {
+		return os.ReadFile(resolvePath(dir, file))
+	}
+
+	return nil, nil
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// execToken runs the exec credential plugin and returns the token it issued
+// and its expiration, if any.
+func (c *cluster) execToken(ctx context.Context) (string, *time.Time, error) {
+	apiVersion := c.exec.APIVersion
+	if apiVersion == "" {
+		apiVersion = EXEC_CREDENTIAL_API_VERSION
+	}
+
+	info, err := json.Marshal(map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ExecCredential",
+		"spec": map[string]interface{}{
+			"interactive": false,
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	// As with kubectl, commands with a path separator are relative to the
+	// kubeconfig file and other commands are looked up in the PATH
+	command := c.exec.Command
+	if strings.ContainsRune(command, filepath.Separator) {
+		command = resolvePath(c.dir, command)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command, c.exec.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(info))
+
+	for _, env := range c.exec.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return "", nil, fmt.Errorf(
+			"exec credential plugin %s failed: %v: %s",
+			c.exec.Command,
+			err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	credential := &execCredential{}
+	if err := json.Unmarshal(stdout.Bytes(), credential); err != nil {
+		return "", nil, fmt.Errorf(
+			"invalid response from exec credential plugin %s: %v",
+			c.exec.Command,
+			err,
+		)
+	}
+
+	if credential.Status.Token == "" {
+		return "", nil, fmt.Errorf(
+			"exec credential plugin %s did not return a token",
+			c.exec.Command,
+		)
+	}
+
+	return credential.Status.Token, credential.Status.ExpirationTimestamp, nil
+}
@@ -0,0 +1,339 @@
+// Package kubernetes implements a provider that reads external entities from
+// Kubernetes objects, either through the Kubernetes API of a kubeconfig
+// context or from exported YAML and JSON manifests.
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 500
+
+	// TOKEN_EXPIRY_MARGIN is how long before their expiration tokens issued by
+	// exec credential plugins are renewed
+	TOKEN_EXPIRY_MARGIN = time.Minute
+)
+
+// resource identifies the API resource of a kind of object.
+type resource struct {
+	apiVersion string
+	name       string
+	namespaced bool
+}
+
+// kinds are the API resources of common kinds of objects, which can be used
+// without an apiVersion. The resources of other kinds are discovered from the
+// API using the apiVersion of the query.
+var kinds = map[string]resource{
+	"namespace":   {"v1", "namespaces", false},
+	"node":        {"v1", "nodes", false},
+	"pod":         {"v1", "pods", true},
+	"service":     {"v1", "services", true},
+	"deployment":  {"apps/v1", "deployments", true},
+	"statefulset": {"apps/v1", "statefulsets", true},
+	"daemonset":   {"apps/v1", "daemonsets", true},
+	"replicaset":  {"apps/v1", "replicasets", true},
+	"job":         {"batch/v1", "jobs", true},
+	"cronjob":     {"batch/v1", "cronjobs", true},
+	"ingress":     {"networking.k8s.io/v1", "ingresses", true},
+}
+
+type KubernetesProvider struct {
+	Interop     *interop.Interop
+	Kubeconfig  string
+	Context     string
+	ClusterName string
+	BaseDir     string
+	PageSize    int
+
+	// The kubeconfig is only read when the API is first used so that
+	// manifests can be read without one
+	lock        sync.Mutex
+	cluster     *cluster
+	token       string
+	tokenExpiry *time.Time
+}
+
+// objectQuery is the parsed extEntityQuery of a mapping.
+type objectQuery struct {
+	kind          string
+	apiVersion    string
+	namespaces    []string
+	labelSelector string
+	selector      labelSelector
+	path          string
+}
+
+func init() {
+	provider.RegisterProviderV2("kubernetes", New)
+	provider.RegisterSchema("kubernetes", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"kubeconfig":  config.String(),
+			"context":     config.String(),
+			"clusterName": config.String(),
+			"baseDir":     config.String(),
+			"pageSize":    config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"kind":          config.String().Require(),
+			"apiVersion":    config.String(),
+			"namespace":     config.ListOf(config.String()),
+			"labelSelector": config.String(),
+			"path":          config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	baseDir := v.GetString("baseDir")
+	if baseDir == "" {
+		// Relative paths are resolved against the directory of the
+		// configuration file, as with the file provider
+		if configFile := i.ConfigFileUsed(); configFile != "" {
+			baseDir = filepath.Dir(configFile)
+		} else {
+			baseDir = "."
+		}
+	}
+
+	pageSize := v.GetInt("pageSize")
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+
+	return &KubernetesProvider{
+		Interop:     i,
+		Kubeconfig:  v.GetString("kubeconfig"),
+		Context:     v.GetString("context"),
+		ClusterName: v.GetString("clusterName"),
+		BaseDir:     baseDir,
+		PageSize:    pageSize,
+	}, nil
+}
+
+func (kp *KubernetesProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		EntityLookup: true,
+	}
+}
+
+func (kp *KubernetesProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator over the objects of the kind of the query.
+// Manifests are read when the query has a path and the Kubernetes API is used
+// otherwise.
+func (kp *KubernetesProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	oq, err := parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oq.path != "" {
+		return kp.manifestEntities(oq, query.Tags)
+	}
+
+	return kp.apiEntities(ctx, oq, query.Tags)
+}
+
+// GetEntity returns the object with the given ID, namespace/name for
+// namespaced objects and name for cluster scoped objects.
+func (kp *KubernetesProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	oq, err := parseQuery(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oq.path != "" {
+		return kp.getManifestEntity(ctx, oq, tags, id)
+	}
+
+	return kp.getApiEntity(ctx, oq, tags, id)
+}
+
+func (kp *KubernetesProvider) Close() error {
+	return nil
+}
+
+func parseQuery(config map[string]interface{}) (*objectQuery, error) {
+	var err error
+
+	oq := &objectQuery{
+		kind:          cast.ToString(config["kind"]),
+		apiVersion:    cast.ToString(config["apiversion"]),
+		namespaces:    cast.ToStringSlice(config["namespace"]),
+		labelSelector: strings.TrimSpace(cast.ToString(config["labelselector"])),
+		path:          cast.ToString(config["path"]),
+	}
+
+	if oq.kind == "" {
+		return nil, fmt.Errorf("missing kubernetes kind")
+	}
+
+	if oq.path != "" {
+		if _, err := filepath.Match(oq.path, ""); err != nil {
+			return nil, fmt.Errorf("invalid manifest path %s: %v", oq.path, err)
+		}
+	} else if _, ok := kinds[strings.ToLower(oq.kind)]; !ok && oq.apiVersion == "" {
+		return nil, fmt.Errorf(
+			"missing apiVersion for kubernetes kind %s",
+			oq.kind,
+		)
+	}
+
+	if oq.selector, err = parseLabelSelector(oq.labelSelector); err != nil {
+		return nil, err
+	}
+
+	return oq, nil
+}
+
+// matches returns true if the object is in one of the namespaces of the query
+// and its labels match the label selector of the query. Objects without a
+// namespace, such as cluster scoped objects, are not filtered by namespace.
+func (oq *objectQuery) matches(object map[string]interface{}) bool {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	namespace := cast.ToString(metadata["namespace"])
+
+	if len(oq.namespaces) > 0 && namespace != "" &&
+		!contains(oq.namespaces, namespace) {
+		return false
+	}
+
+	return oq.selector.matches(cast.ToStringMapString(metadata["labels"]))
+}
+
+// parseId returns the namespace and name of an object ID.
+func parseId(id string) (string, string) {
+	if namespace, name, ok := strings.Cut(id, "/"); ok {
+		return namespace, name
+	}
+
+	return "", id
+}
+
+// client returns an HTTP client for the Kubernetes API and the cluster it
+// connects to. Tokens issued by exec credential plugins are reused until they
+// are about to expire.
+func (kp *KubernetesProvider) client(ctx context.Context) (*http.Client, *cluster, error) {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	if kp.cluster == nil {
+		c, err := loadCluster(
+			kubeconfigPaths(resolvePath(kp.BaseDir, kp.Kubeconfig)),
+			kp.Context,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		kp.cluster = c
+	}
+
+	clientConfig := *kp.cluster.clientConfig
+
+	if kp.cluster.exec != nil {
+		if kp.token == "" ||
+			(kp.tokenExpiry != nil &&
+				time.Now().Add(TOKEN_EXPIRY_MARGIN).After(*kp.tokenExpiry)) {
+			token, expiry, err := kp.cluster.execToken(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			kp.token = token
+			kp.tokenExpiry = expiry
+		}
+
+		clientConfig.BearerToken = kp.token
+	}
+
+	client, err := httpclient.New(ctx, &clientConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, kp.cluster, nil
+}
+
+// toEntity returns the external entity for an object, or nil if it has no
+// name. Labels and annotations are nested under the labels and annotations
+// keys. Label and annotation keys with dots, such as app.kubernetes.io/name,
+// are nested further so that they can be referenced as
+// labels.app.kubernetes.io/name.
+func (kp *KubernetesProvider) toEntity(
+	object map[string]interface{},
+	kind string,
+	apiVersion string,
+	tags []string,
+) *provider.Entity {
+	metadata, _ := object["metadata"].(map[string]interface{})
+
+	name := cast.ToString(metadata["name"])
+	if name == "" {
+		kp.Interop.Logger.Warnf("skipping %s with no name", kind)
+		return nil
+	}
+
+	namespace := cast.ToString(metadata["namespace"])
+
+	id := name
+	if namespace != "" {
+		id = namespace + "/" + name
+	}
+
+	values := map[string]interface{}{
+		"name":        name,
+		"namespace":   namespace,
+		"kind":        kind,
+		"apiVersion":  apiVersion,
+		"uid":         cast.ToString(metadata["uid"]),
+		"clusterName": kp.ClusterName,
+		"labels":      provider.NestKeys(cast.ToStringMap(metadata["labels"])),
+		"annotations": provider.NestKeys(cast.ToStringMap(metadata["annotations"])),
+	}
+
+	// Fields the object does not have are left out rather than set to empty
+	// strings so that the tags they are mapped to are removed
+	for key, value := range values {
+		if value == "" {
+			delete(values, key)
+		}
+	}
+
+	return &provider.Entity{
+		ID:   id,
+		Tags: provider.StringifyValues(provider.SelectFields(values, tags)),
+	}
+}
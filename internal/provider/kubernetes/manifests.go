@@ -0,0 +1,169 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/spf13/cast"
+	"gopkg.in/yaml.v3"
+)
+
+func (kp *KubernetesProvider) manifestEntities(
+	oq *objectQuery,
+	tags []string,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	files, err := provider.FindFiles(kp.BaseDir, oq.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &manifestIterator{
+		kp:    kp,
+		query: oq,
+		files: files,
+		tags:  tags,
+	}, nil
+}
+
+// getManifestEntity scans the manifests for the object with the given ID.
+func (kp *KubernetesProvider) getManifestEntity(
+	ctx context.Context,
+	oq *objectQuery,
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	it, err := kp.manifestEntities(oq, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	defer it.Close()
+
+	for {
+		entities, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range entities {
+			if entities[index].ID == id {
+				return &entities[index], nil
+			}
+		}
+	}
+}
+
+// readManifest returns the objects of a YAML or JSON manifest. Manifests may
+// hold several objects separated by YAML document markers and lists of
+// objects, such as the output of kubectl get -o yaml.
+func readManifest(path string) ([]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	objects := []map[string]interface{}{}
+	decoder := yaml.NewDecoder(f)
+
+	for {
+		var object map[string]interface{}
+
+		err := decoder.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Empty documents are decoded as nil maps
+		if object == nil {
+			continue
+		}
+
+		if strings.HasSuffix(cast.ToString(object["kind"]), "List") {
+			for _, item := range cast.ToSlice(object["items"]) {
+				if itemObject, ok := item.(map[string]interface{}); ok {
+					objects = append(objects, itemObject)
+				}
+			}
+
+			continue
+		}
+
+		objects = append(objects, object)
+	}
+}
+
+// manifestIterator returns the objects of one manifest per call to Next.
+type manifestIterator struct {
+	kp    *KubernetesProvider
+	query *objectQuery
+	files []string
+	tags  []string
+}
+
+func (it *manifestIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	for len(it.files) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		file := it.files[0]
+		it.files = it.files[1:]
+
+		it.kp.Interop.Logger.Debugf("reading kubernetes objects from %s", file)
+
+		objects, err := readManifest(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+
+		entities := []provider.Entity{}
+
+		for _, object := range objects {
+			kind := cast.ToString(object["kind"])
+			apiVersion := cast.ToString(object["apiVersion"])
+
+			if !strings.EqualFold(kind, it.query.kind) ||
+				(it.query.apiVersion != "" && apiVersion != it.query.apiVersion) ||
+				!it.query.matches(object) {
+				continue
+			}
+
+			entity := it.kp.toEntity(object, kind, apiVersion, it.tags)
+			if entity != nil {
+				entities = append(entities, *entity)
+			}
+		}
+
+		if len(entities) > 0 {
+			return entities, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (it *manifestIterator) Close() error {
+	it.files = nil
+	return nil
+}
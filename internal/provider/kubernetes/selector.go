@@ -0,0 +1,171 @@
+package kubernetes
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	OP_EQUALS     = "="
+	OP_NOT_EQUALS = "!="
+	OP_IN         = "in"
+	OP_NOT_IN     = "notin"
+	OP_EXISTS     = "exists"
+	OP_NOT_EXISTS = "!"
+)
+
+var (
+	setRequirementRE = regexp.MustCompile(`^([^\s!=(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+	labelKeyRE       = regexp.MustCompile(`^[^\s!=(),]+$`)
+)
+
+// requirement is one requirement of a label selector.
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+// labelSelector is a parsed label selector in the syntax used by kubectl, for
+// example app=web,tier in (frontend,backend),!canary. Objects match a
+// selector if they meet all of its requirements.
+type labelSelector []requirement
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	selector := labelSelector{}
+
+	for _, term := range splitTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %s: %v", s, err)
+		}
+
+		selector = append(selector, *r)
+	}
+
+	return selector, nil
+}
+
+// splitTerms splits a label selector on the commas that are not within the
+// values of a set requirement.
+func splitTerms(s string) []string {
+	terms := []string{}
+	depth := 0
+	start := 0
+
+	for index, c := range s {
+		switch c {
+		case '(':
+			depth += 1
+
+		case ')':
+			depth -= 1
+
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:index])
+				start = index + 1
+			}
+		}
+	}
+
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (*requirement, error) {
+	if match := setRequirementRE.FindStringSubmatch(term); match != nil {
+		values := []string{}
+
+		for _, value := range strings.Split(match[3], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+
+		return &requirement{match[1], match[2], values}, nil
+	}
+
+	if strings.HasPrefix(term, "!") {
+		key := strings.TrimSpace(term[1:])
+		if !labelKeyRE.MatchString(key) {
+			return nil, fmt.Errorf("invalid requirement %s", term)
+		}
+
+		return &requirement{key, OP_NOT_EXISTS, nil}, nil
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		key, value, ok := strings.Cut(term, op)
+		if !ok {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if !labelKeyRE.MatchString(key) || strings.ContainsAny(value, "!=(), ") {
+			return nil, fmt.Errorf("invalid requirement %s", term)
+		}
+
+		if op == "!=" {
+			return &requirement{key, OP_NOT_EQUALS, []string{value}}, nil
+		}
+
+		return &requirement{key, OP_EQUALS, []string{value}}, nil
+	}
+
+	if !labelKeyRE.MatchString(term) {
+		return nil, fmt.Errorf("invalid requirement %s", term)
+	}
+
+	return &requirement{term, OP_EXISTS, nil}, nil
+}
+
+// matches returns true if the given labels meet all requirements of the
+// selector. As in Kubernetes, != and notin requirements are met by objects
+// without the label.
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+
+		switch r.op {
+		case OP_EQUALS, OP_IN:
+			if !ok || !contains(r.values, value) {
+				return false
+			}
+
+		case OP_NOT_EQUALS, OP_NOT_IN:
+			if ok && contains(r.values, value) {
+				return false
+			}
+
+		case OP_EXISTS:
+			if !ok {
+				return false
+			}
+
+		case OP_NOT_EXISTS:
+			if ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     labelSelector
+		ok       bool
+	}{
+		{"", labelSelector{}, true},
+		{"app=web", labelSelector{{"app", OP_EQUALS, []string{"web"}}}, true},
+		{"app==web", labelSelector{{"app", OP_EQUALS, []string{"web"}}}, true},
+		{"app != web", labelSelector{{"app", OP_NOT_EQUALS, []string{"web"}}}, true},
+		{"app=", labelSelector{{"app", OP_EQUALS, []string{""}}}, true},
+		{"canary", labelSelector{{"canary", OP_EXISTS, nil}}, true},
+		{"!canary", labelSelector{{"canary", OP_NOT_EXISTS, nil}}, true},
+		{"! canary", labelSelector{{"canary", OP_NOT_EXISTS, nil}}, true},
+		{
+			"tier in (frontend, backend)",
+			labelSelector{{"tier", OP_IN, []string{"frontend", "backend"}}},
+			true,
+		},
+		{
+			"tier notin (frontend,backend,)",
+			labelSelector{{"tier", OP_NOT_IN, []string{"frontend", "backend"}}},
+			true,
+		},
+		{"tier in ()", labelSelector{{"tier", OP_IN, []string{}}}, true},
+		{
+			"app=web,tier in (frontend,backend),!canary,env notin (dev),team",
+			labelSelector{
+				{"app", OP_EQUALS, []string{"web"}},
+				{"tier", OP_IN, []string{"frontend", "backend"}},
+				{"canary", OP_NOT_EXISTS, nil},
+				{"env", OP_NOT_IN, []string{"dev"}},
+				{"team", OP_EXISTS, nil},
+			},
+			true,
+		},
+		{
+			"app.kubernetes.io/name=web,",
+			labelSelector{{"app.kubernetes.io/name", OP_EQUALS, []string{"web"}}},
+			true,
+		},
+		{"app===web", nil, false},
+		{"app=web=api", nil, false},
+		{"app=(web)", nil, false},
+		{"=web", nil, false},
+		{"!", nil, false},
+		{"!app=web", nil, false},
+		{"tier in (frontend", nil, false},
+		{"tier in frontend", nil, false},
+		{"app web", nil, false},
+	}
+
+	for _, test := range tests {
+		selector, err := parseLabelSelector(test.selector)
+		if (err == nil) != test.ok {
+			t.Errorf("parseLabelSelector(%q) error = %v; want ok %v", test.selector, err, test.ok)
+			continue
+		}
+
+		if test.ok && !reflect.DeepEqual(selector, test.want) {
+			t.Errorf("parseLabelSelector(%q) = %+v; want %+v", test.selector, selector, test.want)
+		}
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend"}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"app=web", true},
+		{"app==api", false},
+		{"app!=api", true},
+		{"env!=prod", true},
+		{"tier in (frontend,backend)", true},
+		{"tier notin (frontend,backend)", false},
+		{"env notin (prod)", true},
+		{"env in (prod)", false},
+		{"app", true},
+		{"!app", false},
+		{"!canary", true},
+		{"app=web,tier in (backend)", false},
+	}
+
+	for _, test := range tests {
+		selector, err := parseLabelSelector(test.selector)
+		if err != nil {
+			t.Errorf("parseLabelSelector(%q) failed: %v", test.selector, err)
+			continue
+		}
+
+		if got := selector.matches(labels); got != test.want {
+			t.Errorf("matches(%q) = %v; want %v", test.selector, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package newrelic implements a provider that reads external entities from a
+// New Relic entity search so that tags can be propagated from New Relic
+// entities to other New Relic entities, including entities in other accounts.
+package newrelic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	nrClient "github.com/newrelic/newrelic-client-go/newrelic"
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/nerdgraph"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/viper"
+)
+
+type NewRelicProvider struct {
+	Interop *interop.Interop
+	Profile string
+}
+
+func init() {
+	provider.RegisterProviderV2("newrelic", New)
+	provider.RegisterSchema("newrelic", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"profile": config.String(),
+		},
+		Query: map[string]*config.Node{
+			"type":      config.ListOf(config.String()),
+			"domain":    config.ListOf(config.String()),
+			"name":      config.String(),
+			"accountId": config.Int(),
+			"tags": config.ListOf(config.Object(map[string]*config.Node{
+				"key":    config.String().Require(),
+				"values": config.ListOf(config.String()).Require(),
+			})),
+			"query":   config.String(),
+			"profile": config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	profile := v.GetString("profile")
+	if profile != "" && !i.HasProfile(profile) {
+		return nil, fmt.Errorf("unknown New Relic profile %s", profile)
+	}
+
+	return &NewRelicProvider{
+		Interop: i,
+		Profile: profile,
+	}, nil
+}
+
+func (np *NewRelicProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		EntityLookup: true,
+	}
+}
+
+func (np *NewRelicProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := np.parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator that fetches one page of entity search results
+// per call to Next.
+func (np *NewRelicProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	entityQuery, err := np.parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := np.Interop.Client(entityQuery.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entityIterator{
+		np:     np,
+		client: client,
+		query:  nerdgraph.BuildQuery(entityQuery),
+		tags:   query.Tags,
+	}, nil
+}
+
+// GetEntity fetches the New Relic entity with the given GUID. The entity query
+// criteria are only used to select the New Relic profile.
+func (np *NewRelicProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	entityQuery, err := np.parseQuery(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := np.Interop.Client(entityQuery.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	entities, err := nerdgraph.GetEntities(ctx, client, []string{id})
+
+	metrics.ProviderRequestDuration.WithLabelValues("newrelic").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("graphql error fetching entity %s: %v", id, err)
+	}
+
+	if len(entities) == 0 {
+		return nil, nil
+	}
+
+	return toEntity(&entities[0], tags), nil
+}
+
+func (np *NewRelicProvider) Close() error {
+	return nil
+}
+
+// parseQuery reads the entity query criteria from the extEntityQuery of a
+// mapping. The criteria are the same as those of the entityQuery of a mapping.
+func (np *NewRelicProvider) parseQuery(
+	config map[string]interface{},
+) (
+	*nerdgraph.EntityQuery,
+	error,
+) {
+	entityQuery := &nerdgraph.EntityQuery{}
+
+	if err := mapstructure.WeakDecode(config, entityQuery); err != nil {
+		return nil, fmt.Errorf("invalid New Relic entity query: %v", err)
+	}
+
+	if nerdgraph.BuildQuery(entityQuery) == "" {
+		return nil, fmt.Errorf("missing New Relic entity query criteria")
+	}
+
+	if entityQuery.Profile == "" {
+		entityQuery.Profile = np.Profile
+	}
+
+	if entityQuery.Profile != "" && !np.Interop.HasProfile(entityQuery.Profile) {
+		return nil, fmt.Errorf("unknown New Relic profile %s", entityQuery.Profile)
+	}
+
+	return entityQuery, nil
+}
+
+// toEntity returns the external entity for a New Relic entity. The entity
+// fields are top-level keys and the first value of each tag is a key of the
+// nested tags field. Tag keys with dots, such as aws.region, are nested
+// further so that they can be referenced as tags.aws.region.
+func toEntity(entity *nerdgraph.EntityOutline, tags []string) *provider.Entity {
+	entityTags := map[string]interface{}{}
+
+	for _, tag := range entity.Tags {
+		if len(tag.Values) == 0 {
+			continue
+		}
+
+		setNested(entityTags, strings.Split(tag.Key, "."), tag.Values[0])
+	}
+
+	values := map[string]interface{}{
+		"guid":       string(entity.Guid),
+		"name":       entity.Name,
+		"accountId":  strconv.Itoa(entity.AccountID),
+		"domain":     entity.Domain,
+		"type":       entity.Type,
+		"entityType": string(entity.EntityType),
+		"tags":       entityTags,
+	}
+
+	return &provider.Entity{
+		ID:   string(entity.Guid),
+		Tags: provider.SelectFields(values, tags),
+	}
+}
+
+// setNested sets the value at the given path. Values that conflict with an
+// existing value, such as a tag a.b when a tag a exists, are ignored.
+func setNested(m map[string]interface{}, path []string, value string) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := m[key]
+		if !ok {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+
+		childMap, ok := child.(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		m = childMap
+	}
+
+	if _, ok := m[path[len(path)-1]]; !ok {
+		m[path[len(path)-1]] = value
+	}
+}
+
+type entityIterator struct {
+	np     *NewRelicProvider
+	client *nrClient.NewRelic
+	query  string
+	tags   []string
+	cursor string
+	done   bool
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	it.np.Interop.Logger.Debugf(
+		"fetching New Relic entities for external entity query: \"%s\"",
+		it.query,
+	)
+
+	start := time.Now()
+	resp, err := nerdgraph.SearchEntities(
+		ctx,
+		it.np.Interop,
+		it.client,
+		it.query,
+		it.cursor,
+	)
+
+	metrics.ProviderRequestDuration.WithLabelValues("newrelic").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		it.done = true
+		return nil, fmt.Errorf("graphql error fetching entities: %s", err)
+	}
+
+	results := resp.Actor.EntitySearch.Results
+	entities := make([]provider.Entity, 0, len(results.Entities))
+
+	for index := range results.Entities {
+		entities = append(entities, *toEntity(&results.Entities[index], it.tags))
+	}
+
+	it.cursor = results.NextCursor
+	it.done = it.cursor == ""
+
+	return entities, nil
+}
+
+func (it *entityIterator) Close() error {
+	it.done = true
+	return nil
+}
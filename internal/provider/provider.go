@@ -1,11 +1,18 @@
 package provider
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
 	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
@@ -14,6 +21,9 @@ type Entity struct {
   Tags      map[string]interface{}
 }
 
+// Provider is the original provider interface. It returns all external
+// entities at once. Providers implementing it are adapted to ProviderV2 when
+// they are initialized.
 type Provider interface {
   GetEntities(
     config          map[string]interface{},
@@ -22,44 +32,381 @@ type Provider interface {
   ) ([]Entity, error)
 }
 
+// ContextProvider is an optional interface that providers can implement to
+// receive the context of the current sync when fetching external entities. The
+// context carries the New Relic transaction for the sync so that calls made by
+// the provider can be instrumented.
+type ContextProvider interface {
+  GetEntitiesWithContext(
+    ctx             context.Context,
+    config          map[string]interface{},
+    tags            []string,
+    lastUpdate      *time.Time,
+  ) ([]Entity, error)
+}
+
+// EntityGetter is an optional interface that providers can implement to fetch
+// a single external entity by ID. It is used to process change notifications
+// for individual external entities. GetEntity returns nil if no external
+// entity with the given ID matches the given configuration.
+type EntityGetter interface {
+  GetEntity(
+    ctx             context.Context,
+    config          map[string]interface{},
+    tags            []string,
+    id              string,
+  ) (*Entity, error)
+}
+
+// PageCounter is an optional interface that v1 providers and entity iterators
+// which fetch external entities in pages can implement to report the number of
+// pages fetched by the most recent call to GetEntities or by the iterator.
+type PageCounter interface {
+  PageCount() int
+}
+
+// Capabilities describes the optional features supported by a ProviderV2.
+type Capabilities struct {
+  // Delta is true if the provider uses the last update time of the query to
+  // return only the external entities changed since the last sync
+  Delta             bool
+  // WriteBack is true if the provider implements EntityWriter
+  WriteBack         bool
+  // EntityLookup is true if the provider implements EntityGetter
+  EntityLookup      bool
+}
+
+// Query is a request for the external entities matching the extEntityQuery
+// of a mapping. LastUpdate is nil unless delta synchronization is used.
+type Query struct {
+  Config            map[string]interface{}
+  Tags              []string
+  LastUpdate        *time.Time
+}
+
+// EntityIterator streams the pages of external entities returned for a
+// query. Next returns io.EOF once all pages have been returned. Close must be
+// called once the iterator is no longer used, whether or not it was read to the
+// end. An iterator may implement PageCounter to report the number of pages
+// fetched from the external system.
+type EntityIterator interface {
+  Next(ctx context.Context) ([]Entity, error)
+  Close() error
+}
+
+// ProviderV2 is the context-aware provider interface. External entities are
+// streamed page by page so that providers do not have to hold the whole result
+// set in memory. ValidateQuery checks the extEntityQuery of a mapping before
+// it is run, in addition to the checks of the registered Schema. Close
+// releases the resources of the provider when it is no longer used, either
+// because the configuration was reloaded or because the process is exiting.
+type ProviderV2 interface {
+  Capabilities() Capabilities
+  ValidateQuery(config map[string]interface{}) error
+  Entities(ctx context.Context, query *Query) (EntityIterator, error)
+  Close() error
+}
+
+// EntityWriter is an optional interface that providers which can write tags
+// back to the external system implement. Providers that implement it report
+// the WriteBack capability. The sync does not write to external systems yet.
+type EntityWriter interface {
+  WriteTags(
+    ctx             context.Context,
+    config          map[string]interface{},
+    id              string,
+    tags            map[string]interface{},
+  ) error
+}
+
+// Schema describes the configuration accepted by a provider and is used to
+// validate the configuration before the provider is initialized.
+type Schema struct {
+  // Provider describes the provider specific keys of the provider section
+  Provider          map[string]*config.Node
+  // Query describes the keys of the extEntityQuery section of each mapping
+  Query             map[string]*config.Node
+}
+
 type InitFn func (*interop.Interop, *viper.Viper) (Provider, error)
 
+type InitFnV2 func (*interop.Interop, *viper.Viper) (ProviderV2, error)
+
 var (
-  initFns map[string]InitFn
+  initFns map[string]InitFnV2
+  schemas map[string]*Schema
   providerLock sync.Mutex
 )
 
-func GetProvider(i *interop.Interop) (Provider, error) {
-  if !viper.IsSet("provider") {
+func GetProvider(i *interop.Interop) (ProviderV2, error) {
+  if !i.Config.IsSet("provider") {
     return nil, fmt.Errorf("missing provider in config")
   }
 
-  providerType := viper.GetString("provider.type")
+  providerType := i.Config.GetString("provider.type")
   if providerType == "" {
     return nil, fmt.Errorf("missing provider type")
   }
 
+  return NewProvider(i, providerType, i.Config.Sub("provider"))
+}
+
+// NewProvider initializes a provider of the given type using the given
+// provider configuration.
+func NewProvider(
+  i                 *interop.Interop,
+  providerType      string,
+  v                 *viper.Viper,
+) (ProviderV2, error) {
   i.Logger.Debugf("getting provider for type %s...", providerType)
 
   providerLock.Lock()
-  defer providerLock.Unlock()
-
   fn, ok := initFns[providerType]
+  providerLock.Unlock()
+
   if !ok {
     return nil, fmt.Errorf("invalid provider: %s", providerType)
   }
 
   i.Logger.Debugf("initializing provider...")
-  return fn(i, viper.Sub("provider"))
+  return fn(i, v)
+}
+
+// GetEntities reads all pages of the external entities returned by the given
+// provider for the given query.
+func GetEntities(
+  ctx               context.Context,
+  p                 ProviderV2,
+  query             *Query,
+) ([]Entity, error) {
+  it, err := p.Entities(ctx, query)
+  if err != nil {
+    return nil, err
+  }
+
+  defer it.Close()
+
+  entities := []Entity{}
+
+  for {
+    page, err := it.Next(ctx)
+    if err == io.EOF {
+      return entities, nil
+    }
+
+    if err != nil {
+      return nil, err
+    }
+
+    entities = append(entities, page...)
+  }
 }
 
+// RegisterProvider registers a provider implementing the original Provider
+// interface. The provider is adapted to ProviderV2 when it is initialized.
 func RegisterProvider(t string, initFn InitFn) {
+  RegisterProviderV2(t, func (
+    i               *interop.Interop,
+    v               *viper.Viper,
+  ) (ProviderV2, error) {
+    p, err := initFn(i, v)
+    if err != nil {
+      return nil, err
+    }
+
+    return FromV1(p), nil
+  })
+}
+
+func RegisterProviderV2(t string, initFn InitFnV2) {
   providerLock.Lock()
   defer providerLock.Unlock()
 
   if initFns == nil {
-    initFns = make(map[string]InitFn)
+    initFns = make(map[string]InitFnV2)
   }
 
   initFns[t] = initFn
 }
+
+// RegisteredProviders returns the sorted list of registered provider types.
+func RegisteredProviders() []string {
+  providerLock.Lock()
+  defer providerLock.Unlock()
+
+  types := make([]string, 0, len(initFns))
+  for t := range initFns {
+    types = append(types, t)
+  }
+
+  sort.Strings(types)
+
+  return types
+}
+
+// RegisterSchema registers the configuration schema for a provider type.
+func RegisterSchema(t string, schema *Schema) {
+  providerLock.Lock()
+  defer providerLock.Unlock()
+
+  if schemas == nil {
+    schemas = make(map[string]*Schema)
+  }
+
+  schemas[t] = schema
+}
+
+// GetSchema returns the configuration schema for a provider type, if one was
+// registered.
+func GetSchema(t string) (*Schema, bool) {
+  providerLock.Lock()
+  defer providerLock.Unlock()
+
+  schema, ok := schemas[t]
+
+  return schema, ok
+}
+
+// SelectFields returns the top level fields of the given key-value pairs that
+// are referenced by the given tags, which may be dot paths to nested fields.
+// Fields are found as with FindField and keep their original names.
+func SelectFields(
+  values            map[string]interface{},
+  tags              []string,
+) map[string]interface{} {
+  selected := map[string]interface{}{}
+
+  for _, tag := range tags {
+    field := tag
+    if index := strings.Index(tag, "."); index > 0 {
+      field = tag[0:index]
+    }
+
+    if name, value, ok := FindField(values, field); ok {
+      selected[name] = value
+    }
+  }
+
+  return selected
+}
+
+// FindField returns the name and value of the field with the given name. Keys
+// read from the configuration, such as the keys of a mapping, are lowercase,
+// so if there is no field with the exact name, the field with the same name
+// ignoring case is returned. If several fields have the same name ignoring
+// case, the first in sorted order is returned.
+func FindField(
+  values            map[string]interface{},
+  name              string,
+) (string, interface{}, bool) {
+  if value, ok := values[name]; ok {
+    return name, value, true
+  }
+
+  found := ""
+  ok := false
+
+  for key := range values {
+    if strings.EqualFold(key, name) && (!ok || key < found) {
+      found = key
+      ok = true
+    }
+  }
+
+  if !ok {
+    return "", nil, false
+  }
+
+  return found, values[found], true
+}
+
+// StringifyValues converts the scalar values of the given key-value pairs,
+// such as numbers, booleans and timestamps, to strings so that they can be used
+// as match keys and tag values. Nested key-value pairs are converted
+// recursively.
+func StringifyValues(values map[string]interface{}) map[string]interface{} {
+  for key, value := range values {
+    switch v := value.(type) {
+    case nil, string, []interface{}:
+
+    case map[string]interface{}:
+      values[key] = StringifyValues(v)
+
+    case time.Time:
+      values[key] = v.Format(time.RFC3339)
+
+    default:
+      values[key] = cast.ToString(v)
+    }
+  }
+
+  return values
+}
+
+// NestKeys returns the given key-value pairs with keys split on dots into
+// nested key-value pairs, so that keys with dots, such as the annotation
+// backstage.io/techdocs-ref, can be referenced with the same dot paths as
+// nested fields. Values that conflict with an existing value, such as a key
+// a.b when a key a exists, are ignored. Keys are processed in lexical order so
+// that the same value is ignored on every run.
+func NestKeys(values map[string]interface{}) map[string]interface{} {
+  nested := map[string]interface{}{}
+
+  keys := make([]string, 0, len(values))
+  for key := range values {
+    keys = append(keys, key)
+  }
+
+  sort.Strings(keys)
+
+  for _, key := range keys {
+    m := nested
+    parts := strings.Split(key, ".")
+
+    for _, part := range parts[:len(parts)-1] {
+      child, ok := m[part]
+      if !ok {
+        child = map[string]interface{}{}
+        m[part] = child
+      }
+
+      childMap, ok := child.(map[string]interface{})
+      if !ok {
+        m = nil
+        break
+      }
+
+      m = childMap
+    }
+
+    if m == nil {
+      continue
+    }
+
+    if _, ok := m[parts[len(parts)-1]]; !ok {
+      m[parts[len(parts)-1]] = values[key]
+    }
+  }
+
+  return nested
+}
+
+// FindFiles returns the files matching the given glob pattern. Relative
+// patterns are resolved against the given base directory, such as the
+// directory of the configuration file. An error is returned if no files match.
+func FindFiles(baseDir string, pattern string) ([]string, error) {
+  if !filepath.IsAbs(pattern) {
+    pattern = filepath.Join(baseDir, pattern)
+  }
+
+  files, err := filepath.Glob(pattern)
+  if err != nil {
+    return nil, fmt.Errorf("invalid path %s: %v", pattern, err)
+  }
+
+  if len(files) == 0 {
+    return nil, fmt.Errorf("no files match path %s", pattern)
+  }
+
+  return files, nil
+}
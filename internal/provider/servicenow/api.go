@@ -9,9 +9,11 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/clientcredentials"
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider/httpclient"
 )
 
 type Records struct {
@@ -27,6 +29,7 @@ func init() {
 }
 
 func (snp *ServiceNowProvider) getPaginatedResults(
+	ctx context.Context,
 	client *http.Client,
 	url string,
 	result interface{},
@@ -36,19 +39,21 @@ func (snp *ServiceNowProvider) getPaginatedResults(
 		url,
 	)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
 
-	if snp.AuthType == AUTH_TYPE_BASIC {
-		req.SetBasicAuth(snp.ApiUser, snp.ApiPassword)
-	}
-
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
+	start := time.Now()
 	resp, err := client.Do(req)
+
+	metrics.ProviderRequestDuration.WithLabelValues("servicenow").Observe(
+		time.Since(start).Seconds(),
+	)
+
 	if err != nil {
 		return "", err
 	}
@@ -87,24 +92,30 @@ func (snp *ServiceNowProvider) getPaginatedResults(
 	return "", nil
 }
 
-func (snp *ServiceNowProvider) getRecords(
+// recordIterator fetches the records of a table one page at a time, following
+// the next links returned by the table API.
+type recordIterator struct {
+	snp       *ServiceNowProvider
+	client    *http.Client
+	url       string
+	pageCount int
+}
+
+func (snp *ServiceNowProvider) newRecordIterator(
+	ctx context.Context,
 	tableName string,
 	query string,
 	urlQueryParams map[string]string,
 	fields []string,
 ) (
-	[]map[string]interface{},
+	*recordIterator,
 	error,
 ) {
-	var results []map[string]interface{}
-
-	client, err := snp.createHttpClient()
+	client, err := snp.createHttpClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	done := false
-
 	sysparmQuery := ""
 	if query != "" {
 		sysparmQuery = "&sysparm_query=" + url.QueryEscape(query)
@@ -120,70 +131,49 @@ func (snp *ServiceNowProvider) getRecords(
 		sysparmQuery,
 	)
 
-	for !done {
-		records := &Records{}
-
-		nextUrl, err := snp.getPaginatedResults(client, url, records)
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, records.Result...)
-
-		if nextUrl == "" {
-			done = true
-			continue
-		}
+	return &recordIterator{snp: snp, client: client, url: url}, nil
+}
 
-		url = nextUrl
+func (it *recordIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.url == "" {
+		return nil, io.EOF
 	}
 
-	return results, nil
-}
+	records := &Records{}
 
-func (snp *ServiceNowProvider) createHttpClient() (*http.Client, error) {
-	if snp.AuthType == AUTH_TYPE_OAUTH {
-		ctx := context.TODO()
-
-		if snp.OAuthGrantType == OAUTH_GRANT_TYPE_PASSWORD {
-			endpointParams := url.Values{}
-			endpointParams.Add("username", snp.ApiUser)
-			endpointParams.Add("password", snp.ApiPassword)
-
-			oauthConfig := &oauth2.Config{
-				ClientID:     snp.OAuthClientID,
-				ClientSecret: snp.OAuthClientSecret,
-				Endpoint: oauth2.Endpoint{
-					AuthURL:   "",
-					TokenURL:  snp.OAuthTokenURL,
-					AuthStyle: oauth2.AuthStyleAutoDetect,
-				},
-				Scopes: snp.OAuthScopes,
-			}
+	nextUrl, err := it.snp.getPaginatedResults(ctx, it.client, it.url, records)
+	if err != nil {
+		return nil, err
+	}
 
-			token, err := oauthConfig.PasswordCredentialsToken(
-				ctx,
-				snp.ApiUser,
-				snp.ApiPassword,
-			)
-			if err != nil {
-				return nil, err
-			}
+	it.url = nextUrl
+	it.pageCount += 1
 
-			return oauthConfig.Client(ctx, token), nil
-		}
+	return it.snp.toEntities(records.Result), nil
+}
 
-		oauthConfig := &clientcredentials.Config{
-			ClientID:     snp.OAuthClientID,
-			ClientSecret: snp.OAuthClientSecret,
-			TokenURL:     snp.OAuthTokenURL,
-			Scopes:       snp.OAuthScopes,
-		}
+func (it *recordIterator) Close() error {
+	it.url = ""
+	return nil
+}
 
-		return oauthConfig.Client(ctx), nil
-	}
+func (it *recordIterator) PageCount() int {
+	return it.pageCount
+}
 
-	return &http.Client{}, nil
+func (snp *ServiceNowProvider) createHttpClient(
+	ctx context.Context,
+) (*http.Client, error) {
+	return httpclient.New(ctx, &httpclient.Config{
+		AuthType:          httpclient.AuthType(snp.AuthType),
+		Username:          snp.ApiUser,
+		Password:          snp.ApiPassword,
+		OAuthTokenURL:     snp.OAuthTokenURL,
+		OAuthClientID:     snp.OAuthClientID,
+		OAuthClientSecret: snp.OAuthClientSecret,
+		OAuthGrantType:    httpclient.OAuthGrantType(snp.OAuthGrantType),
+		OAuthScopes:       snp.OAuthScopes,
+	})
 }
 
 func buildUrlQueryParamString(urlQueryParams map[string]string) string {
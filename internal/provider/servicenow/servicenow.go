@@ -1,11 +1,13 @@
 package servicenow
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
 	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
 	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
 	"github.com/spf13/cast"
@@ -37,17 +39,45 @@ type ServiceNowProvider struct {
 }
 
 var (
-	dateRE *regexp.Regexp
-	timeRE *regexp.Regexp
+	dateRE  *regexp.Regexp
+	timeRE  *regexp.Regexp
+	sysIdRE *regexp.Regexp
 )
 
 func init() {
-	provider.RegisterProvider("servicenow", New)
+	provider.RegisterProviderV2("servicenow", New)
+	provider.RegisterSchema("servicenow", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"apiUrl":      config.String(),
+			"apiUser":     config.String(),
+			"apiPassword": config.String(),
+			"authType": config.String().OneOf(
+				string(AUTH_TYPE_BASIC),
+				string(AUTH_TYPE_OAUTH),
+			),
+			"oauthTokenUrl": config.String(),
+			"oauthGrantType": config.String().OneOf(
+				string(OAUTH_GRANT_TYPE_PASSWORD),
+				string(OAUTH_GRANT_TYPE_CLIENT_CREDENTIALS),
+			),
+			"oauthClientId":     config.String(),
+			"oauthClientSecret": config.String(),
+			"oauthClientScopes": config.ListOf(config.String()),
+			"pageSize":          config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"type":           config.String().Require(),
+			"query":          config.String(),
+			"serverTimezone": config.String(),
+			"urlQueryParams": config.MapOf(config.String()),
+		},
+	})
 	dateRE = regexp.MustCompile(`(?i)\${lastUpdateDate}`)
 	timeRE = regexp.MustCompile(`(?i)\${lastUpdateTime}`)
+	sysIdRE = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
 }
 
-func New(i *interop.Interop, v *viper.Viper) (provider.Provider, error) {
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
 	v.AutomaticEnv()
 	v.SetEnvPrefix("NR_CMDB_SNOW")
 
@@ -80,7 +110,7 @@ func New(i *interop.Interop, v *viper.Viper) (provider.Provider, error) {
 	}
 
 	if authType == AUTH_TYPE_BASIC {
-		err := requireUsernamePassword(v, snp)
+		err := requireUsernamePassword(i, v, snp)
 		if err != nil {
 			return nil, err
 		}
@@ -106,7 +136,13 @@ func New(i *interop.Interop, v *viper.Viper) (provider.Provider, error) {
 			return nil, fmt.Errorf("missing servicenow api oauth client ID")
 		}
 
-		oauthClientSecret := v.GetString("oauthClientSecret")
+		// Secret references in environment variables are resolved here since
+		// only the configuration file is resolved at startup
+		oauthClientSecret, err := i.ResolveSecret(v.GetString("oauthClientSecret"))
+		if err != nil {
+			return nil, err
+		}
+
 		if oauthClientSecret == "" {
 			return nil, fmt.Errorf("missing servicenow api oauth secret key")
 		}
@@ -123,7 +159,7 @@ func New(i *interop.Interop, v *viper.Viper) (provider.Provider, error) {
 		snp.OAuthScopes = oauthClientScopes
 
 		if grantType == OAUTH_GRANT_TYPE_PASSWORD {
-			err := requireUsernamePassword(v, snp)
+			err := requireUsernamePassword(i, v, snp)
 			if err != nil {
 				return nil, err
 			}
@@ -133,50 +169,114 @@ func New(i *interop.Interop, v *viper.Viper) (provider.Provider, error) {
 	return snp, nil
 }
 
-func (snp *ServiceNowProvider) GetEntities(
-	config map[string]interface{},
-	tags []string,
-	lastUpdate *time.Time,
+func (snp *ServiceNowProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Delta:        true,
+		EntityLookup: true,
+	}
+}
+
+func (snp *ServiceNowProvider) ValidateQuery(config map[string]interface{}) error {
+	if cast.ToString(config["type"]) == "" {
+		return fmt.Errorf("missing CI type field")
+	}
+
+	if _, err := getServerTimezone(config); err != nil {
+		return fmt.Errorf("invalid server timezone: %v", err)
+	}
+
+	return nil
+}
+
+// Entities returns an iterator that fetches one page of CIs from the table
+// API per call to Next.
+func (snp *ServiceNowProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
 ) (
-	[]provider.Entity,
+	provider.EntityIterator,
 	error,
 ) {
-	ciType := cast.ToString(config["type"])
+	ciType := cast.ToString(query.Config["type"])
 	if ciType == "" {
 		return nil, fmt.Errorf("missing CI type field")
 	}
 
 	var err error
 
-	ciQuery := cast.ToString(config["query"])
-	if ciQuery != "" && lastUpdate != nil {
-		ciQuery, err = subsDateTime(ciQuery, config, lastUpdate)
+	ciQuery := cast.ToString(query.Config["query"])
+	if ciQuery != "" && query.LastUpdate != nil {
+		ciQuery, err = subsDateTime(ciQuery, query.Config, query.LastUpdate)
 		if err != nil {
 			return nil, fmt.Errorf("query datetime substitution failed: %v", err)
 		}
 	}
 
-	var urlQueryParams map[string]string
+	it, err := snp.newRecordIterator(
+		ctx,
+		ciType,
+		ciQuery,
+		getUrlQueryParams(query.Config),
+		getFields(query.Tags),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get records failed: %s", err)
+	}
+
+	return it, nil
+}
 
-	if v, ok := config["urlqueryparams"]; ok {
-		urlQueryParams = cast.ToStringMapString(v)
+func (snp *ServiceNowProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	ciType := cast.ToString(config["type"])
+	if ciType == "" {
+		return nil, fmt.Errorf("missing CI type field")
 	}
 
-	newTags := []string{}
+	// The ID is added to an encoded query, so IDs other than sys_ids could
+	// add conditions that select other CIs
+	if !sysIdRE.MatchString(id) {
+		return nil, fmt.Errorf("invalid sys_id %s", id)
+	}
 
-	for _, tag := range tags {
-		if index := strings.Index(tag, "."); index > 0 {
-			newTags = append(newTags, tag[0:index])
-			continue
-		}
-		newTags = append(newTags, tag)
+	it, err := snp.newRecordIterator(
+		ctx,
+		ciType,
+		"sys_id="+id,
+		getUrlQueryParams(config),
+		getFields(tags),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get records failed: %s", err)
 	}
 
-	items, err := snp.getRecords(ciType, ciQuery, urlQueryParams, newTags)
+	defer it.Close()
+
+	// A sys_id matches at most one record so the first page is sufficient
+	entities, err := it.Next(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get records failed: %s", err)
 	}
 
+	for index := range entities {
+		if strings.EqualFold(entities[index].ID, id) {
+			return &entities[index], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (snp *ServiceNowProvider) toEntities(
+	items []map[string]interface{},
+) []provider.Entity {
 	var entities []provider.Entity
 
 	for _, item := range items {
@@ -198,16 +298,50 @@ func (snp *ServiceNowProvider) GetEntities(
 		)
 	}
 
-	return entities, nil
+	return entities
+}
+
+func (snp *ServiceNowProvider) Close() error {
+	return nil
 }
 
-func requireUsernamePassword(v *viper.Viper, snp *ServiceNowProvider) error {
+func getUrlQueryParams(config map[string]interface{}) map[string]string {
+	if v, ok := config["urlqueryparams"]; ok {
+		return cast.ToStringMapString(v)
+	}
+
+	return nil
+}
+
+func getFields(tags []string) []string {
+	fields := []string{}
+
+	for _, tag := range tags {
+		if index := strings.Index(tag, "."); index > 0 {
+			fields = append(fields, tag[0:index])
+			continue
+		}
+		fields = append(fields, tag)
+	}
+
+	return fields
+}
+
+func requireUsernamePassword(
+	i *interop.Interop,
+	v *viper.Viper,
+	snp *ServiceNowProvider,
+) error {
 	apiUser := v.GetString("apiUser")
 	if apiUser == "" {
 		return fmt.Errorf("missing servicenow api user")
 	}
 
-	apiPassword := v.GetString("apiPassword")
+	apiPassword, err := i.ResolveSecret(v.GetString("apiPassword"))
+	if err != nil {
+		return err
+	}
+
 	if apiPassword == "" {
 		return fmt.Errorf("missing servicenow api password")
 	}
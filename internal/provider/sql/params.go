@@ -0,0 +1,128 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// boundQuery is a query whose named parameters were replaced by the
+// placeholders of a driver.
+type boundQuery struct {
+	text string
+	// names holds the name of the parameter of each placeholder, in order
+	names []string
+}
+
+// bindParams replaces the named parameters in the query, such as :lastUpdate,
+// by the placeholders of the given driver. Parameter names are case
+// insensitive. Quoted strings, quoted identifiers, comments and PostgreSQL
+// casts (::) are left untouched.
+func bindParams(query string, driver string, allowed ...string) (*boundQuery, error) {
+	var sb strings.Builder
+
+	bq := &boundQuery{names: []string{}}
+	positions := map[string]int{}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid sql query: unterminated quote")
+			}
+
+			sb.WriteString(query[i : i+end+2])
+			i += end + 2
+
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+
+			sb.WriteString(query[i : i+end])
+			i += end
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid sql query: unterminated comment")
+			}
+
+			sb.WriteString(query[i : i+end+4])
+			i += end + 4
+
+		case strings.HasPrefix(query[i:], "::"):
+			sb.WriteString("::")
+			i += 2
+
+		case c == ':' && i+1 < len(query) && isIdentStart(query[i+1]):
+			end := i + 1
+			for end < len(query) && isIdentPart(query[end]) {
+				end += 1
+			}
+
+			name, ok := lookupParam(query[i+1:end], allowed)
+			if !ok {
+				return nil, fmt.Errorf("unknown sql query parameter: %s", query[i:end])
+			}
+
+			// PostgreSQL placeholders are numbered so that a parameter used more
+			// than once is bound once. Other drivers bind each placeholder.
+			if driver == DRIVER_POSTGRES {
+				position, ok := positions[name]
+				if !ok {
+					bq.names = append(bq.names, name)
+					position = len(bq.names)
+					positions[name] = position
+				}
+
+				fmt.Fprintf(&sb, "$%d", position)
+			} else {
+				bq.names = append(bq.names, name)
+				sb.WriteByte('?')
+			}
+
+			i = end
+
+		default:
+			sb.WriteByte(c)
+			i += 1
+		}
+	}
+
+	bq.text = sb.String()
+
+	return bq, nil
+}
+
+// args returns the arguments for the placeholders of the query.
+func (bq *boundQuery) args(values map[string]interface{}) []interface{} {
+	args := make([]interface{}, len(bq.names))
+
+	for index, name := range bq.names {
+		args[index] = values[name]
+	}
+
+	return args
+}
+
+func lookupParam(name string, allowed []string) (string, bool) {
+	for _, param := range allowed {
+		if strings.EqualFold(name, param) {
+			return param, true
+		}
+	}
+
+	return "", false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,126 @@
+package sql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindParams(t *testing.T) {
+	tests := []struct {
+		query  string
+		driver string
+		text   string
+		names  []string
+		ok     bool
+	}{
+		{
+			"SELECT * FROM t WHERE updated > :lastUpdate",
+			DRIVER_MYSQL,
+			"SELECT * FROM t WHERE updated > ?",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT * FROM t WHERE updated > :LASTUPDATE",
+			DRIVER_POSTGRES,
+			"SELECT * FROM t WHERE updated > $1",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT * FROM t WHERE a > :lastUpdate OR b > :lastUpdate",
+			DRIVER_POSTGRES,
+			"SELECT * FROM t WHERE a > $1 OR b > $1",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT * FROM t WHERE a > :lastUpdate OR b > :lastUpdate",
+			DRIVER_SQLITE,
+			"SELECT * FROM t WHERE a > ? OR b > ?",
+			[]string{PARAM_LAST_UPDATE, PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT ':lastUpdate', \":lastUpdate\", `:lastUpdate` FROM t",
+			DRIVER_MYSQL,
+			"SELECT ':lastUpdate', \":lastUpdate\", `:lastUpdate` FROM t",
+			[]string{},
+			true,
+		},
+		{
+			"SELECT 'it''s :x' FROM t WHERE a > :lastUpdate",
+			DRIVER_MYSQL,
+			"SELECT 'it''s :x' FROM t WHERE a > ?",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT a -- :x\nFROM t /* :y */ WHERE b > :lastUpdate",
+			DRIVER_SQLITE,
+			"SELECT a -- :x\nFROM t /* :y */ WHERE b > ?",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT a FROM t -- :x",
+			DRIVER_SQLITE,
+			"SELECT a FROM t -- :x",
+			[]string{},
+			true,
+		},
+		{
+			"SELECT a::text FROM t WHERE b > :lastUpdate::timestamp",
+			DRIVER_POSTGRES,
+			"SELECT a::text FROM t WHERE b > $1::timestamp",
+			[]string{PARAM_LAST_UPDATE},
+			true,
+		},
+		{
+			"SELECT a FROM t WHERE b = ':' OR c = :1",
+			DRIVER_MYSQL,
+			"SELECT a FROM t WHERE b = ':' OR c = :1",
+			[]string{},
+			true,
+		},
+		{"SELECT a FROM t WHERE b = :other", DRIVER_MYSQL, "", nil, false},
+		{"SELECT 'a FROM t", DRIVER_MYSQL, "", nil, false},
+		{"SELECT a FROM t /* comment", DRIVER_MYSQL, "", nil, false},
+	}
+
+	for _, test := range tests {
+		bq, err := bindParams(test.query, test.driver, PARAM_LAST_UPDATE)
+		if (err == nil) != test.ok {
+			t.Errorf("bindParams(%q) error = %v; want ok %v", test.query, err, test.ok)
+			continue
+		}
+
+		if !test.ok {
+			continue
+		}
+
+		if bq.text != test.text || !reflect.DeepEqual(bq.names, test.names) {
+			t.Errorf(
+				"bindParams(%q, %s) = %q, %v; want %q, %v",
+				test.query,
+				test.driver,
+				bq.text,
+				bq.names,
+				test.text,
+				test.names,
+			)
+		}
+	}
+}
+
+func TestBoundQueryArgs(t *testing.T) {
+	bq, err := bindParams("SELECT :id, :lastUpdate, :ID", DRIVER_MYSQL, PARAM_LAST_UPDATE, PARAM_ID)
+	if err != nil {
+		t.Fatalf("bindParams failed: %v", err)
+	}
+
+	args := bq.args(map[string]interface{}{PARAM_ID: "a", PARAM_LAST_UPDATE: 1})
+	if want := []interface{}{"a", 1, "a"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args() = %v; want %v", args, want)
+	}
+}
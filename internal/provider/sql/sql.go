@@ -0,0 +1,397 @@
+// Package sql implements a provider that reads external entities from the
+// rows returned by a query against a PostgreSQL, MySQL or SQLite database.
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_MYSQL    = "mysql"
+	DRIVER_SQLITE   = "sqlite"
+
+	PARAM_LAST_UPDATE = "lastUpdate"
+	PARAM_ID          = "id"
+
+	// SQLITE_TIME_FORMAT is the format of the SQLite date and time functions
+	// and of CURRENT_TIMESTAMP
+	SQLITE_TIME_FORMAT = "2006-01-02 15:04:05"
+
+	DEFAULT_ID_COLUMN      = "id"
+	DEFAULT_BATCH_SIZE     = 1000
+	DEFAULT_MAX_OPEN_CONNS = 4
+)
+
+type SqlProvider struct {
+	Interop   *interop.Interop
+	Driver    string
+	DB        *dbsql.DB
+	BatchSize int
+}
+
+// sqlQuery is the parsed extEntityQuery of a mapping.
+type sqlQuery struct {
+	query       *boundQuery
+	idColumn    string
+	entityQuery *boundQuery
+}
+
+func init() {
+	provider.RegisterProviderV2("sql", New)
+	provider.RegisterSchema("sql", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"driver": config.String().Require().OneOf(
+				DRIVER_POSTGRES,
+				DRIVER_MYSQL,
+				DRIVER_SQLITE,
+			),
+			"dsn":             config.String().Require(),
+			"maxOpenConns":    config.Int(),
+			"connMaxLifetime": config.Duration(),
+			"batchSize":       config.Int(),
+		},
+		Query: map[string]*config.Node{
+			"query":       config.String().Require(),
+			"idColumn":    config.String(),
+			"entityQuery": config.String(),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	driver := strings.ToLower(v.GetString("driver"))
+
+	switch driver {
+	case DRIVER_POSTGRES, DRIVER_MYSQL, DRIVER_SQLITE:
+
+	case "":
+		return nil, fmt.Errorf("missing sql driver")
+
+	default:
+		return nil, fmt.Errorf("invalid sql driver: %s", driver)
+	}
+
+	dsn := v.GetString("dsn")
+	if dsn == "" {
+		return nil, fmt.Errorf("missing sql dsn")
+	}
+
+	// Connections are established lazily so that an unavailable database does
+	// not prevent startup.
+	db, err := dbsql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sql database: %v", err)
+	}
+
+	maxOpenConns := v.GetInt("maxOpenConns")
+	if maxOpenConns <= 0 {
+		maxOpenConns = DEFAULT_MAX_OPEN_CONNS
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+
+	if v.IsSet("connMaxLifetime") {
+		db.SetConnMaxLifetime(v.GetDuration("connMaxLifetime"))
+	}
+
+	batchSize := v.GetInt("batchSize")
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BATCH_SIZE
+	}
+
+	return &SqlProvider{
+		Interop:   i,
+		Driver:    driver,
+		DB:        db,
+		BatchSize: batchSize,
+	}, nil
+}
+
+func (sp *SqlProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Delta:        true,
+		EntityLookup: true,
+	}
+}
+
+func (sp *SqlProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := sp.parseQuery(config)
+	return err
+}
+
+// Entities runs the query and returns an iterator that reads at most BatchSize
+// rows per call to Next.
+func (sp *SqlProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	sq, err := sp.parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a last update, the start of the epoch is bound so that queries
+	// that filter on :lastUpdate return all rows.
+	lastUpdate := time.Unix(0, 0).UTC()
+	if query.LastUpdate != nil {
+		lastUpdate = query.LastUpdate.UTC()
+	}
+
+	rows, err := sp.query(ctx, sq.query, map[string]interface{}{
+		PARAM_LAST_UPDATE: sp.timestamp(lastUpdate),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	it := &entityIterator{
+		sp:    sp,
+		query: sq,
+		rows:  rows,
+		tags:  query.Tags,
+	}
+
+	if err := it.init(); err != nil {
+		it.Close()
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// GetEntity runs the entityQuery of the query with the given ID bound to :id.
+func (sp *SqlProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	sq, err := sp.parseQuery(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if sq.entityQuery == nil {
+		return nil, fmt.Errorf("missing entityQuery for fetching single entities")
+	}
+
+	rows, err := sp.query(ctx, sq.entityQuery, map[string]interface{}{
+		PARAM_ID: id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	it := &entityIterator{
+		sp:    sp,
+		query: sq,
+		rows:  rows,
+		tags:  tags,
+	}
+
+	defer it.Close()
+
+	if err := it.init(); err != nil {
+		return nil, err
+	}
+
+	for {
+		entities, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range entities {
+			if entities[index].ID == id {
+				return &entities[index], nil
+			}
+		}
+	}
+}
+
+func (sp *SqlProvider) Close() error {
+	return sp.DB.Close()
+}
+
+func (sp *SqlProvider) parseQuery(config map[string]interface{}) (*sqlQuery, error) {
+	var err error
+
+	text := cast.ToString(config["query"])
+	if text == "" {
+		return nil, fmt.Errorf("missing sql query")
+	}
+
+	sq := &sqlQuery{
+		idColumn: cast.ToString(config["idcolumn"]),
+	}
+
+	if sq.query, err = bindParams(text, sp.Driver, PARAM_LAST_UPDATE); err != nil {
+		return nil, err
+	}
+
+	if entityQuery := cast.ToString(config["entityquery"]); entityQuery != "" {
+		if sq.entityQuery, err = bindParams(entityQuery, sp.Driver, PARAM_ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if sq.idColumn == "" {
+		sq.idColumn = DEFAULT_ID_COLUMN
+	}
+
+	return sq, nil
+}
+
+// timestamp returns the value to bind for the given time. SQLite has no
+// timestamp type, so times are bound as text in the format of the SQLite date
+// and time functions.
+func (sp *SqlProvider) timestamp(t time.Time) interface{} {
+	if sp.Driver == DRIVER_SQLITE {
+		return t.Format(SQLITE_TIME_FORMAT)
+	}
+
+	return t
+}
+
+func (sp *SqlProvider) query(
+	ctx context.Context,
+	bq *boundQuery,
+	values map[string]interface{},
+) (
+	*dbsql.Rows,
+	error,
+) {
+	sp.Interop.Logger.Debugf("running sql query %s...", bq.text)
+
+	start := time.Now()
+	rows, err := sp.DB.QueryContext(ctx, bq.text, bq.args(values)...)
+
+	metrics.ProviderRequestDuration.WithLabelValues("sql").Observe(
+		time.Since(start).Seconds(),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("sql query failed: %v", err)
+	}
+
+	return rows, nil
+}
+
+// entityIterator streams the rows of a query so that only one batch of rows is
+// held in memory at a time.
+type entityIterator struct {
+	sp      *SqlProvider
+	query   *sqlQuery
+	rows    *dbsql.Rows
+	tags    []string
+	columns []string
+}
+
+// init reads the column names of the result and checks that the ID column is
+// among them.
+func (it *entityIterator) init() error {
+	columns, err := it.rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to read sql columns: %v", err)
+	}
+
+	for _, column := range columns {
+		if column == it.query.idColumn {
+			it.columns = columns
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"sql query does not return the id column %s",
+		it.query.idColumn,
+	)
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	if it.rows == nil {
+		return nil, io.EOF
+	}
+
+	entities := []provider.Entity{}
+	values := make([]interface{}, len(it.columns))
+	pointers := make([]interface{}, len(it.columns))
+
+	for index := range values {
+		pointers[index] = &values[index]
+	}
+
+	for len(entities) < it.sp.BatchSize && it.rows.Next() {
+		if err := it.rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to read sql row: %v", err)
+		}
+
+		row := make(map[string]interface{}, len(it.columns))
+		for index, column := range it.columns {
+			row[column] = values[index]
+		}
+
+		row = provider.StringifyValues(row)
+
+		id := cast.ToString(row[it.query.idColumn])
+		if id == "" {
+			it.sp.Interop.Logger.Warnf(
+				"skipping row with no %s column",
+				it.query.idColumn,
+			)
+			continue
+		}
+
+		entities = append(entities, provider.Entity{
+			ID:   id,
+			Tags: provider.SelectFields(row, it.tags),
+		})
+	}
+
+	if err := it.rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sql rows: %v", err)
+	}
+
+	if len(entities) == 0 {
+		return nil, io.EOF
+	}
+
+	return entities, nil
+}
+
+func (it *entityIterator) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+
+	err := it.rows.Close()
+	it.rows = nil
+
+	return err
+}
@@ -0,0 +1,164 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// STATE_VERSION is the only supported version of the state file format, used
+// by Terraform 0.12 and later.
+const STATE_VERSION = 4
+
+// state is the subset of a Terraform state file read by the provider.
+type state struct {
+	Version   int             `json:"version"`
+	Resources []stateResource `json:"resources"`
+}
+
+type stateResource struct {
+	Module    string          `json:"module"`
+	Mode      string          `json:"mode"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Provider  string          `json:"provider"`
+	Instances []stateInstance `json:"instances"`
+}
+
+type stateInstance struct {
+	IndexKey            interface{}            `json:"index_key"`
+	Attributes          map[string]interface{} `json:"attributes"`
+	SensitiveAttributes []json.RawMessage      `json:"sensitive_attributes"`
+}
+
+// pathStep is one step of the path of a sensitive attribute. The value of a
+// get_attr step is an attribute name and the value of an index step is an
+// object with the map key or list index as its value.
+type pathStep struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func readState(path string) (*state, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	// Numbers are decoded as json.Number so that large integers, such as
+	// account numbers, are not converted to floating point
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+
+	s := &state{}
+	if err := decoder.Decode(s); err != nil {
+		return nil, fmt.Errorf("invalid terraform state: %v", err)
+	}
+
+	if s.Version != STATE_VERSION {
+		return nil, fmt.Errorf("unsupported terraform state version %d", s.Version)
+	}
+
+	return s, nil
+}
+
+// address returns the address of the resource instance with the given index
+// key, such as module.app.aws_instance.web[0].
+func (r *stateResource) address(indexKey interface{}) string {
+	var b strings.Builder
+
+	if r.Module != "" {
+		b.WriteString(r.Module)
+		b.WriteString(".")
+	}
+
+	if r.Mode == MODE_DATA {
+		b.WriteString("data.")
+	}
+
+	b.WriteString(r.Type)
+	b.WriteString(".")
+	b.WriteString(r.Name)
+
+	switch key := indexKey.(type) {
+	case nil:
+
+	case string:
+		fmt.Fprintf(&b, "[%q]", key)
+
+	default:
+		fmt.Fprintf(&b, "[%s]", cast.ToString(key))
+	}
+
+	return b.String()
+}
+
+// attributes returns the attributes of the instance without the attributes
+// that Terraform marked as sensitive.
+func (i *stateInstance) attributes() map[string]interface{} {
+	attributes := i.Attributes
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+
+	for _, raw := range i.SensitiveAttributes {
+		var steps []pathStep
+
+		if err := json.Unmarshal(raw, &steps); err != nil || len(steps) == 0 {
+			continue
+		}
+
+		removePath(attributes, steps)
+	}
+
+	return attributes
+}
+
+// removePath removes the value at the given path. Map keys are deleted and
+// list elements are set to nil so that the indices of the remaining elements
+// do not change.
+func removePath(value interface{}, steps []pathStep) {
+	for index, step := range steps {
+		last := index == len(steps)-1
+
+		key := step.Value
+		if step.Type == "index" {
+			if m, ok := key.(map[string]interface{}); ok {
+				key = m["value"]
+			}
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			name := cast.ToString(key)
+
+			if last {
+				delete(v, name)
+				return
+			}
+
+			value = v[name]
+
+		case []interface{}:
+			n, err := cast.ToIntE(key)
+			if err != nil || n < 0 || n >= len(v) {
+				return
+			}
+
+			if last {
+				v[n] = nil
+				return
+			}
+
+			value = v[n]
+
+		default:
+			return
+		}
+	}
+}
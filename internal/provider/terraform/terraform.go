@@ -0,0 +1,388 @@
+// Package terraform implements a provider that reads external entities from
+// the resource instances recorded in local Terraform state files.
+package terraform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+const (
+	MODE_MANAGED = "managed"
+	MODE_DATA    = "data"
+
+	ID_KEY_ADDRESS = "address"
+	ID_KEY_ID      = "id"
+
+	// META_KEY is the key of the nested field holding the address and other
+	// metadata of each resource instance
+	META_KEY = "terraform"
+)
+
+type TerraformProvider struct {
+	Interop *interop.Interop
+	BaseDir string
+}
+
+// stateQuery is the parsed extEntityQuery of a mapping.
+type stateQuery struct {
+	path          string
+	resourceTypes []string
+	modules       []string
+	mode          string
+	idKey         string
+}
+
+func init() {
+	provider.RegisterProviderV2("terraform", New)
+	provider.RegisterSchema("terraform", &provider.Schema{
+		Provider: map[string]*config.Node{
+			"baseDir": config.String(),
+		},
+		Query: map[string]*config.Node{
+			"path":         config.String().Require(),
+			"resourceType": config.ListOf(config.String()),
+			"module":       config.ListOf(config.String()),
+			"mode":         config.String().OneOf(MODE_MANAGED, MODE_DATA),
+			"idKey":        config.String().OneOf(ID_KEY_ADDRESS, ID_KEY_ID),
+		},
+	})
+}
+
+func New(i *interop.Interop, v *viper.Viper) (provider.ProviderV2, error) {
+	baseDir := v.GetString("baseDir")
+	if baseDir == "" {
+		// Relative paths are resolved against the directory of the
+		// configuration file, as with the file provider
+		if configFile := i.ConfigFileUsed(); configFile != "" {
+			baseDir = filepath.Dir(configFile)
+		} else {
+			baseDir = "."
+		}
+	}
+
+	return &TerraformProvider{
+		Interop: i,
+		BaseDir: baseDir,
+	}, nil
+}
+
+func (tp *TerraformProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Delta:        true,
+		EntityLookup: true,
+	}
+}
+
+func (tp *TerraformProvider) ValidateQuery(config map[string]interface{}) error {
+	_, err := parseQuery(config)
+	return err
+}
+
+// Entities returns an iterator over the resource instances of the state files
+// matching the path of the query, in lexical order of the file names. Each
+// call to Next returns the resource instances of one state file.
+func (tp *TerraformProvider) Entities(
+	ctx context.Context,
+	query *provider.Query,
+) (
+	provider.EntityIterator,
+	error,
+) {
+	sq, err := parseQuery(query.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := provider.FindFiles(tp.BaseDir, sq.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entityIterator{
+		tp:         tp,
+		query:      sq,
+		files:      files,
+		tags:       query.Tags,
+		lastUpdate: query.LastUpdate,
+	}, nil
+}
+
+// GetEntity scans the state files matching the path of the query for the
+// resource instance with the given address or ID.
+func (tp *TerraformProvider) GetEntity(
+	ctx context.Context,
+	config map[string]interface{},
+	tags []string,
+	id string,
+) (
+	*provider.Entity,
+	error,
+) {
+	it, err := tp.Entities(ctx, &provider.Query{Config: config, Tags: tags})
+	if err != nil {
+		return nil, err
+	}
+
+	defer it.Close()
+
+	for {
+		entities, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range entities {
+			if entities[index].ID == id {
+				return &entities[index], nil
+			}
+		}
+	}
+}
+
+func (tp *TerraformProvider) Close() error {
+	return nil
+}
+
+func parseQuery(config map[string]interface{}) (*stateQuery, error) {
+	sq := &stateQuery{
+		path:          cast.ToString(config["path"]),
+		resourceTypes: cast.ToStringSlice(config["resourcetype"]),
+		modules:       cast.ToStringSlice(config["module"]),
+		mode:          strings.ToLower(cast.ToString(config["mode"])),
+		idKey:         strings.ToLower(cast.ToString(config["idkey"])),
+	}
+
+	if sq.path == "" {
+		return nil, fmt.Errorf("missing terraform state path")
+	}
+
+	if _, err := filepath.Match(sq.path, ""); err != nil {
+		return nil, fmt.Errorf("invalid terraform state path %s: %v", sq.path, err)
+	}
+
+	for _, resourceType := range sq.resourceTypes {
+		if _, err := path.Match(resourceType, ""); err != nil {
+			return nil, fmt.Errorf(
+				"invalid terraform resource type %s: %v",
+				resourceType,
+				err,
+			)
+		}
+	}
+
+	switch sq.mode {
+	case "":
+		sq.mode = MODE_MANAGED
+
+	case MODE_MANAGED, MODE_DATA:
+
+	default:
+		return nil, fmt.Errorf("invalid terraform resource mode: %s", sq.mode)
+	}
+
+	switch sq.idKey {
+	case "":
+		sq.idKey = ID_KEY_ADDRESS
+
+	case ID_KEY_ADDRESS, ID_KEY_ID:
+
+	default:
+		return nil, fmt.Errorf("invalid terraform id key: %s", sq.idKey)
+	}
+
+	return sq, nil
+}
+
+// matches returns true if the given resource is selected by the query.
+func (sq *stateQuery) matches(resource *stateResource) bool {
+	mode := resource.Mode
+	if mode == "" {
+		mode = MODE_MANAGED
+	}
+
+	if mode != sq.mode {
+		return false
+	}
+
+	if len(sq.resourceTypes) > 0 {
+		found := false
+
+		for _, resourceType := range sq.resourceTypes {
+			if ok, _ := path.Match(resourceType, resource.Type); ok {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(sq.modules) > 0 {
+		for _, module := range sq.modules {
+			if inModule(resource.Module, module) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// inModule returns true if the module address is the given module, one of its
+// instances or one of its descendants. For example, module.network[0] and
+// module.network.module.subnets are both in module.network.
+func inModule(address, module string) bool {
+	if !strings.HasPrefix(address, module) {
+		return false
+	}
+
+	rest := address[len(module):]
+
+	return rest == "" ||
+		strings.HasPrefix(rest, ".") ||
+		strings.HasPrefix(rest, "[")
+}
+
+type entityIterator struct {
+	tp         *TerraformProvider
+	query      *stateQuery
+	files      []string
+	tags       []string
+	lastUpdate *time.Time
+}
+
+func (it *entityIterator) Next(ctx context.Context) ([]provider.Entity, error) {
+	for len(it.files) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		file := it.files[0]
+		it.files = it.files[1:]
+
+		entities, err := it.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entities) > 0 {
+			return entities, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+// readFile returns the entities for the selected resource instances of a state
+// file. During delta synchronization, state files that were not modified since
+// the last update are skipped since the state has no per resource timestamps.
+func (it *entityIterator) readFile(file string) ([]provider.Entity, error) {
+	if it.lastUpdate != nil {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ModTime().Before(*it.lastUpdate) {
+			it.tp.Interop.Logger.Debugf(
+				"skipping %s; not modified since %s",
+				file,
+				it.lastUpdate.Format(time.RFC3339),
+			)
+			return nil, nil
+		}
+	}
+
+	it.tp.Interop.Logger.Debugf("reading terraform state from %s", file)
+
+	state, err := readState(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+
+	entities := []provider.Entity{}
+
+	for index := range state.Resources {
+		resource := &state.Resources[index]
+		if !it.query.matches(resource) {
+			continue
+		}
+
+		for _, instance := range resource.Instances {
+			entity := it.toEntity(file, resource, &instance)
+			if entity != nil {
+				entities = append(entities, *entity)
+			}
+		}
+	}
+
+	return entities, nil
+}
+
+// toEntity returns the entity for a resource instance, or nil if the instance
+// has no ID. The attributes of the instance are the top-level keys and the
+// address and other metadata are keys of the nested terraform field.
+func (it *entityIterator) toEntity(
+	file string,
+	resource *stateResource,
+	instance *stateInstance,
+) *provider.Entity {
+	address := resource.address(instance.IndexKey)
+
+	values := instance.attributes()
+	values[META_KEY] = map[string]interface{}{
+		"address":  address,
+		"module":   resource.Module,
+		"mode":     resource.Mode,
+		"type":     resource.Type,
+		"name":     resource.Name,
+		"provider": resource.Provider,
+		"indexKey": cast.ToString(instance.IndexKey),
+	}
+
+	values = provider.StringifyValues(values)
+
+	id := address
+	if it.query.idKey == ID_KEY_ID {
+		id = cast.ToString(values["id"])
+		if id == "" {
+			it.tp.Interop.Logger.Warnf(
+				"skipping resource instance %s in %s with no id attribute",
+				address,
+				file,
+			)
+			return nil
+		}
+	}
+
+	return &provider.Entity{
+		ID:   id,
+		Tags: provider.SelectFields(values, it.tags),
+	}
+}
+
+func (it *entityIterator) Close() error {
+	it.files = nil
+	return nil
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	stdsync "sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/sync"
+)
+
+const (
+  reloadDelay = time.Second
+)
+
+// configWatcher watches the directories containing the configuration files
+// and reloads the configuration when any of them change. Directories are
+// watched rather than files so that files replaced by renaming, such as
+// mounted Kubernetes ConfigMaps, are detected.
+type configWatcher struct {
+  fsWatcher         *fsnotify.Watcher
+  reload            func()
+  lock              stdsync.Mutex
+  timer             *time.Timer
+  watched           map[string]bool
+}
+
+func (s *Server) newConfigWatcher() (*configWatcher, error) {
+  configFile := s.i.ConfigFileUsed()
+  if configFile == "" {
+    // The configuration was not read from a file
+    return nil, nil
+  }
+
+  if !config.IsSupportedFile(configFile) {
+    s.log.Warnf(
+      "config file %s will not be watched; only YAML and JSON files can be reloaded",
+      configFile,
+    )
+    return nil, nil
+  }
+
+  tree, err := config.Load(configFile)
+  if err != nil {
+    return nil, err
+  }
+
+  hash, err := hashTree(tree)
+  if err != nil {
+    return nil, err
+  }
+
+  s.configHash = hash
+
+  fsWatcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    return nil, fmt.Errorf("failed to create config watcher: %v", err)
+  }
+
+  w := &configWatcher{
+    fsWatcher: fsWatcher,
+    reload: s.reloadConfig,
+    watched: map[string]bool{},
+  }
+
+  if err := w.watch(tree); err != nil {
+    fsWatcher.Close()
+    return nil, err
+  }
+
+  return w, nil
+}
+
+// watch adds the directories of the files and the directories in the given
+// tree to the watcher.
+func (w *configWatcher) watch(tree *config.Tree) error {
+  dirs := append([]string{}, tree.Dirs...)
+
+  for _, file := range tree.Files {
+    dirs = append(dirs, filepath.Dir(file))
+  }
+
+  w.lock.Lock()
+  defer w.lock.Unlock()
+
+  for _, dir := range dirs {
+    if w.watched[dir] {
+      continue
+    }
+
+    if err := w.fsWatcher.Add(dir); err != nil {
+      return fmt.Errorf("failed to watch %s: %v", dir, err)
+    }
+
+    w.watched[dir] = true
+  }
+
+  return nil
+}
+
+func (w *configWatcher) run(ctx context.Context) {
+  for {
+    select {
+    case <-ctx.Done():
+      return
+
+    case _, ok := <-w.fsWatcher.Events:
+      if !ok {
+        return
+      }
+
+      w.schedule()
+
+    case _, ok := <-w.fsWatcher.Errors:
+      if !ok {
+        return
+      }
+    }
+  }
+}
+
+// schedule reloads the configuration once no further changes have been seen
+// for the reload delay.
+func (w *configWatcher) schedule() {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+
+  if w.timer == nil {
+    w.timer = time.AfterFunc(reloadDelay, w.fire)
+    return
+  }
+
+  w.timer.Reset(reloadDelay)
+}
+
+func (w *configWatcher) fire() {
+  w.lock.Lock()
+  w.timer = nil
+  w.lock.Unlock()
+
+  w.reload()
+}
+
+func (w *configWatcher) stop() {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+
+  if w.timer != nil {
+    w.timer.Stop()
+    w.timer = nil
+  }
+
+  w.fsWatcher.Close()
+}
+
+// reloadConfig reloads the configuration between runs. The new configuration
+// is validated first and ignored if it is invalid. Pending webhook changes are
+// run using the previous configuration before the new one is put into use.
+func (s *Server) reloadConfig() {
+  if !s.startRun() {
+    return
+  }
+
+  defer s.runs.Done()
+
+  if !s.running.CompareAndSwap(false, true) {
+    s.log.Debugf("a run is in progress; deferring config reload")
+    s.watcher.schedule()
+    return
+  }
+
+  defer s.finishRun()
+
+  tree, err := config.Load(s.i.ConfigFileUsed())
+  if err != nil {
+    s.reloadFailed("", err)
+    return
+  }
+
+  hash, err := hashTree(tree)
+  if err != nil {
+    s.reloadFailed("", err)
+    return
+  }
+
+  // A config that already failed to load is not reported again until it
+  // changes
+  if hash == s.configHash || hash == s.failedConfigHash {
+    s.log.Debugf("config unchanged; not reloading")
+    return
+  }
+
+  s.log.Infof("config changed; reloading")
+
+  if err := sync.ValidateConfig(s.i.ConfigFileUsed()); err != nil {
+    s.reloadFailed(hash, err)
+    return
+  }
+
+  if s.debouncer != nil {
+    s.runChanges(s.debouncer.drain())
+  }
+
+  var (
+    syncer          *sync.Syncer
+    jobs            []*job
+  )
+
+  err = s.i.ReloadConfig(func() error {
+    var err error
+
+    syncer, err = s.syncer.Reload()
+    if err != nil {
+      return err
+    }
+
+    jobs, err = s.buildJobs(syncer)
+
+    return err
+  })
+  if err != nil {
+    s.reloadFailed(hash, err)
+    return
+  }
+
+  now := time.Now()
+
+  s.statusLock.Lock()
+
+  s.unscheduleJobs()
+  previous := s.syncer
+  s.syncer = syncer
+  s.scheduleJobs(jobs)
+  s.configHash = hash
+  s.failedConfigHash = ""
+  s.lastReload = &now
+  s.lastReloadError = ""
+  // Mapping indices may refer to different mappings in the new config
+  s.lastMappingRuns = make(map[int]sync.MappingResult)
+  generation := s.generation.Add(1)
+
+  s.statusLock.Unlock()
+
+  previous.CloseReplacedProviders(syncer)
+
+  if err := s.watcher.watch(tree); err != nil {
+    s.log.Warnf("failed to watch new config files: %v", err)
+  }
+
+  syncer.RecordConfigReload(generation, nil)
+
+  s.log.Infof("config reloaded; generation %d", generation)
+}
+
+func (s *Server) reloadFailed(hash string, err error) {
+  now := time.Now()
+
+  s.statusLock.Lock()
+  s.failedConfigHash = hash
+  s.lastReload = &now
+  s.lastReloadError = err.Error()
+  s.statusLock.Unlock()
+
+  s.syncer.RecordConfigReload(s.generation.Load(), err)
+
+  s.log.Errorf("config reload failed; continuing with previous config: %v", err)
+}
+
+func hashTree(tree *config.Tree) (string, error) {
+  data, err := json.Marshal(tree.Values)
+  if err != nil {
+    return "", fmt.Errorf("failed to hash config: %v", err)
+  }
+
+  sum := sha256.Sum256(data)
+
+  return hex.EncodeToString(sum[:]), nil
+}
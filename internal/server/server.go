@@ -0,0 +1,488 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	stdsync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+	"github.com/newrelic/nr-entity-tag-sync/internal/sync"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+  defaultListenAddress = ":8080"
+  defaultSchedule = "@every 1h"
+  shutdownTimeout = 10 * time.Second
+)
+
+var (
+  cronParser = cron.NewParser(
+    cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+  )
+)
+
+// job runs a set of mappings of a syncer on a schedule. Each job belongs to
+// the syncer it was built for so that mapping indices are never run against
+// the syncer of a reloaded config. A job triggered while another run is in
+// progress is marked as pending and run once the other run completes.
+type job struct {
+  syncer            *sync.Syncer
+  name              string
+  schedule          string
+  cronSchedule      cron.Schedule
+  indices           []int
+  entryId           cron.EntryID
+  pending           atomic.Bool
+}
+
+// Server runs the syncer on a schedule in a long-running process and serves
+// the HTTP endpoints used to monitor it.
+type Server struct {
+  i                 *interop.Interop
+  log               *logrus.Logger
+  syncer            *sync.Syncer
+  httpServer        *http.Server
+  cron              *cron.Cron
+  jobs              []*job
+  runOnStart        bool
+  webhook           *webhookConfig
+  debouncer         *debouncer
+  watcher           *configWatcher
+  running           atomic.Bool
+  runs              stdsync.WaitGroup
+  runLock           stdsync.Mutex
+  stopping          bool
+  ready             atomic.Bool
+  generation        atomic.Int64
+  statusLock        stdsync.RWMutex
+  lastRun           *sync.SyncResult
+  lastMappingRuns   map[int]sync.MappingResult
+  configHash        string
+  failedConfigHash  string
+  lastReload        *time.Time
+  lastReloadError   string
+}
+
+func New(i *interop.Interop, syncer *sync.Syncer) (*Server, error) {
+  listenAddress := i.Config.GetString("server.listenAddress")
+  if listenAddress == "" {
+    listenAddress = defaultListenAddress
+  }
+
+  runOnStart := true
+  if i.Config.IsSet("server.runOnStart") {
+    runOnStart = i.Config.GetBool("server.runOnStart")
+  }
+
+  s := &Server{
+    i: i,
+    log: i.Logger,
+    syncer: syncer,
+    cron: cron.New(cron.WithParser(cronParser)),
+    runOnStart: runOnStart,
+    lastMappingRuns: make(map[int]sync.MappingResult),
+  }
+
+  jobs, err := s.buildJobs(syncer)
+  if err != nil {
+    return nil, err
+  }
+
+  s.scheduleJobs(jobs)
+  s.generation.Store(1)
+
+  watchConfig := true
+  if s.i.Config.IsSet("server.watchConfig") {
+    watchConfig = s.i.Config.GetBool("server.watchConfig")
+  }
+
+  if watchConfig {
+    watcher, err := s.newConfigWatcher()
+    if err != nil {
+      return nil, err
+    }
+
+    s.watcher = watcher
+  }
+
+  webhook, err := getWebhookConfig(s.i)
+  if err != nil {
+    return nil, err
+  }
+
+  mux := http.NewServeMux()
+  mux.Handle("/metrics", metrics.Handler())
+  mux.HandleFunc("/healthz", s.handleHealthz)
+  mux.HandleFunc("/readyz", s.handleReadyz)
+  mux.HandleFunc("/status", s.handleStatus)
+
+  if webhook != nil {
+    s.webhook = webhook
+    s.debouncer = newDebouncer(webhook.debounce, s.flushChanges)
+    mux.HandleFunc(webhook.path, s.handleWebhook)
+  }
+
+  s.httpServer = &http.Server{
+    Addr: listenAddress,
+    Handler: mux,
+    ReadHeaderTimeout: 10 * time.Second,
+  }
+
+  return s, nil
+}
+
+// Run starts the HTTP server and the scheduler and blocks until the given
+// context is canceled.
+func (s *Server) Run(ctx context.Context) error {
+  errCh := make(chan error, 1)
+
+  go func() {
+    s.log.Infof("listening on %s", s.httpServer.Addr)
+
+    err := s.httpServer.ListenAndServe()
+    if err != nil && !errors.Is(err, http.ErrServerClosed) {
+      errCh <- err
+    }
+  }()
+
+  s.cron.Start()
+
+  if s.watcher != nil {
+    go s.watcher.run(ctx)
+  }
+
+  // The configuration was validated when the server was created so the
+  // server is ready once the scheduler has started, or once the initial run
+  // has completed when mappings are run on startup
+  if s.runOnStart {
+    go func() {
+      s.runAll()
+      s.ready.Store(true)
+    }()
+  } else {
+    s.ready.Store(true)
+  }
+
+  select {
+  case <-ctx.Done():
+    return s.shutdown()
+
+  case err := <-errCh:
+    s.cron.Stop()
+    return fmt.Errorf("http server failed: %v", err)
+  }
+}
+
+// buildJobs creates the jobs for the enabled mappings of the given syncer.
+// Mappings without a schedule are run together by the default job.
+func (s *Server) buildJobs(syncer *sync.Syncer) ([]*job, error) {
+  schedule := s.i.Config.GetString("server.schedule")
+  if schedule == "" {
+    if s.i.Config.IsSet("server.interval") {
+      interval := s.i.Config.GetDuration("server.interval")
+      if interval <= 0 {
+        return nil, fmt.Errorf(
+          "invalid server interval: %s",
+          s.i.Config.GetString("server.interval"),
+        )
+      }
+
+      schedule = "@every " + interval.String()
+    } else {
+      schedule = defaultSchedule
+    }
+  }
+
+  jobs := []*job{}
+  defaultJob := &job{ syncer: syncer, name: "default", schedule: schedule }
+
+  for index, mapping := range syncer.Mappings() {
+    if !mapping.IsEnabled() {
+      s.log.Debugf("mapping %d is disabled; not scheduling", index)
+      continue
+    }
+
+    if mapping.Schedule == "" {
+      defaultJob.indices = append(defaultJob.indices, index)
+      continue
+    }
+
+    jobs = append(jobs, &job{
+      syncer: syncer,
+      name: jobName(index, mapping.Name),
+      schedule: mapping.Schedule,
+      indices: []int{ index },
+    })
+  }
+
+  if len(defaultJob.indices) > 0 {
+    jobs = append([]*job{ defaultJob }, jobs...)
+  }
+
+  for _, j := range jobs {
+    cronSchedule, err := cronParser.Parse(j.schedule)
+    if err != nil {
+      return nil, fmt.Errorf("invalid schedule %q for %s: %v", j.schedule, j.name, err)
+    }
+
+    j.cronSchedule = cronSchedule
+  }
+
+  return jobs, nil
+}
+
+func (s *Server) scheduleJobs(jobs []*job) {
+  for _, j := range jobs {
+    j := j
+
+    j.entryId = s.cron.Schedule(j.cronSchedule, cron.FuncJob(func() {
+      s.runJob(j)
+    }))
+
+    s.log.Debugf("scheduled %s with schedule %s", j.name, j.schedule)
+  }
+
+  s.jobs = jobs
+}
+
+func (s *Server) unscheduleJobs() {
+  for _, j := range s.jobs {
+    s.cron.Remove(j.entryId)
+  }
+
+  s.jobs = nil
+}
+
+func (s *Server) runAll() {
+  s.statusLock.RLock()
+  jobs := s.jobs
+  s.statusLock.RUnlock()
+
+  for _, j := range jobs {
+    s.runJob(j)
+  }
+}
+
+// startRun registers a run, a scheduled job, a flush of webhook changes or a
+// config reload, so that shutdown waits for it to complete. It returns false
+// once the server is shutting down, in which case the run must not be started.
+func (s *Server) startRun() bool {
+  s.runLock.Lock()
+  defer s.runLock.Unlock()
+
+  if s.stopping {
+    return false
+  }
+
+  s.runs.Add(1)
+
+  return true
+}
+
+func (s *Server) runJob(j *job) {
+  if !s.startRun() {
+    return
+  }
+
+  defer s.runs.Done()
+
+  if !s.running.CompareAndSwap(false, true) {
+    // Triggers while the job is already pending are collapsed into one run
+    if j.pending.CompareAndSwap(false, true) {
+      s.log.Infof("deferring run of %s; a previous run is still in progress", j.name)
+    }
+
+    // The previous run may have completed before the job was marked as
+    // pending, in which case it did not see the job
+    if !s.running.Load() {
+      s.runPendingJobs()
+    }
+
+    return
+  }
+
+  defer s.finishRun()
+
+  // A job that fired while the config was being reloaded belongs to the
+  // previous syncer. The syncer can not be replaced while the running flag is
+  // held so it is only checked once.
+  s.statusLock.RLock()
+  current := j.syncer == s.syncer
+  s.statusLock.RUnlock()
+
+  if !current {
+    s.log.Debugf("skipping run of %s; the config was reloaded", j.name)
+    return
+  }
+
+  s.log.Debugf("running %s", j.name)
+
+  result, err := j.syncer.Run(j.indices)
+  if err != nil {
+    s.log.Errorf("run of %s failed: %v", j.name, err)
+  }
+
+  s.statusLock.Lock()
+  defer s.statusLock.Unlock()
+
+  s.lastRun = result
+
+  if result != nil {
+    for _, mappingResult := range result.Mappings {
+      s.lastMappingRuns[mappingResult.Index] = mappingResult
+    }
+  }
+}
+
+// finishRun releases the running flag and starts the jobs that were deferred
+// while it was held.
+func (s *Server) finishRun() {
+  s.running.Store(false)
+  s.runPendingJobs()
+}
+
+func (s *Server) runPendingJobs() {
+  s.statusLock.RLock()
+  jobs := s.jobs
+  s.statusLock.RUnlock()
+
+  for _, j := range jobs {
+    if j.pending.CompareAndSwap(true, false) {
+      go s.runJob(j)
+    }
+  }
+}
+
+func (s *Server) shutdown() error {
+  s.log.Infof("shutting down")
+
+  // Stop accepting webhook requests first so that no changes are queued once
+  // the debouncer is stopped
+  ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+  defer cancel()
+
+  err := s.httpServer.Shutdown(ctx)
+
+  if s.debouncer != nil {
+    s.debouncer.stop()
+  }
+
+  if s.watcher != nil {
+    s.watcher.stop()
+  }
+
+  <-s.cron.Stop().Done()
+
+  // Wait for any run started on startup, by the scheduler or by a timer to
+  // finish before closing the syncer
+  s.runLock.Lock()
+  s.stopping = true
+  s.runLock.Unlock()
+
+  s.runs.Wait()
+
+  s.syncer.Close()
+
+  return err
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+  w.WriteHeader(http.StatusOK)
+  w.Write([]byte("ok"))
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+  if !s.ready.Load() {
+    w.WriteHeader(http.StatusServiceUnavailable)
+    w.Write([]byte("not ready"))
+    return
+  }
+
+  w.WriteHeader(http.StatusOK)
+  w.Write([]byte("ok"))
+}
+
+type mappingStatus struct {
+  Index             int                     `json:"index"`
+  Name              string                  `json:"name,omitempty"`
+  Schedule          string                  `json:"schedule"`
+  NextRun           *time.Time              `json:"nextRun,omitempty"`
+  LastResult        *sync.MappingResult     `json:"lastResult,omitempty"`
+}
+
+type status struct {
+  Ready             bool                    `json:"ready"`
+  Running           bool                    `json:"running"`
+  ConfigGeneration  int64                   `json:"configGeneration"`
+  LastReload        *time.Time              `json:"lastReload,omitempty"`
+  LastReloadError   string                  `json:"lastReloadError,omitempty"`
+  LastRun           *sync.SyncResult        `json:"lastRun,omitempty"`
+  Mappings          []mappingStatus         `json:"mappings"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+  st := status{
+    Ready: s.ready.Load(),
+    Running: s.running.Load(),
+    Mappings: []mappingStatus{},
+  }
+
+  s.statusLock.RLock()
+
+  st.ConfigGeneration = s.generation.Load()
+  st.LastReload = s.lastReload
+  st.LastReloadError = s.lastReloadError
+  st.LastRun = s.lastRun
+
+  for _, j := range s.jobs {
+    var nextRun *time.Time
+
+    if entry := s.cron.Entry(j.entryId); !entry.Next.IsZero() {
+      nextRun = &entry.Next
+    }
+
+    for _, index := range j.indices {
+      ms := mappingStatus{
+        Index: index,
+        Name: s.syncer.Mappings()[index].Name,
+        Schedule: j.schedule,
+        NextRun: nextRun,
+      }
+
+      if result, ok := s.lastMappingRuns[index]; ok {
+        ms.LastResult = &result
+      }
+
+      st.Mappings = append(st.Mappings, ms)
+    }
+  }
+
+  s.statusLock.RUnlock()
+
+  sort.Slice(st.Mappings, func(i, j int) bool {
+    return st.Mappings[i].Index < st.Mappings[j].Index
+  })
+
+  w.Header().Set("Content-Type", "application/json")
+
+  if err := json.NewEncoder(w).Encode(st); err != nil {
+    s.log.Warnf("failed to write status response: %v", err)
+  }
+}
+
+func jobName(index int, name string) string {
+  if name != "" {
+    return fmt.Sprintf("mapping %d (%s)", index, name)
+  }
+
+  return fmt.Sprintf("mapping %d", index)
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/sync"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+)
+
+type webhookAuthType string
+
+const (
+  WEBHOOK_AUTH_TYPE_HMAC              webhookAuthType = "hmac"
+  WEBHOOK_AUTH_TYPE_BEARER            webhookAuthType = "bearer"
+
+  defaultWebhookPath = "/webhook"
+  defaultSignatureHeader = "X-Signature"
+  defaultDebounce = 5 * time.Second
+  minRetryDelay = time.Second
+  maxWebhookBodySize = 1 << 20
+)
+
+type webhookConfig struct {
+  path              string
+  authType          webhookAuthType
+  secret            []byte
+  signatureHeader   string
+  debounce          time.Duration
+}
+
+// changeNotification describes a change to a single external entity. If
+// Mapping is set, only the mappings with that name are run. Otherwise, all
+// mappings with an extEntityQuery type equal to Type are run.
+type changeNotification struct {
+  Mapping           string          `json:"mapping"`
+  Type              string          `json:"type"`
+  Id                string          `json:"id"`
+}
+
+// changeTarget identifies the mappings that changes apply to, either by
+// mapping name or by extEntityQuery type. Changes are queued by target rather
+// than by mapping index and the mappings are resolved when the changes are run
+// so that changes queued before a config reload are not run against whichever
+// mapping has the same index in the new config.
+type changeTarget struct {
+  mapping           string
+  extEntityType     string
+}
+
+type queuedChange struct {
+  Mapping           int             `json:"mapping"`
+  Id                string          `json:"id"`
+}
+
+// debouncer collects external entity IDs per change target and flushes them
+// once no new IDs have been added for the debounce delay.
+type debouncer struct {
+  lock              stdsync.Mutex
+  delay             time.Duration
+  pending           map[changeTarget]map[string]struct{}
+  timer             *time.Timer
+  flush             func(map[changeTarget][]string)
+  stopped           bool
+}
+
+func getWebhookConfig(i *interop.Interop) (*webhookConfig, error) {
+  if !i.Config.GetBool("server.webhook.enabled") {
+    return nil, nil
+  }
+
+  path := i.Config.GetString("server.webhook.path")
+  if path == "" {
+    path = defaultWebhookPath
+  }
+
+  authType := webhookAuthType(
+    strings.ToLower(i.Config.GetString("server.webhook.authType")),
+  )
+  if authType != WEBHOOK_AUTH_TYPE_HMAC && authType != WEBHOOK_AUTH_TYPE_BEARER {
+    return nil, fmt.Errorf("invalid webhook authentication type: %s", authType)
+  }
+
+  secret := i.Config.GetString("server.webhook.secret")
+  if secret == "" {
+    return nil, fmt.Errorf("missing webhook secret")
+  }
+
+  signatureHeader := i.Config.GetString("server.webhook.signatureHeader")
+  if signatureHeader == "" {
+    signatureHeader = defaultSignatureHeader
+  }
+
+  debounce := defaultDebounce
+  if i.Config.IsSet("server.webhook.debounce") {
+    debounce = i.Config.GetDuration("server.webhook.debounce")
+    if debounce <= 0 {
+      return nil, fmt.Errorf(
+        "invalid webhook debounce: %s",
+        i.Config.GetString("server.webhook.debounce"),
+      )
+    }
+  }
+
+  return &webhookConfig{
+    path,
+    authType,
+    []byte(secret),
+    signatureHeader,
+    debounce,
+  }, nil
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+
+  body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+  if err != nil {
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+      http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+      return
+    }
+
+    http.Error(w, "failed to read body", http.StatusBadRequest)
+    return
+  }
+
+  if !s.authenticateWebhook(r, body) {
+    http.Error(w, "unauthorized", http.StatusUnauthorized)
+    return
+  }
+
+  notifications, err := parseChangeNotifications(body)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  s.statusLock.RLock()
+  syncer := s.syncer
+  s.statusLock.RUnlock()
+
+  queued := []queuedChange{}
+
+  for _, notification := range notifications {
+    target := changeTarget{ notification.Mapping, notification.Type }
+
+    indices := findMappings(syncer, target)
+    if len(indices) == 0 {
+      s.log.Warnf(
+        "no mappings found for change notification for %s of type %s",
+        notification.Id,
+        notification.Type,
+      )
+      continue
+    }
+
+    s.debouncer.add(target, notification.Id)
+
+    for _, index := range indices {
+      queued = append(queued, queuedChange{ index, notification.Id })
+    }
+  }
+
+  if len(queued) == 0 {
+    http.Error(w, "no matching mappings", http.StatusNotFound)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(http.StatusAccepted)
+
+  if err := json.NewEncoder(w).Encode(queued); err != nil {
+    s.log.Warnf("failed to write webhook response: %v", err)
+  }
+}
+
+func (s *Server) authenticateWebhook(r *http.Request, body []byte) bool {
+  if s.webhook.authType == WEBHOOK_AUTH_TYPE_BEARER {
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok {
+      return false
+    }
+
+    return subtle.ConstantTimeCompare([]byte(token), s.webhook.secret) == 1
+  }
+
+  signature := strings.TrimPrefix(
+    r.Header.Get(s.webhook.signatureHeader),
+    "sha256=",
+  )
+
+  actual, err := hex.DecodeString(signature)
+  if err != nil {
+    return false
+  }
+
+  mac := hmac.New(sha256.New, s.webhook.secret)
+  mac.Write(body)
+
+  return hmac.Equal(actual, mac.Sum(nil))
+}
+
+func parseChangeNotifications(body []byte) ([]changeNotification, error) {
+  var notifications []changeNotification
+
+  trimmed := strings.TrimSpace(string(body))
+  if strings.HasPrefix(trimmed, "[") {
+    if err := json.Unmarshal(body, &notifications); err != nil {
+      return nil, fmt.Errorf("invalid change notification: %v", err)
+    }
+  } else {
+    notification := changeNotification{}
+    if err := json.Unmarshal(body, &notification); err != nil {
+      return nil, fmt.Errorf("invalid change notification: %v", err)
+    }
+
+    notifications = append(notifications, notification)
+  }
+
+  for _, notification := range notifications {
+    if notification.Id == "" {
+      return nil, fmt.Errorf("missing id in change notification")
+    }
+
+    if notification.Mapping == "" && notification.Type == "" {
+      return nil, fmt.Errorf(
+        "one of mapping or type is required in change notification",
+      )
+    }
+  }
+
+  return notifications, nil
+}
+
+// findMappings returns the indices of the enabled mappings of the syncer that
+// the change target applies to.
+func findMappings(syncer *sync.Syncer, target changeTarget) []int {
+  indices := []int{}
+
+  for index, mapping := range syncer.Mappings() {
+    if !mapping.IsEnabled() {
+      continue
+    }
+
+    if target.mapping != "" {
+      if mapping.Name == target.mapping {
+        indices = append(indices, index)
+      }
+      continue
+    }
+
+    extEntityType := cast.ToString(mapping.ExtEntityQuery["type"])
+    if strings.EqualFold(extEntityType, target.extEntityType) {
+      indices = append(indices, index)
+    }
+  }
+
+  return indices
+}
+
+func (s *Server) flushChanges(changes map[changeTarget][]string) {
+  if !s.startRun() {
+    return
+  }
+
+  defer s.runs.Done()
+
+  if !s.running.CompareAndSwap(false, true) {
+    // Requeue the changes and try again once the run may have completed
+    s.log.Debugf("a run is in progress; requeueing changes")
+
+    s.debouncer.requeue(changes)
+
+    return
+  }
+
+  defer s.finishRun()
+
+  s.runChanges(changes)
+}
+
+// runChanges runs the mappings of the current syncer that each change target
+// applies to for the changed external entities. The caller must hold the
+// running flag so that the syncer is not replaced while the changes are run.
+func (s *Server) runChanges(changes map[changeTarget][]string) {
+  s.statusLock.RLock()
+  syncer := s.syncer
+  s.statusLock.RUnlock()
+
+  // Targets may resolve to the same mapping, which is run once for all of the
+  // changed entities
+  pending := map[int]map[string]struct{}{}
+
+  for target, ids := range changes {
+    indices := findMappings(syncer, target)
+    if len(indices) == 0 {
+      s.log.Warnf(
+        "no mappings found for %d changed entities; mapping %q or type %q no longer exists",
+        len(ids),
+        target.mapping,
+        target.extEntityType,
+      )
+      continue
+    }
+
+    for _, index := range indices {
+      if pending[index] == nil {
+        pending[index] = make(map[string]struct{})
+      }
+
+      for _, id := range ids {
+        pending[index][id] = struct{}{}
+      }
+    }
+  }
+
+  indices := make([]int, 0, len(pending))
+  for index := range pending {
+    indices = append(indices, index)
+  }
+
+  sort.Ints(indices)
+
+  for _, index := range indices {
+    ids := make([]string, 0, len(pending[index]))
+    for id := range pending[index] {
+      ids = append(ids, id)
+    }
+
+    s.log.Debugf("running mapping %d for %d changed entities", index, len(ids))
+
+    _, err := syncer.RunExtEntities(index, ids)
+    if err != nil {
+      s.log.Errorf("run of mapping %d for changed entities failed: %v", index, err)
+    }
+  }
+}
+
+func newDebouncer(
+  delay             time.Duration,
+  flush             func(map[changeTarget][]string),
+) *debouncer {
+  return &debouncer{
+    delay: delay,
+    pending: make(map[changeTarget]map[string]struct{}),
+    flush: flush,
+  }
+}
+
+func (d *debouncer) add(target changeTarget, id string) {
+  d.lock.Lock()
+  defer d.lock.Unlock()
+
+  if d.stopped {
+    return
+  }
+
+  d.queue(target, id)
+  d.schedule(d.delay)
+}
+
+// requeue queues changes that could not be run yet. They are retried after
+// the debounce delay, or after minRetryDelay if it is longer, so that a short
+// debounce delay does not retry continuously while a run is in progress.
+func (d *debouncer) requeue(changes map[changeTarget][]string) {
+  d.lock.Lock()
+  defer d.lock.Unlock()
+
+  if d.stopped {
+    return
+  }
+
+  for target, ids := range changes {
+    for _, id := range ids {
+      d.queue(target, id)
+    }
+  }
+
+  delay := d.delay
+  if delay < minRetryDelay {
+    delay = minRetryDelay
+  }
+
+  d.schedule(delay)
+}
+
+// queue adds an ID to the pending changes. The caller must hold the lock.
+func (d *debouncer) queue(target changeTarget, id string) {
+  ids, ok := d.pending[target]
+  if !ok {
+    ids = make(map[string]struct{})
+    d.pending[target] = ids
+  }
+
+  ids[id] = struct{}{}
+}
+
+// schedule flushes the pending changes after the given delay. The caller must
+// hold the lock.
+func (d *debouncer) schedule(delay time.Duration) {
+  if d.timer == nil {
+    d.timer = time.AfterFunc(delay, d.fire)
+    return
+  }
+
+  d.timer.Reset(delay)
+}
+
+func (d *debouncer) fire() {
+  d.flush(d.drain())
+}
+
+// drain removes and returns all pending changes and stops the timer.
+func (d *debouncer) drain() map[changeTarget][]string {
+  d.lock.Lock()
+
+  pending := d.pending
+  d.pending = make(map[changeTarget]map[string]struct{})
+
+  if d.timer != nil {
+    d.timer.Stop()
+    d.timer = nil
+  }
+
+  d.lock.Unlock()
+
+  changes := make(map[changeTarget][]string, len(pending))
+
+  for target, ids := range pending {
+    for id := range ids {
+      changes[target] = append(changes[target], id)
+    }
+  }
+
+  return changes
+}
+
+// stop stops the timer. Changes added after the debouncer is stopped are
+// ignored.
+func (d *debouncer) stop() {
+  d.lock.Lock()
+  defer d.lock.Unlock()
+
+  d.stopped = true
+
+  if d.timer != nil {
+    d.timer.Stop()
+    d.timer = nil
+  }
+}
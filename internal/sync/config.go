@@ -1,18 +1,12 @@
 package sync
 
-type Tag struct {
-  Key               string
-  Values            []string
-}
+import (
+	"github.com/newrelic/nr-entity-tag-sync/internal/nerdgraph"
+)
 
-type EntityQuery struct {
-  Type              []string
-  Domain            []string
-  Name              string
-  AccountId         int
-  Tags              []Tag
-  Query             string
-}
+type Tag = nerdgraph.Tag
+
+type EntityQuery = nerdgraph.EntityQuery
 
 type Match struct {
   ExtEntityKey      string
@@ -22,11 +16,41 @@ type Match struct {
 
 type Mapping map[string]string
 
+// Relationship declares the New Relic entity relationships that are traversed
+// from each matched entity so that related entities inherit its tags.
+type Relationship struct {
+  Type              string
+  Direction         string
+  Depth             int
+}
+
 type MappingConfig struct {
+  Name              string
+  Enabled           *bool
+  Labels            []string
+  Provider          string
+  Schedule          string
   ExtEntityQuery    map[string]interface{}
   EntityQuery       EntityQuery
   Match             Match
   Mapping           Mapping
+  Relationships     []Relationship
+}
+
+// IsEnabled returns true unless the mapping has been explicitly disabled.
+func (m *MappingConfig) IsEnabled() bool {
+  return m.Enabled == nil || *m.Enabled
+}
+
+// HasLabel returns true if the mapping has the given label.
+func (m *MappingConfig) HasLabel(label string) bool {
+  for _, l := range m.Labels {
+    if l == label {
+      return true
+    }
+  }
+
+  return false
 }
 
 type Mappings []MappingConfig
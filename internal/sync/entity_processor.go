@@ -1,11 +1,11 @@
 package sync
 
 import (
+	"context"
 	"fmt"
-	"strings"
 
-	"github.com/newrelic/newrelic-client-go/pkg/common"
-	"github.com/newrelic/newrelic-client-go/pkg/entities"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/newrelic/nr-entity-tag-sync/internal/nerdgraph"
 	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
 )
 
@@ -18,62 +18,9 @@ const (
   ENTITY_UPDATE_ERR
 )
 
-type EntityOutline struct {
-  Guid              common.EntityGUID
-  Name              string
-  AccountID         int
-  EntityType        entities.EntityType
-  Domain            string
-  Type              string
-  Tags              []entities.EntityTag
-}
-
-const (
-  entitySearchResultQuery = `
-    entities {
-      guid
-      name
-      accountId
-      entityType
-      domain
-      type
-      tags {
-        key
-        values
-      }
-    }
-    nextCursor
-  `
-  getEntitySearchByQuery = `query(
-    $query: String,
-  ) { actor { entitySearch(
-    query: $query,
-  ) {
-    count
-    results {` + entitySearchResultQuery + "} } } }"
-  getEntitySearchByQueryWithCursor = `query(
-    $query: String,
-    $cursor: String,
-  ) { actor { entitySearch(
-    query: $query,
-  ) {
-    count
-    results(
-      cursor: $cursor,
-    ) {` + entitySearchResultQuery + "} } } }"
-)
-
-type entitySearchResponse struct {
-  Actor struct {
-    EntitySearch struct {
-      Count int
-      Results struct {
-        Entities []EntityOutline
-        NextCursor string
-      }
-    }
-  }
-}
+// EntityOutline is the outline of a New Relic entity returned by an entity
+// search.
+type EntityOutline = nerdgraph.EntityOutline
 
 type entityProcessingResult struct {
   totalEntities             int
@@ -92,19 +39,30 @@ type entityProcessorFn func (
 ) (entityProcessorResult, []error)
 
 func processEntities(
+  ctx context.Context,
   i *interop.Interop,
   mapping *MappingConfig,
   entityProcessor entityProcessorFn,
 ) (*entityProcessingResult, error) {
+  txn := newrelic.FromContext(ctx)
   processingResult := &entityProcessingResult{}
-  query := buildQuery(&mapping.EntityQuery)
+  query := nerdgraph.BuildQuery(&mapping.EntityQuery)
   nextCursor := ""
 
+  client, err := i.Client(mapping.EntityQuery.Profile)
+  if err != nil {
+    return processingResult, err
+  }
+
   i.Logger.Debugf("fetching New Relic entities for query: \"%s\"", query)
 
   for done := false; !done; {
-    resp, err := getEntities(i, query, nextCursor)
+    segment := txn.StartSegment("EntitySearch/Page")
+
+    resp, err := nerdgraph.SearchEntities(ctx, i, client, query, nextCursor)
     if err != nil {
+      segment.End()
+      recordNerdGraphError("entity_search")
       return processingResult,
         fmt.Errorf("graphql error fetching entities: %s", err)
     }
@@ -165,6 +123,9 @@ func processEntities(
     processingResult.totalEntitiesScanned += len(entitySearch.Results.Entities)
     nextCursor = entitySearch.Results.NextCursor
 
+    segment.AddAttribute("entityCount", len(entitySearch.Results.Entities))
+    segment.End()
+
     if nextCursor == "" {
       done = true
     }
@@ -172,99 +133,3 @@ func processEntities(
 
   return processingResult, nil
 }
-
-func buildQuery(entityQuery *EntityQuery) string {
-  if entityQuery.Query != "" {
-    return entityQuery.Query
-  }
-
-  var parts []string
-
-  if len(entityQuery.Domain) > 0 {
-    parts = append(
-      parts,
-      fmt.Sprintf("domain IN ('%s')", strings.Join(entityQuery.Domain, "','")),
-    )
-  }
-
-  if len(entityQuery.Type) > 0 {
-    parts = append(
-      parts,
-      fmt.Sprintf("type IN ('%s')", strings.Join(entityQuery.Type, "','")),
-    )
-  }
-
-  if entityQuery.Name != "" {
-    parts = append(
-      parts,
-      fmt.Sprintf("name LIKE '%s'", entityQuery.Name),
-    )
-  }
-
-  if entityQuery.AccountId != 0 {
-    parts = append(
-      parts,
-      fmt.Sprintf("tags.`accountId` = %d", entityQuery.AccountId),
-    )
-  }
-
-  if len(entityQuery.Tags) > 0 {
-    var tags []string
-
-    for _, tag := range entityQuery.Tags {
-      tags = append(
-        tags,
-        fmt.Sprintf(
-          "tags.`%s` IN ('%s')",
-          tag.Key,
-          strings.Join(tag.Values, "','"),
-        ),
-      )
-    }
-
-    parts = append(
-      parts,
-      strings.Join(tags, " AND "),
-    )
-  }
-
-  return strings.Join(parts, " AND ")
-}
-
-func getEntities(
-  i                 *interop.Interop,
-  query             string,
-  cursor            string,
-) (*entitySearchResponse, error) {
-  var resp entitySearchResponse
-
-  vars := map[string]interface{}{
-    "query":   query,
-  }
-
-  if cursor != "" {
-    vars["cursor"] = cursor
-
-    i.Logger.Tracef("running query using cursor: %s", cursor)
-
-    if err := i.NrClient.NerdGraph.QueryWithResponse(
-      getEntitySearchByQueryWithCursor,
-      vars,
-      &resp,
-    ); err != nil {
-      return nil, err
-    }
-
-    return &resp, nil
-  }
-
-  if err := i.NrClient.NerdGraph.QueryWithResponse(
-    getEntitySearchByQuery,
-    vars,
-    &resp,
-  ); err != nil {
-    return nil, err
-  }
-
-  return &resp, nil
-}
@@ -1,74 +1,237 @@
 package sync
 
 import (
-	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/newrelic/newrelic-client-go/pkg/nrdb"
+	"github.com/newrelic/nr-entity-tag-sync/internal/version"
 )
 
 type auditEvent map[string]interface{}
 
+type syncRun struct {
+  id                uuid.UUID
+  startTime         time.Time
+  extEntityIds      []string
+  dryRun            bool
+}
+
+type mappingRun struct {
+  index             int
+  name              string
+  labels            []string
+  provider          *providerInstance
+  profile           string
+  startTime         time.Time
+  lastUpdate        *time.Time
+  pageCount         int
+  extEntityCount    int
+  processingResults *entityProcessingResult
+  changes           []EntityChange
+  relationships     []*RelationshipResult
+  tagged            map[string]bool
+}
+
+func (m *mappingRun) displayName() string {
+  if m.name != "" {
+    return fmt.Sprintf("mapping %d (%s)", m.index, m.name)
+  }
+
+  return fmt.Sprintf("mapping %d", m.index)
+}
+
 type eventsConfig struct {
   Enabled           bool
   AccountId         int
   EventType         string
 }
 
+func (s *Syncer) eventsEnabled(run *syncRun) bool {
+  return s.eventsConfig.Enabled && !run.dryRun
+}
+
 func (s *Syncer) newAuditEvent(
-  uuid uuid.UUID,
-  action string,
-  err error,
+  run               *syncRun,
+  action            string,
+  err               error,
 ) auditEvent {
   event := auditEvent{}
 
   event["eventType"] = s.eventsConfig.EventType
-  event["id"] = uuid.String()
+  event["id"] = run.id.String()
   event["action"] = action
   event["error"] = err != nil
   if err != nil {
     event["errorMessage"] = err.Error()
   }
 
+  event["version"] = version.Version
+  event["commit"] = version.Commit
+  event["providerType"] = s.providerType
+  event["deltaMode"] = s.useLastUpdate
+
+  // Runs for individual external entities are marked so that they are not
+  // used as the last update timestamp for delta synchronization
+  if run.extEntityIds != nil {
+    event["trigger"] = "webhook"
+  }
+
+  if s.hostname != "" {
+    event["host"] = s.hostname
+  }
+
+  if s.requestId != "" {
+    event["requestId"] = s.requestId
+  }
+
+  return event
+}
+
+func (s *Syncer) newMappingAuditEvent(
+  run               *syncRun,
+  mapping           *mappingRun,
+  err               error,
+) auditEvent {
+  event := s.newAuditEvent(run, "mapping_complete", err)
+
+  event["mappingIndex"] = mapping.index
+  if mapping.name != "" {
+    event["mappingName"] = mapping.name
+  }
+  if len(mapping.labels) > 0 {
+    event["mappingLabels"] = strings.Join(mapping.labels, ",")
+  }
+  if mapping.provider != nil {
+    event["providerName"] = mapping.provider.name
+    event["providerType"] = mapping.provider.providerType
+    event["deltaMode"] = mapping.provider.useLastUpdate
+  }
+  if mapping.profile != "" {
+    event["profileName"] = mapping.profile
+  }
+  if mapping.lastUpdate != nil {
+    event["lastUpdateTimestamp"] = mapping.lastUpdate.UnixMilli()
+  }
+  event["mappingDurationMs"] = time.Since(mapping.startTime).Milliseconds()
+  event["providerPageCount"] = mapping.pageCount
+
   return event
 }
 
+// addRelationshipAttributes adds the counters of each relationship of a mapping
+// to a mapping event, prefixed with the relationship type and direction, e.g.
+// relationship.hosts.inbound.totalEntitiesUpdated.
+func addRelationshipAttributes(
+  event             auditEvent,
+  relationships     []*RelationshipResult,
+) {
+  for _, r := range relationships {
+    prefix := fmt.Sprintf(
+      "relationship.%s.%s.",
+      strings.ToLower(r.Type),
+      r.Direction,
+    )
+
+    counters := map[string]int{
+      "totalEntitiesScanned": r.TotalEntitiesScanned,
+      "totalEntitiesSkipped": r.TotalEntitiesSkipped,
+      "totalEntitiesUpdated": r.TotalEntitiesUpdated,
+      "totalEntitiesWithErrors": r.TotalEntitiesWithErrors,
+      "totalTraversalErrors": r.TotalTraversalErrors,
+    }
+
+    // Relationships with the same type and direction share their counters
+    for name, value := range counters {
+      total, _ := event[prefix + name].(int)
+      event[prefix + name] = total + value
+    }
+  }
+}
+
+// RecordConfigReload records an audit event for an attempt to reload the
+// configuration. The generation is the generation of the configuration in use
+// after the attempt.
+func (s *Syncer) RecordConfigReload(generation int64, err error) {
+  if !s.eventsConfig.Enabled {
+    return
+  }
+
+  id, idErr := uuid.NewV4()
+  if idErr != nil {
+    s.log.Warnf("failed to generate event id: %v", idErr)
+  }
+
+  event := s.newAuditEvent(&syncRun{ id: id }, "config_reload", err)
+  event["configGeneration"] = generation
+
+  s.pushEvent(event)
+}
+
 func (s *Syncer ) pushEvent(event auditEvent) {
-  if err := s.i.NrClient.Events.EnqueueEvent(context.Background(), event);
-    err != nil {
+  if err := s.i.EnqueueEvent("", map[string]interface{}(event)); err != nil {
     s.log.Warnf("failed to push event: %s", err)
   }
 }
 
-func (s *Syncer) getLastUpdateTimestamp() (*time.Time, error) {
-  if !s.useLastUpdate {
-    return nil, nil
+// pushMappingEvent pushes an event for a mapping to the events account of the
+// New Relic profile used by the mapping, if it has one.
+func (s *Syncer) pushMappingEvent(mapping *mappingRun, event auditEvent) {
+  if err := s.i.EnqueueEvent(mapping.profile, map[string]interface{}(event)); err != nil {
+    s.log.Warnf("failed to push event: %s", err)
   }
+}
 
+// getLastUpdateTimestamp returns the time of the last successful run of the
+// mapping. Each mapping has its own timestamp so that runs of a subset of the
+// mappings, such as mappings with their own schedule or mappings selected on
+// the command line, do not move the timestamp of the mappings they skip.
+// Mappings are identified by name or, for mappings without a name, by index
+// and provider.
+func (s *Syncer) getLastUpdateTimestamp(mapping *mappingRun) (*time.Time, error) {
   if !s.eventsConfig.Enabled {
     return nil, fmt.Errorf("events must be enabled to use last timestamp")
   }
 
+  filter := fmt.Sprintf(
+    "mappingIndex = %d AND mappingName IS NULL AND providerName = '%s'",
+    mapping.index,
+    escapeNrqlString(mapping.provider.name),
+  )
+  if mapping.name != "" {
+    filter = fmt.Sprintf("mappingName = '%s'", escapeNrqlString(mapping.name))
+  }
+
+  // Mapping events are posted to the events account of the profile used by
+  // the mapping
+  client, accountId := s.i.EventsAccount(mapping.profile)
+
   s.log.Tracef(
-    "querying for latest timestamp for event type %s",
+    "querying for latest timestamp of %s for event type %s",
+    mapping.displayName(),
     s.eventsConfig.EventType,
   )
 
-  result, err := s.i.NrClient.Nrdb.Query(
-    s.eventsConfig.AccountId,
+  result, err := client.Nrdb.Query(
+    accountId,
     nrdb.NRQL(fmt.Sprintf(
-      "SELECT latest(timestamp) FROM %s WHERE action = 'sync_end' SINCE 1 MONTH AGO",
+      "SELECT latest(timestamp) FROM %s WHERE action = 'mapping_complete' AND error IS FALSE AND trigger IS NULL AND %s SINCE 1 MONTH AGO",
       s.eventsConfig.EventType,
+      filter,
     )),
   )
   if err != nil {
+    recordNerdGraphError("nrql_query")
     return nil, fmt.Errorf("query for last update failed: %s", err)
   }
 
   if len(result.Results) == 0 {
-    s.log.Warn("no results found searching for last update timetaamp")
+    s.log.Warnf(
+      "no results found searching for last update timestamp of %s",
+      mapping.displayName(),
+    )
     return nil, nil
   }
 
@@ -81,7 +244,8 @@ func (s *Syncer) getLastUpdateTimestamp() (*time.Time, error) {
 
   latestTimestamp, ok := val.(float64)
   if !ok {
-    s.log.Warn("timestamp attribute found in result is not a float")
+    // latest() returns null when no events match
+    s.log.Debugf("no previous run found for %s", mapping.displayName())
     return nil, nil
   }
 
@@ -90,3 +254,7 @@ func (s *Syncer) getLastUpdateTimestamp() (*time.Time, error) {
 
   return &t, nil
 }
+
+func escapeNrqlString(value string) string {
+  return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
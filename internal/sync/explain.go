@@ -0,0 +1,247 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/nerdgraph"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+)
+
+// ExplainCandidate describes how a single external entity was compared with
+// the New Relic entity being explained.
+type ExplainCandidate struct {
+  ExtEntityId               string          `json:"extEntityId"`
+  HasExtEntityKey           bool            `json:"hasExtEntityKey"`
+  ExtEntityKeyValue         string          `json:"extEntityKeyValue,omitempty"`
+  Matched                   bool            `json:"matched"`
+}
+
+// ExplainMappingResult describes how a single mapping applies to the New Relic
+// entity being explained.
+type ExplainMappingResult struct {
+  Index                     int             `json:"index"`
+  Name                      string          `json:"name,omitempty"`
+  Error                     string          `json:"error,omitempty"`
+  ExtEntityCount            int             `json:"extEntityCount"`
+  InEntityQuery             bool            `json:"inEntityQuery"`
+  EntityGuid                string          `json:"entityGuid,omitempty"`
+  EntityName                string          `json:"entityName,omitempty"`
+  EntityKey                 string          `json:"entityKey"`
+  HasEntityKey              bool            `json:"hasEntityKey"`
+  EntityKeyValue            string          `json:"entityKeyValue,omitempty"`
+  ExtEntityKey              string          `json:"extEntityKey"`
+  Operator                  string          `json:"operator"`
+  Candidates                []ExplainCandidate `json:"candidates,omitempty"`
+  MatchedExtEntityId        string          `json:"matchedExtEntityId,omitempty"`
+  Change                    *EntityChange   `json:"change,omitempty"`
+}
+
+// ExplainResult describes how each mapping applies to a New Relic entity.
+type ExplainResult struct {
+  Entity                    string                  `json:"entity"`
+  Mappings                  []ExplainMappingResult  `json:"mappings"`
+}
+
+// Explain walks the mappings at the given indices and reports, for the New
+// Relic entity with the given GUID or name, whether the entity is selected by
+// the mapping, the value of the entity match key, the result of comparing it
+// with each external entity and what tag changes would be applied. No tag
+// changes are applied. Delta synchronization is not used so that all external
+// entities are considered.
+func (s *Syncer) Explain(indices []int, entity string) (*ExplainResult, error) {
+  return s.ExplainContext(context.Background(), indices, entity)
+}
+
+// ExplainContext is like Explain but uses the given context.
+func (s *Syncer) ExplainContext(
+  ctx               context.Context,
+  indices           []int,
+  entity            string,
+) (*ExplainResult, error) {
+  result := &ExplainResult{ Entity: entity, Mappings: []ExplainMappingResult{} }
+
+  for _, index := range indices {
+    if index < 0 || index >= len(s.mappings) {
+      return nil, fmt.Errorf("invalid mapping index %d", index)
+    }
+
+    mappingResult := s.explainMapping(ctx, index, &s.mappings[index], entity)
+
+    result.Mappings = append(result.Mappings, *mappingResult)
+  }
+
+  return result, nil
+}
+
+func (s *Syncer) explainMapping(
+  ctx               context.Context,
+  index             int,
+  mappingConfig     *MappingConfig,
+  target            string,
+) *ExplainMappingResult {
+  result := &ExplainMappingResult{
+    Index: index,
+    Name: mappingConfig.Name,
+    EntityKey: mappingConfig.Match.EntityKey,
+    ExtEntityKey: mappingConfig.Match.ExtEntityKey,
+    Operator: mappingConfig.Match.Operator,
+  }
+
+  extEntityTags := []string { mappingConfig.Match.ExtEntityKey }
+  extEntityTags = append(extEntityTags, getKeys(mappingConfig.Mapping)...)
+
+  instance, err := s.getProvider(mappingConfig)
+  if err != nil {
+    result.Error = err.Error()
+    return result
+  }
+
+  p, err := instance.get(s.i)
+  if err != nil {
+    result.Error = err.Error()
+    return result
+  }
+
+  if err := p.ValidateQuery(mappingConfig.ExtEntityQuery); err != nil {
+    result.Error = fmt.Sprintf("invalid extEntityQuery: %v", err)
+    return result
+  }
+
+  extEntities, err := provider.GetEntities(
+    ctx,
+    p,
+    &provider.Query{
+      Config: mappingConfig.ExtEntityQuery,
+      Tags: extEntityTags,
+    },
+  )
+  if err != nil {
+    result.Error = fmt.Sprintf("reading entities from provider failed: %v", err)
+    return result
+  }
+
+  result.ExtEntityCount = len(extEntities)
+
+  // Narrow the entity search to the target entity
+  query := fmt.Sprintf(
+    "(id = '%s' OR name = '%s')",
+    escapeQueryValue(target),
+    escapeQueryValue(target),
+  )
+
+  if baseQuery := nerdgraph.BuildQuery(&mappingConfig.EntityQuery); baseQuery != "" {
+    query = fmt.Sprintf("(%s) AND %s", baseQuery, query)
+  }
+
+  explainConfig := *mappingConfig
+  explainConfig.EntityQuery = EntityQuery{ Query: query }
+
+  _, err = processEntities(
+    ctx,
+    s.i,
+    &explainConfig,
+    func (
+      i                 *interop.Interop,
+      config            *MappingConfig,
+      entity            *EntityOutline,
+    ) (entityProcessorResult, []error) {
+      if result.InEntityQuery || !isExplainTarget(entity, target) {
+        return ENTITY_NO_MATCH, nil
+      }
+
+      result.InEntityQuery = true
+      result.EntityGuid = string(entity.Guid)
+      result.EntityName = entity.Name
+
+      extEntity := explainMatch(s.i, result, entity, &config.Match, extEntities)
+      if extEntity == nil {
+        return ENTITY_NO_MATCH, nil
+      }
+
+      result.MatchedExtEntityId = extEntity.ID
+
+      diff := diffTags(s.i, config.Mapping, extEntity, entity)
+      if diff.empty() {
+        return ENTITY_UPDATE_NONE, nil
+      }
+
+      change := newEntityChange(entity, extEntity, diff)
+      result.Change = &change
+
+      return ENTITY_UPDATE_OK, nil
+    },
+  )
+  if err != nil {
+    result.Error = err.Error()
+  }
+
+  return result
+}
+
+// explainMatch compares the entity with each external entity in the same way
+// as getMatchingEntity, recording the result of each comparison. It returns
+// the first matching external entity, which is the one getMatchingEntity
+// would return.
+func explainMatch(
+  i                 *interop.Interop,
+  result            *ExplainMappingResult,
+  entity            *EntityOutline,
+  match             *Match,
+  extEntities       []provider.Entity,
+) *provider.Entity {
+  entityKeyValue, entityKeyExists := getEntityKeyValue(
+    i,
+    entity,
+    match.EntityKey,
+  )
+
+  result.HasEntityKey = entityKeyExists && entityKeyValue != ""
+  result.EntityKeyValue = entityKeyValue
+
+  if !result.HasEntityKey {
+    return nil
+  }
+
+  var matched *provider.Entity
+
+  for index := range extEntities {
+    extEntity := &extEntities[index]
+    candidate := ExplainCandidate{ ExtEntityId: extEntity.ID }
+
+    extEntityKeyValue, extEntityKeyExists := getExtEntityKeyValue(
+      i,
+      extEntity,
+      match.ExtEntityKey,
+    )
+
+    candidate.HasExtEntityKey = extEntityKeyExists && extEntityKeyValue != ""
+    candidate.ExtEntityKeyValue = extEntityKeyValue
+
+    if candidate.HasExtEntityKey {
+      candidate.Matched = matchKeyValues(
+        match.Operator,
+        extEntityKeyValue,
+        entityKeyValue,
+      )
+    }
+
+    if candidate.Matched && matched == nil {
+      matched = extEntity
+    }
+
+    result.Candidates = append(result.Candidates, candidate)
+  }
+
+  return matched
+}
+
+func isExplainTarget(entity *EntityOutline, target string) bool {
+  return string(entity.Guid) == target || strings.EqualFold(entity.Name, target)
+}
+
+func escapeQueryValue(s string) string {
+  return strings.ReplaceAll(s, "'", "\\'")
+}
@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/metrics"
+)
+
+func recordRunMetrics(run *syncRun, err error) {
+  result := "success"
+  if err != nil {
+    result = "failure"
+  }
+
+  metrics.RunsTotal.WithLabelValues(result).Inc()
+  metrics.RunDuration.Observe(time.Since(run.startTime).Seconds())
+}
+
+func (s *Syncer) recordMappingMetrics(
+  mapping           *mappingRun,
+  processingResults *entityProcessingResult,
+  err               error,
+) {
+  app := s.i.App
+
+  app.RecordCustomMetric(
+    "Custom/EntityTagSync/Entities/Scanned",
+    float64(processingResults.totalEntitiesScanned),
+  )
+  app.RecordCustomMetric(
+    "Custom/EntityTagSync/Entities/Matched",
+    float64(processingResults.totalEntitiesMatched),
+  )
+  app.RecordCustomMetric(
+    "Custom/EntityTagSync/Entities/Updated",
+    float64(processingResults.totalEntitiesUpdated),
+  )
+  app.RecordCustomMetric(
+    "Custom/EntityTagSync/Entities/Failed",
+    float64(processingResults.totalEntitiesWithErrors),
+  )
+
+  label := metrics.MappingLabel(mapping.index, mapping.name)
+  outcomes := map[string]int{
+    "scanned": processingResults.totalEntitiesScanned,
+    "matched": processingResults.totalEntitiesMatched,
+    "no_match": processingResults.totalEntitiesNoMatch,
+    "skipped": processingResults.totalEntitiesSkipped,
+    "updated": processingResults.totalEntitiesUpdated,
+    "errors": processingResults.totalEntitiesWithErrors,
+  }
+
+  for outcome, count := range outcomes {
+    metrics.MappingEntitiesTotal.WithLabelValues(label, outcome).Add(
+      float64(count),
+    )
+  }
+
+  recordMappingDuration(mapping)
+
+  if err == nil && processingResults.totalEntitiesWithErrors == 0 {
+    recordMappingSuccess(mapping)
+  }
+}
+
+func recordMappingDuration(mapping *mappingRun) {
+  metrics.MappingDuration.WithLabelValues(
+    metrics.MappingLabel(mapping.index, mapping.name),
+  ).Observe(time.Since(mapping.startTime).Seconds())
+}
+
+func recordMappingSuccess(mapping *mappingRun) {
+  metrics.LastSuccessTimestamp.WithLabelValues(
+    metrics.MappingLabel(mapping.index, mapping.name),
+  ).SetToCurrentTime()
+}
+
+func recordProviderPages(providerType string, mapping *mappingRun) {
+  metrics.ProviderPagesTotal.WithLabelValues(
+    providerType,
+    metrics.MappingLabel(mapping.index, mapping.name),
+  ).Add(float64(mapping.pageCount))
+}
+
+func recordNerdGraphError(errorType string) {
+  metrics.NerdGraphErrorsTotal.WithLabelValues(errorType).Inc()
+}
@@ -0,0 +1,217 @@
+package sync
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	stdsync "sync"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// DEFAULT_PROVIDER is the name of the provider configured in the provider
+// section and the provider used by mappings that do not specify one.
+const DEFAULT_PROVIDER = "default"
+
+// providerInstance is a named provider configuration. The provider is
+// initialized the first time it is used.
+type providerInstance struct {
+  name              string
+  providerType      string
+  useLastUpdate     bool
+  config            map[string]interface{}
+  v                 *viper.Viper
+  lock              stdsync.Mutex
+  provider          provider.ProviderV2
+}
+
+// get returns the provider, initializing it if necessary.
+func (p *providerInstance) get(i *interop.Interop) (provider.ProviderV2, error) {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+
+  if p.provider != nil {
+    return p.provider, nil
+  }
+
+  i.Logger.Debugf("initializing provider %s of type %s", p.name, p.providerType)
+
+  instance, err := provider.NewProvider(i, p.providerType, p.v)
+  if err != nil {
+    return nil, fmt.Errorf("failed to initialize provider %s: %v", p.name, err)
+  }
+
+  if p.useLastUpdate && !instance.Capabilities().Delta {
+    i.Logger.Warnf(
+      "provider %s of type %s does not support delta synchronization; useLastUpdate is ignored",
+      p.name,
+      p.providerType,
+    )
+  }
+
+  p.provider = instance
+
+  return instance, nil
+}
+
+// delta returns true if the last update time should be passed to the given
+// provider.
+func (p *providerInstance) delta(instance provider.ProviderV2) bool {
+  return p.useLastUpdate && instance.Capabilities().Delta
+}
+
+// close closes the provider if it was initialized. The provider is
+// initialized again if it is used after it was closed.
+func (p *providerInstance) close(i *interop.Interop) {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+
+  if p.provider == nil {
+    return
+  }
+
+  i.Logger.Debugf("closing provider %s of type %s", p.name, p.providerType)
+
+  if err := p.provider.Close(); err != nil {
+    i.Logger.Warnf("failed to close provider %s: %v", p.name, err)
+  }
+
+  p.provider = nil
+}
+
+// loadProviders reads the provider configured in the provider section, which
+// is named DEFAULT_PROVIDER, and the named providers configured in the
+// providers section. Providers from the previous syncer are reused when their
+// configuration has not changed. Provider names are case-insensitive.
+func loadProviders(
+  i                 *interop.Interop,
+  previous          *Syncer,
+) (map[string]*providerInstance, error) {
+  providers := map[string]*providerInstance{}
+
+  if i.Config.IsSet("provider") {
+    providers[DEFAULT_PROVIDER] = newProviderInstance(i, DEFAULT_PROVIDER, "provider")
+  }
+
+  for name := range i.Config.GetStringMap("providers") {
+    name = strings.ToLower(name)
+
+    if _, ok := providers[name]; ok {
+      return nil, fmt.Errorf(
+        "provider %s is configured in both the provider and providers sections",
+        name,
+      )
+    }
+
+    providers[name] = newProviderInstance(i, name, "providers." + name)
+  }
+
+  if len(providers) == 0 {
+    return nil, fmt.Errorf("missing provider in config")
+  }
+
+  for name, p := range providers {
+    if p.providerType == "" {
+      return nil, fmt.Errorf("missing provider type for provider %s", name)
+    }
+
+    if previous == nil {
+      continue
+    }
+
+    if prev, ok := previous.providers[name]; ok &&
+      reflect.DeepEqual(prev.config, p.config) {
+      i.Logger.Debugf("config for provider %s unchanged; reusing provider", name)
+      providers[name] = prev
+    }
+  }
+
+  return providers, nil
+}
+
+func newProviderInstance(
+  i                 *interop.Interop,
+  name              string,
+  key               string,
+) *providerInstance {
+  config := i.Config.GetStringMap(key)
+
+  return &providerInstance{
+    name: name,
+    providerType: cast.ToString(config["type"]),
+    useLastUpdate: cast.ToBool(config["uselastupdate"]),
+    config: config,
+    v: i.Config.Sub(key),
+  }
+}
+
+// Close closes the providers of the syncer.
+func (s *Syncer) Close() {
+  for _, p := range s.providers {
+    p.close(s.i)
+  }
+}
+
+// CloseReplacedProviders closes the providers of the syncer that are not
+// reused by the given syncer, which was created by Reload. It must only be
+// called once the given syncer has replaced this one.
+func (s *Syncer) CloseReplacedProviders(next *Syncer) {
+  for name, p := range s.providers {
+    if next.providers[name] != p {
+      p.close(s.i)
+    }
+  }
+}
+
+// getProvider returns the provider instance used by the given mapping.
+func (s *Syncer) getProvider(mappingConfig *MappingConfig) (*providerInstance, error) {
+  p, ok := s.providers[mappingConfig.providerName()]
+  if !ok {
+    return nil, fmt.Errorf("no provider named %s", mappingConfig.Provider)
+  }
+
+  return p, nil
+}
+
+// providerName returns the lowercased name of the provider used by the mapping.
+func (m *MappingConfig) providerName() string {
+  if m.Provider == "" {
+    return DEFAULT_PROVIDER
+  }
+
+  return strings.ToLower(m.Provider)
+}
+
+// providerTypes returns the sorted, comma separated list of the distinct types
+// of the configured providers.
+func providerTypes(providers map[string]*providerInstance) string {
+  seen := map[string]bool{}
+  types := []string{}
+
+  for _, p := range providers {
+    if !seen[p.providerType] {
+      seen[p.providerType] = true
+      types = append(types, p.providerType)
+    }
+  }
+
+  sort.Strings(types)
+
+  return strings.Join(types, ",")
+}
+
+// anyUseLastUpdate returns true if any of the providers use delta
+// synchronization.
+func anyUseLastUpdate(providers map[string]*providerInstance) bool {
+  for _, p := range providers {
+    if p.useLastUpdate {
+      return true
+    }
+  }
+
+  return false
+}
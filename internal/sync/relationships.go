@@ -0,0 +1,236 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	nrClient "github.com/newrelic/newrelic-client-go/newrelic"
+	"github.com/newrelic/nr-entity-tag-sync/internal/nerdgraph"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+)
+
+const (
+  RELATIONSHIP_DIRECTION_INBOUND  = "inbound"
+  RELATIONSHIP_DIRECTION_OUTBOUND = "outbound"
+  RELATIONSHIP_DIRECTION_BOTH     = "both"
+  DEFAULT_RELATIONSHIP_DEPTH      = 1
+  MAX_RELATIONSHIP_DEPTH          = 5
+)
+
+// RelationshipResult describes the related entities that inherited tags
+// through a relationship of a mapping.
+type RelationshipResult struct {
+  Type                      string          `json:"type"`
+  Direction                 string          `json:"direction"`
+  Depth                     int             `json:"depth"`
+  TotalEntitiesScanned      int             `json:"totalEntitiesScanned"`
+  TotalEntitiesSkipped      int             `json:"totalEntitiesSkipped"`
+  TotalEntitiesUpdated      int             `json:"totalEntitiesUpdated"`
+  TotalEntitiesWithErrors   int             `json:"totalEntitiesWithErrors"`
+  TotalTraversalErrors      int             `json:"totalTraversalErrors"`
+}
+
+// entityMatch is a New Relic entity and the external entity it matched.
+type entityMatch struct {
+  extEntity         *provider.Entity
+  entity            EntityOutline
+}
+
+// inheritance identifies the matched entity and the relationship through which
+// a related entity inherits tags.
+type inheritance struct {
+  entity            *EntityOutline
+  relationshipType  string
+}
+
+// newRelationshipResults returns the results for the relationships of a
+// mapping with the defaults applied.
+func newRelationshipResults(relationships []Relationship) []*RelationshipResult {
+  results := make([]*RelationshipResult, len(relationships))
+
+  for index, relationship := range relationships {
+    result := &RelationshipResult{
+      Type: strings.ToUpper(relationship.Type),
+      Direction: strings.ToLower(relationship.Direction),
+      Depth: relationship.Depth,
+    }
+
+    if result.Direction == "" {
+      result.Direction = RELATIONSHIP_DIRECTION_BOTH
+    }
+
+    if result.Depth <= 0 {
+      result.Depth = DEFAULT_RELATIONSHIP_DEPTH
+    } else if result.Depth > MAX_RELATIONSHIP_DEPTH {
+      result.Depth = MAX_RELATIONSHIP_DEPTH
+    }
+
+    results[index] = result
+  }
+
+  return results
+}
+
+// relatedErrorCount returns the number of related entities that could not be
+// updated and relationships that could not be traversed.
+func (m *mappingRun) relatedErrorCount() int {
+  count := 0
+
+  for _, result := range m.relationships {
+    count += result.TotalEntitiesWithErrors + result.TotalTraversalErrors
+  }
+
+  return count
+}
+
+// inheritTags applies the tags of the external entity matched to the given New
+// Relic entity to the entities related to it through the relationships of the
+// mapping. Each relationship is traversed breadth first up to its depth.
+// Entities that were already reached are not traversed again so that cycles
+// in the relationship graph terminate. Entities are tagged at most once per
+// mapping run, so matched entities keep their own tags and an entity related to
+// several matched entities inherits the tags of the first one.
+func (s *Syncer) inheritTags(
+  ctx               context.Context,
+  run               *syncRun,
+  mapping           *mappingRun,
+  client            *nrClient.NewRelic,
+  mappingConfig     *MappingConfig,
+  extEntity         *provider.Entity,
+  entity            *EntityOutline,
+) {
+  for _, result := range mapping.relationships {
+    seen := map[string]bool{ string(entity.Guid): true }
+    guids := []string{ string(entity.Guid) }
+
+    for depth := 1; depth <= result.Depth && len(guids) > 0; depth += 1 {
+      next := []string{}
+
+      for _, guid := range guids {
+        related, err := s.getRelatedEntities(ctx, client, guid, result)
+        if err != nil {
+          result.TotalTraversalErrors += 1
+          s.log.Warnf(
+            "failed to read %s relationships of entity %s: %v",
+            result.Type,
+            guid,
+            err,
+          )
+          continue
+        }
+
+        for _, relatedEntity := range related {
+          relatedGuid := string(relatedEntity.Guid)
+          if seen[relatedGuid] {
+            continue
+          }
+
+          seen[relatedGuid] = true
+          next = append(next, relatedGuid)
+
+          if mapping.tagged[relatedGuid] {
+            s.log.Debugf(
+              "skipping related entity %s (%s); already tagged in this run",
+              relatedEntity.Name,
+              relatedEntity.Guid,
+            )
+            continue
+          }
+
+          mapping.tagged[relatedGuid] = true
+          result.TotalEntitiesScanned += 1
+
+          s.log.Debugf(
+            "New Relic entity %s (%s) inherits tags from %s (%s) through %s relationship",
+            relatedEntity.Name,
+            relatedEntity.Guid,
+            entity.Name,
+            entity.Guid,
+            result.Type,
+          )
+
+          updateResult, errors := s.applyTags(
+            ctx,
+            run,
+            mapping,
+            client,
+            mappingConfig,
+            extEntity,
+            relatedEntity,
+            &inheritance{ entity, result.Type },
+          )
+
+          switch updateResult {
+          case ENTITY_UPDATE_OK:
+            result.TotalEntitiesUpdated += 1
+
+          case ENTITY_UPDATE_NONE:
+            result.TotalEntitiesSkipped += 1
+
+          case ENTITY_UPDATE_ERR:
+            result.TotalEntitiesWithErrors += 1
+
+            for _, err := range errors {
+              s.log.Warnf("error while updating related entity: %s", err)
+            }
+          }
+        }
+      }
+
+      guids = next
+    }
+  }
+}
+
+// getRelatedEntities returns the entities related to the entity with the given
+// GUID through the given relationship. Related entities that are not visible
+// to the user of the API key are ignored.
+func (s *Syncer) getRelatedEntities(
+  ctx               context.Context,
+  client            *nrClient.NewRelic,
+  guid              string,
+  relationship      *RelationshipResult,
+) ([]*EntityOutline, error) {
+  txn := newrelic.FromContext(ctx)
+  related := []*EntityOutline{}
+  cursor := ""
+
+  for {
+    segment := txn.StartSegment("RelatedEntities/Page")
+
+    resp, err := nerdgraph.GetRelatedEntities(
+      ctx,
+      client,
+      guid,
+      relationship.Type,
+      strings.ToUpper(relationship.Direction),
+      cursor,
+    )
+
+    segment.End()
+
+    if err != nil {
+      recordNerdGraphError("related_entities")
+      return nil, fmt.Errorf("graphql error fetching related entities: %s", err)
+    }
+
+    for index := range resp.Results {
+      vertex := &resp.Results[index].Target
+      if string(vertex.Guid) == guid {
+        vertex = &resp.Results[index].Source
+      }
+
+      if vertex.Entity != nil {
+        related = append(related, vertex.Entity)
+      }
+    }
+
+    if resp.NextCursor == "" || resp.NextCursor == cursor {
+      return related, nil
+    }
+
+    cursor = resp.NextCursor
+  }
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+)
+
+// EntityChange describes the tag changes that would be applied to a New Relic
+// entity to bring it in line with a matching external entity. For entities
+// that inherit tags through a relationship, InheritedFrom is the GUID of the
+// matched entity and Relationship is the type of the relationship.
+type EntityChange struct {
+  EntityGuid                string          `json:"entityGuid"`
+  EntityName                string          `json:"entityName"`
+  ExtEntityId               string          `json:"extEntityId"`
+  TagsToDelete              []string        `json:"tagsToDelete,omitempty"`
+  TagsToAdd                 []Tag           `json:"tagsToAdd,omitempty"`
+  InheritedFrom             string          `json:"inheritedFrom,omitempty"`
+  Relationship              string          `json:"relationship,omitempty"`
+}
+
+// MappingResult describes the outcome of processing a single mapping during a
+// sync run.
+type MappingResult struct {
+  Index                     int             `json:"index"`
+  Name                      string          `json:"name,omitempty"`
+  StartTime                 time.Time       `json:"startTime"`
+  EndTime                   time.Time       `json:"endTime"`
+  Error                     string          `json:"error,omitempty"`
+  ExtEntityCount            int             `json:"extEntityCount"`
+  ProviderPageCount         int             `json:"providerPageCount"`
+  TotalEntityCount          int             `json:"totalEntityCount"`
+  TotalEntitiesScanned      int             `json:"totalEntitiesScanned"`
+  TotalEntitiesMatched      int             `json:"totalEntitiesMatched"`
+  TotalEntitiesNoMatch      int             `json:"totalEntitiesNoMatch"`
+  TotalEntitiesSkipped      int             `json:"totalEntitiesSkipped"`
+  TotalEntitiesUpdated      int             `json:"totalEntitiesUpdated"`
+  TotalEntitiesWithErrors   int             `json:"totalEntitiesWithErrors"`
+  Changes                   []EntityChange  `json:"changes,omitempty"`
+  Relationships             []RelationshipResult `json:"relationships,omitempty"`
+}
+
+// SyncResult describes the outcome of a sync run.
+type SyncResult struct {
+  Id                        string          `json:"id"`
+  StartTime                 time.Time       `json:"startTime"`
+  EndTime                   time.Time       `json:"endTime"`
+  Error                     string          `json:"error,omitempty"`
+  Mappings                  []MappingResult `json:"mappings"`
+}
+
+func newMappingResult(mapping *mappingRun, err error) MappingResult {
+  result := MappingResult{
+    Index: mapping.index,
+    Name: mapping.name,
+    StartTime: mapping.startTime,
+    EndTime: time.Now(),
+    ExtEntityCount: mapping.extEntityCount,
+    ProviderPageCount: mapping.pageCount,
+    Changes: mapping.changes,
+  }
+
+  for _, relationship := range mapping.relationships {
+    result.Relationships = append(result.Relationships, *relationship)
+  }
+
+  if err != nil {
+    result.Error = err.Error()
+  }
+
+  if r := mapping.processingResults; r != nil {
+    result.TotalEntityCount = r.totalEntities
+    result.TotalEntitiesScanned = r.totalEntitiesScanned
+    result.TotalEntitiesMatched = r.totalEntitiesMatched
+    result.TotalEntitiesNoMatch = r.totalEntitiesNoMatch
+    result.TotalEntitiesSkipped = r.totalEntitiesSkipped
+    result.TotalEntitiesUpdated = r.totalEntitiesUpdated
+    result.TotalEntitiesWithErrors = r.totalEntitiesWithErrors
+  }
+
+  return result
+}
+
+func (r *SyncResult) complete(err error) (*SyncResult, error) {
+  r.EndTime = time.Now()
+
+  if err != nil {
+    r.Error = err.Error()
+  }
+
+  return r, err
+}
+
+func newEntityChange(
+  entity            *EntityOutline,
+  extEntity         *provider.Entity,
+  diff              *tagDiff,
+) EntityChange {
+  change := EntityChange{
+    EntityGuid: string(entity.Guid),
+    EntityName: entity.Name,
+    ExtEntityId: extEntity.ID,
+    TagsToDelete: diff.tagsToDelete,
+  }
+
+  for _, tag := range diff.tagsToAdd {
+    change.TagsToAdd = append(change.TagsToAdd, Tag{ Key: tag.Key, Values: tag.Values })
+  }
+
+  return change
+}
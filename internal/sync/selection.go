@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SelectMappings returns the indices of the mappings identified by the given
+// selectors and labels. Each selector is either a mapping name or a zero-based
+// mapping index and selects the mapping even if it is disabled. Each label
+// selects all enabled mappings that have the label. If no selectors or labels
+// are given, the indices of all enabled mappings are returned.
+func (s *Syncer) SelectMappings(selectors []string, labels []string) ([]int, error) {
+  indices := []int{}
+  selected := map[int]bool{}
+
+  add := func(index int) {
+    if !selected[index] {
+      indices = append(indices, index)
+      selected[index] = true
+    }
+  }
+
+  if len(selectors) == 0 && len(labels) == 0 {
+    for i := range s.mappings {
+      if s.mappings[i].IsEnabled() {
+        add(i)
+      }
+    }
+
+    return indices, nil
+  }
+
+  for _, selector := range selectors {
+    found := false
+
+    for i, mapping := range s.mappings {
+      if mapping.Name != "" && mapping.Name == selector {
+        add(i)
+        found = true
+      }
+    }
+
+    if found {
+      continue
+    }
+
+    index, err := strconv.Atoi(selector)
+    if err != nil || index < 0 || index >= len(s.mappings) {
+      return nil, fmt.Errorf("no mapping found for %s", selector)
+    }
+
+    add(index)
+  }
+
+  if len(labels) > 0 {
+    found := false
+
+    for i := range s.mappings {
+      if !s.mappings[i].IsEnabled() {
+        continue
+      }
+
+      for _, label := range labels {
+        if s.mappings[i].HasLabel(label) {
+          add(i)
+          found = true
+          break
+        }
+      }
+    }
+
+    if !found {
+      return nil, fmt.Errorf(
+        "no enabled mappings found with labels %s",
+        strings.Join(labels, ","),
+      )
+    }
+  }
+
+  return indices, nil
+}
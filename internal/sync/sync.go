@@ -1,43 +1,85 @@
 package sync
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	nrClient "github.com/newrelic/newrelic-client-go/newrelic"
 	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
 	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 type Syncer struct {
   i                 *interop.Interop
   log               *logrus.Logger
   mappings          Mappings
-  provider          provider.Provider
+  providers         map[string]*providerInstance
+  providerType      string
   useLastUpdate     bool
   eventsConfig      *eventsConfig
+  hostname          string
+  requestId         string
 }
 
 func New(i *interop.Interop) (*Syncer, error) {
+  return newSyncer(i, nil)
+}
+
+// Reload creates a new syncer from the current configuration. Each provider of
+// this syncer is reused if its configuration has not changed.
+func (s *Syncer) Reload() (*Syncer, error) {
+  return newSyncer(s.i, s)
+}
+
+func newSyncer(i *interop.Interop, previous *Syncer) (*Syncer, error) {
+  if err := ValidateConfig(i.ConfigFileUsed()); err != nil {
+    return nil, err
+  }
+
   mappings := Mappings{}
 
-  err := viper.UnmarshalKey("mappings", &mappings)
+  err := i.Config.UnmarshalKey("mappings", &mappings)
   if err != nil {
     return nil, err
   }
 
-  p, err := provider.GetProvider(i)
+  providers, err := loadProviders(i, previous)
   if err != nil {
     return nil, err
   }
 
+  for index := range mappings {
+    if _, ok := providers[mappings[index].providerName()]; !ok {
+      return nil, fmt.Errorf(
+        "mapping %d refers to unknown provider %s",
+        index,
+        mappings[index].Provider,
+      )
+    }
+  }
+
+  for index := range mappings {
+    profile := mappings[index].EntityQuery.Profile
+    if profile != "" && !i.HasProfile(profile) {
+      return nil, fmt.Errorf(
+        "mapping %d refers to unknown New Relic profile %s",
+        index,
+        profile,
+      )
+    }
+  }
+
   events := &eventsConfig{}
 
-  err = viper.UnmarshalKey("events", events)
+  err = i.Config.UnmarshalKey("events", events)
   if err != nil {
     return nil, fmt.Errorf("error parsing events config: %v", err)
   }
@@ -56,169 +98,644 @@ func New(i *interop.Interop) (*Syncer, error) {
     }
   }
 
+  hostname, err := os.Hostname()
+  if err != nil {
+    i.Logger.Warnf("failed to determine hostname: %v", err)
+  }
+
   return &Syncer{
     i,
     i.Logger,
     mappings,
-    p,
-    viper.GetBool("provider.useLastUpdate"),
+    providers,
+    providerTypes(providers),
+    anyUseLastUpdate(providers),
     events,
+    hostname,
+    "",
   }, nil
 }
 
+// SetRequestId sets an identifier for the invocation that triggered the sync,
+// such as an AWS Lambda request ID, which is added to all audit events.
+func (s *Syncer) SetRequestId(requestId string) {
+  s.requestId = requestId
+}
+
+// Mappings returns the mapping configurations used by the syncer.
+func (s *Syncer) Mappings() Mappings {
+  return s.mappings
+}
+
+// Sync runs all enabled mappings.
 func (s *Syncer) Sync() error {
-  cycleId, err := uuid.NewV4()
+  indices, err := s.SelectMappings(nil, nil)
   if err != nil {
-    s.syncFailed(uuid.Nil, err)
+    return err
+  }
+
+  _, err = s.Run(indices)
+
+  return err
+}
+
+// Run runs the mappings at the given indices in order and returns the result
+// of the run. The returned error is non-nil if the run failed or if any
+// mapping completed with errors.
+func (s *Syncer) Run(indices []int) (*SyncResult, error) {
+  return s.RunContext(context.Background(), indices)
+}
+
+// RunContext is like Run but uses the given context for the run.
+func (s *Syncer) RunContext(ctx context.Context, indices []int) (*SyncResult, error) {
+  return s.run(ctx, indices, nil, false)
+}
+
+// Plan runs the mappings at the given indices in order without applying any
+// tag changes. The tag changes that would be applied are returned in the
+// result for each mapping. No audit events are produced.
+func (s *Syncer) Plan(indices []int) (*SyncResult, error) {
+  return s.PlanContext(context.Background(), indices)
+}
+
+// PlanContext is like Plan but uses the given context for the run.
+func (s *Syncer) PlanContext(ctx context.Context, indices []int) (*SyncResult, error) {
+  return s.run(ctx, indices, nil, true)
+}
+
+// RunExtEntities runs the mapping at the given index for only the external
+// entities with the given IDs. Each external entity is fetched individually, so
+// the provider must support the EntityLookup capability.
+func (s *Syncer) RunExtEntities(index int, ids []string) (*SyncResult, error) {
+  return s.run(context.Background(), []int{ index }, ids, false)
+}
+
+func (s *Syncer) run(
+  ctx               context.Context,
+  indices           []int,
+  extEntityIds      []string,
+  dryRun            bool,
+) (*SyncResult, error) {
+  txnName := "EntityTagSync/Sync"
+  if dryRun {
+    txnName = "EntityTagSync/Plan"
   }
 
-  s.syncStarted(cycleId)
+  txn := s.i.App.StartTransaction(txnName)
+  defer txn.End()
+
+  txn.AddAttribute("mappingCount", len(indices))
+  txn.AddAttribute("providerType", s.providerType)
 
-  lastUpdateTs, err := s.getLastUpdateTimestamp()
+  ctx = newrelic.NewContext(ctx, txn)
+  run := &syncRun{
+    startTime: time.Now(),
+    extEntityIds: extEntityIds,
+    dryRun: dryRun,
+  }
+  result := &SyncResult{ StartTime: run.startTime }
+
+  cycleId, err := uuid.NewV4()
   if err != nil {
-    return s.syncFailed(cycleId, err)
+    s.syncFailed(run, err)
   }
 
+  run.id = cycleId
+  result.Id = cycleId.String()
+
+  txn.AddAttribute("cycleId", result.Id)
+
+  s.syncStarted(run)
+
   errorCount := 0
 
-  for i, mappingConfig := range s.mappings {
-    s.log.Debugf(
-      "starting mapping %d; reading all external entities from provider",
-      i,
-    )
+  for _, index := range indices {
+    if index < 0 || index >= len(s.mappings) {
+      return result.complete(
+        s.syncFailed(run, fmt.Errorf("invalid mapping index %d", index)),
+      )
+    }
 
-    extEntityTags := []string { mappingConfig.Match.ExtEntityKey }
-    extEntityTags = append(extEntityTags, getKeys(mappingConfig.Mapping)...)
+    mappingConfig := &s.mappings[index]
+    mapping := &mappingRun{
+      index: index,
+      name: mappingConfig.Name,
+      labels: mappingConfig.Labels,
+      profile: mappingConfig.EntityQuery.Profile,
+      startTime: time.Now(),
+    }
 
-    extEntities, err := s.provider.GetEntities(
-      mappingConfig.ExtEntityQuery,
-      extEntityTags,
-      lastUpdateTs,
-    )
+    mapping.provider, err = s.getProvider(mappingConfig)
+    if err != nil {
+      return result.complete(s.syncFailed(run, err))
+    }
+
+    err := s.syncMapping(ctx, run, mapping, mappingConfig)
     if err != nil {
-      s.mappingFailed(cycleId, fmt.Errorf("reading entities from provider failed: %v", err))
       errorCount += 1
-      continue
     }
 
-    extEntityCount := len(extEntities)
+    result.Mappings = append(result.Mappings, newMappingResult(mapping, err))
+  }
 
-    if extEntityCount == 0 {
-      s.mappingSkipped(cycleId)
-      continue
+  if errorCount > 0 {
+    err := fmt.Errorf("sync completed with errors")
+    txn.NoticeError(err)
+    return result.complete(s.syncFailed(run, err))
+  }
+
+  s.syncComplete(run)
+
+  return result.complete(nil)
+}
+
+func (s *Syncer) syncMapping(
+  ctx               context.Context,
+  run               *syncRun,
+  mapping           *mappingRun,
+  mappingConfig     *MappingConfig,
+) error {
+  txn := newrelic.FromContext(ctx)
+  index := mapping.index
+
+  segment := txn.StartSegment(fmt.Sprintf("Mapping/%d", index))
+  defer segment.End()
+
+  segment.AddAttribute("mappingIndex", index)
+  if mappingConfig.Name != "" {
+    segment.AddAttribute("mappingName", mappingConfig.Name)
+  }
+
+  s.log.Debugf(
+    "starting %s; reading all external entities from provider %s",
+    mapping.displayName(),
+    mapping.provider.name,
+  )
+
+  p, err := mapping.provider.get(s.i)
+  if err != nil {
+    txn.NoticeError(err)
+    s.mappingFailed(run, mapping, err)
+    return err
+  }
+
+  if err := p.ValidateQuery(mappingConfig.ExtEntityQuery); err != nil {
+    err = fmt.Errorf("invalid extEntityQuery: %v", err)
+    txn.NoticeError(err)
+    s.mappingFailed(run, mapping, err)
+    return err
+  }
+
+  mapping.relationships = newRelationshipResults(mappingConfig.Relationships)
+  mapping.tagged = map[string]bool{}
+
+  extEntityTags := []string { mappingConfig.Match.ExtEntityKey }
+  extEntityTags = append(extEntityTags, getKeys(mappingConfig.Mapping)...)
+
+  providerSegment := txn.StartSegment("Provider/GetEntities")
+  providerSegment.AddAttribute("providerName", mapping.provider.name)
+  providerSegment.AddAttribute("providerType", mapping.provider.providerType)
+
+  extEntities, extEntityCount, err := s.getExtEntities(
+    ctx,
+    run,
+    mapping,
+    p,
+    mappingConfig,
+    extEntityTags,
+  )
+
+  providerSegment.End()
+
+  if mapping.pageCount > 0 && !run.dryRun {
+    recordProviderPages(mapping.provider.providerType, mapping)
+  }
+
+  if err != nil {
+    err = fmt.Errorf("reading entities from provider failed: %v", err)
+    txn.NoticeError(err)
+    s.mappingFailed(run, mapping, err)
+    return err
+  }
+
+  mapping.extEntityCount = extEntityCount
+
+  if len(extEntities) == 0 {
+    s.mappingSkipped(run, mapping)
+    return nil
+  }
+
+  s.log.Debugf(
+    "read %d entities from provider; %d are retained for matching",
+    extEntityCount,
+    len(extEntities),
+  )
+
+  client, err := s.i.Client(mappingConfig.EntityQuery.Profile)
+  if err != nil {
+    txn.NoticeError(err)
+    s.mappingFailed(run, mapping, err)
+    return err
+  }
+
+  matches := []entityMatch{}
+
+  processingResults, err := processEntities(
+    ctx,
+    s.i,
+    mappingConfig,
+    func (
+      i                 *interop.Interop,
+      config            *MappingConfig,
+      entity            *EntityOutline,
+    ) (entityProcessorResult, []error) {
+      extEntity := getMatchingEntity(
+        s.i,
+        entity,
+        &config.Match,
+        extEntities,
+      )
+      if extEntity == nil {
+        // No entity with a value for extEntityKey that maches an entity with a
+        // value for entityKey
+        return ENTITY_NO_MATCH, nil
+      }
+
+      s.log.Debugf(
+        "external entity %s matches New Relic entity %s (%s)",
+        extEntity.ID,
+        entity.Name,
+        entity.Guid,
+      )
+
+      // Related entities inherit tags once all entities have been matched so
+      // that tags from a direct match always take precedence
+      mapping.tagged[string(entity.Guid)] = true
+      if len(mapping.relationships) > 0 {
+        matches = append(matches, entityMatch{ extEntity, *entity })
+      }
+
+      return s.applyTags(
+        ctx,
+        run,
+        mapping,
+        client,
+        mappingConfig,
+        extEntity,
+        entity,
+        nil,
+      )
+    },
+  )
+
+  if err == nil {
+    for index := range matches {
+      s.inheritTags(
+        ctx,
+        run,
+        mapping,
+        client,
+        mappingConfig,
+        matches[index].extEntity,
+        &matches[index].entity,
+      )
     }
+  }
 
-    s.log.Debugf("read %d entities from provider", extEntityCount)
+  mapping.processingResults = processingResults
 
-    processingResults, err := processEntities(
+  s.mappingComplete(run, mapping, extEntityCount, processingResults, err)
+
+  if err != nil {
+    txn.NoticeError(err)
+    return err
+  }
+
+  if processingResults.totalEntitiesWithErrors > 0 {
+    return fmt.Errorf(
+      "%d entities could not be updated",
+      processingResults.totalEntitiesWithErrors,
+    )
+  }
+
+  if count := mapping.relatedErrorCount(); count > 0 {
+    return fmt.Errorf(
+      "%d errors while updating related entities",
+      count,
+    )
+  }
+
+  return nil
+}
+
+// applyTags applies the mapped values of the external entity to the tags of
+// the New Relic entity. During a dry run, the changes that would be applied
+// are recorded instead. The inheritance is nil unless the entity inherits the
+// tags from a matched entity.
+func (s *Syncer) applyTags(
+  ctx               context.Context,
+  run               *syncRun,
+  mapping           *mappingRun,
+  client            *nrClient.NewRelic,
+  mappingConfig     *MappingConfig,
+  extEntity         *provider.Entity,
+  entity            *EntityOutline,
+  inherited         *inheritance,
+) (entityProcessorResult, []error) {
+  if run.dryRun {
+    diff := diffTags(s.i, mappingConfig.Mapping, extEntity, entity)
+    if diff.empty() {
+      return ENTITY_UPDATE_NONE, nil
+    }
+
+    change := newEntityChange(entity, extEntity, diff)
+    if inherited != nil {
+      change.InheritedFrom = string(inherited.entity.Guid)
+      change.Relationship = inherited.relationshipType
+    }
+
+    mapping.changes = append(mapping.changes, change)
+
+    return ENTITY_UPDATE_OK, nil
+  }
+
+  return updateTags(
+    ctx,
+    s.i,
+    client,
+    mappingConfig.Mapping,
+    extEntity,
+    entity,
+  )
+}
+
+// getExtEntities reads the external entities for the mapping, either by
+// streaming all pages of entities from the provider or by fetching the entities
+// with the IDs of the run individually. Only external entities with a value
+// for the external entity match key are retained since others can never match.
+// With the equal operators, only the first external entity with each value of
+// the match key is retained since it is the only one that can be matched.
+// Retained entities are compacted to the values of the given tags. The number
+// of external entities read is returned along with the retained entities.
+func (s *Syncer) getExtEntities(
+  ctx               context.Context,
+  run               *syncRun,
+  mapping           *mappingRun,
+  p                 provider.ProviderV2,
+  mappingConfig     *MappingConfig,
+  extEntityTags     []string,
+) ([]provider.Entity, int, error) {
+  extEntities := []provider.Entity{}
+  count := 0
+
+  operator := mappingConfig.Match.Operator
+  unique := operator == "equal" || operator == "equal-ignore-case"
+  seen := map[string]bool{}
+
+  retain := func(extEntity *provider.Entity) {
+    count += 1
+
+    value, ok := getExtEntityKeyValue(
       s.i,
-      &mappingConfig,
-      func (
-        i                 *interop.Interop,
-        mapping           *MappingConfig,
-        entity            *EntityOutline,
-      ) (entityProcessorResult, []error) {
-        extEntity := getMatchingEntity(
-          s.i,
-          entity,
-          &mapping.Match,
-          extEntities,
-        )
-        if extEntity == nil {
-          // No entity with a value for extEntityKey that maches an entity with a
-          // value for entityKey
-          return ENTITY_NO_MATCH, nil
-        }
-
-        s.log.Debugf(
-          "external entity %s matches New Relic entity %s (%s)",
+      extEntity,
+      mappingConfig.Match.ExtEntityKey,
+    )
+    if !ok || value == "" {
+      s.log.Tracef(
+        "skipping external entity %s because it does not have the match key %s or the match key is not a string value",
+        extEntity.ID,
+        mappingConfig.Match.ExtEntityKey,
+      )
+      return
+    }
+
+    if unique {
+      if operator == "equal-ignore-case" {
+        value = strings.ToLower(value)
+      }
+
+      if seen[value] {
+        s.log.Tracef(
+          "skipping external entity %s because an earlier external entity has the same value for the match key %s",
           extEntity.ID,
-          entity.Name,
-          entity.Guid,
+          mappingConfig.Match.ExtEntityKey,
         )
+        return
+      }
 
-        return updateTags(
-          s.i,
-          mappingConfig.Mapping,
-          extEntity,
-          entity,
-        )
-      },
-    )
+      seen[value] = true
+    }
 
-    s.mappingComplete(cycleId, extEntityCount, processingResults, err)
+    extEntities = append(extEntities, compactExtEntity(extEntity, extEntityTags))
+  }
 
-    if err != nil || processingResults.totalEntitiesWithErrors > 0 {
-      errorCount += 1
+  if run.extEntityIds == nil {
+    query := &provider.Query{
+      Config: mappingConfig.ExtEntityQuery,
+      Tags: extEntityTags,
+    }
+
+    if mapping.provider.delta(p) {
+      lastUpdate, err := s.getLastUpdateTimestamp(mapping)
+      if err != nil {
+        return nil, 0, err
+      }
+
+      mapping.lastUpdate = lastUpdate
+      query.LastUpdate = lastUpdate
+    }
+
+    it, err := p.Entities(ctx, query)
+    if err != nil {
+      return nil, 0, err
     }
+
+    defer it.Close()
+
+    mapping.pageCount = 0
+
+    for {
+      page, err := it.Next(ctx)
+      if err == io.EOF {
+        break
+      }
+
+      if err != nil {
+        return nil, 0, err
+      }
+
+      mapping.pageCount += 1
+
+      for index := range page {
+        retain(&page[index])
+      }
+    }
+
+    if pageCounter, ok := it.(provider.PageCounter); ok {
+      mapping.pageCount = pageCounter.PageCount()
+    }
+
+    return extEntities, count, nil
   }
 
-  if errorCount > 0 {
-    return s.syncFailed(cycleId, fmt.Errorf("sync completed with errors"))
+  getter, ok := p.(provider.EntityGetter)
+  if !ok || !p.Capabilities().EntityLookup {
+    return nil, 0, fmt.Errorf(
+      "provider %s of type %s does not support fetching single entities",
+      mapping.provider.name,
+      mapping.provider.providerType,
+    )
   }
 
-  s.syncComplete(cycleId)
+  for _, id := range run.extEntityIds {
+    extEntity, err := getter.GetEntity(
+      ctx,
+      mappingConfig.ExtEntityQuery,
+      extEntityTags,
+      id,
+    )
+    if err != nil {
+      return nil, 0, err
+    }
 
-  return nil
+    if extEntity == nil {
+      s.log.Debugf("external entity %s not found; skipping", id)
+      continue
+    }
+
+    retain(extEntity)
+  }
+
+  return extEntities, count, nil
 }
 
-func (s *Syncer) syncStarted(uuid uuid.UUID) {
-  if s.eventsConfig.Enabled {
-    startEvent := s.newAuditEvent(uuid, "sync_start", nil)
+// compactExtEntity returns a copy of the external entity holding only the
+// values of the given keys, which are the only values used for matching and
+// tagging, so that the external entities retained for a mapping use as little
+// memory as possible. Values are stored under the same nested keys so that
+// they are looked up in the same way. The external entity is returned
+// unchanged if one of the keys is nested under another.
+func compactExtEntity(extEntity *provider.Entity, keys []string) provider.Entity {
+  tags := map[string]interface{}{}
+
+  for _, key := range keys {
+    value, ok := getNestedKeyValue(key, extEntity.Tags)
+    if !ok {
+      continue
+    }
+
+    path := strings.Split(key, ".")
+    m := tags
+
+    for _, name := range path[:len(path) - 1] {
+      if _, ok := m[name]; !ok {
+        m[name] = map[string]interface{}{}
+      }
+
+      next, ok := m[name].(map[string]interface{})
+      if !ok {
+        return *extEntity
+      }
+
+      m = next
+    }
+
+    name := path[len(path) - 1]
+    if _, ok := m[name].(map[string]interface{}); ok {
+      return *extEntity
+    }
+
+    m[name] = value
+  }
+
+  return provider.Entity{ ID: extEntity.ID, Tags: tags }
+}
+
+func (s *Syncer) syncStarted(run *syncRun) {
+  if s.eventsEnabled(run) {
+    startEvent := s.newAuditEvent(run, "sync_start", nil)
     s.pushEvent(startEvent)
   }
 
   s.log.Debugf("sync started")
 }
 
-func (s *Syncer) syncFailed(uuid uuid.UUID, err error) error {
-  if s.eventsConfig.Enabled {
-    endEvent := s.newAuditEvent(uuid, "sync_end", err)
+func (s *Syncer) syncFailed(run *syncRun, err error) error {
+  if s.eventsEnabled(run) {
+    endEvent := s.newAuditEvent(run, "sync_end", err)
+    endEvent["runDurationMs"] = time.Since(run.startTime).Milliseconds()
     s.pushEvent(endEvent)
   }
 
+  if !run.dryRun {
+    recordRunMetrics(run, err)
+  }
+
   s.log.Debugf("sync failed")
 
   return err
 }
 
-func (s *Syncer) syncComplete(uuid uuid.UUID) {
-  if s.eventsConfig.Enabled {
-    endEvent := s.newAuditEvent(uuid, "sync_end", nil)
+func (s *Syncer) syncComplete(run *syncRun) {
+  if s.eventsEnabled(run) {
+    endEvent := s.newAuditEvent(run, "sync_end", nil)
+    endEvent["runDurationMs"] = time.Since(run.startTime).Milliseconds()
     s.pushEvent(endEvent)
   }
 
+  if !run.dryRun {
+    recordRunMetrics(run, nil)
+  }
+
   s.log.Debugf("sync complete")
 }
 
-func (s *Syncer) mappingFailed(uuid uuid.UUID, err error) {
-  if s.eventsConfig.Enabled {
-    mappingEvent := s.newAuditEvent(uuid, "mapping_complete", err)
-    s.pushEvent(mappingEvent)
+func (s *Syncer) mappingFailed(
+  run               *syncRun,
+  mapping           *mappingRun,
+  err               error,
+) {
+  if s.eventsEnabled(run) {
+    mappingEvent := s.newMappingAuditEvent(run, mapping, err)
+    s.pushMappingEvent(mapping, mappingEvent)
   }
-  s.log.Error(fmt.Sprintf("mapping failed: %v", err))
+
+  if !run.dryRun {
+    recordMappingDuration(mapping)
+  }
+
+  s.log.Error(fmt.Sprintf("%s failed: %v", mapping.displayName(), err))
 }
 
-func (s *Syncer) mappingSkipped(uuid uuid.UUID) {
-  if s.eventsConfig.Enabled {
-    mappingEvent := s.newAuditEvent(uuid, "mapping_complete", nil)
+func (s *Syncer) mappingSkipped(run *syncRun, mapping *mappingRun) {
+  if s.eventsEnabled(run) {
+    mappingEvent := s.newMappingAuditEvent(run, mapping, nil)
 
     mappingEvent["extEntityCount"] = 0
 
-    s.pushEvent(mappingEvent)
+    s.pushMappingEvent(mapping, mappingEvent)
   }
 
-  s.log.Debug("no entities returned from provider; continuing to next mapping")
+  if !run.dryRun {
+    recordMappingDuration(mapping)
+    recordMappingSuccess(mapping)
+  }
+
+  s.log.Debugf(
+    "no entities returned from provider for %s; continuing to next mapping",
+    mapping.displayName(),
+  )
 }
 
 func (s *Syncer) mappingComplete(
-  uuid                uuid.UUID,
+  run                 *syncRun,
+  mapping             *mappingRun,
   extEntityCount      int,
   processingResults   *entityProcessingResult,
   err                 error,
 ) {
-  if s.eventsConfig.Enabled {
-    mappingEvent := s.newAuditEvent(uuid, "mapping_complete", err)
+  if s.eventsEnabled(run) {
+    mappingEvent := s.newMappingAuditEvent(run, mapping, err)
 
     mappingEvent["extEntityCount"] = extEntityCount
     mappingEvent["totalEntityCount"] = processingResults.totalEntities
@@ -229,18 +746,26 @@ func (s *Syncer) mappingComplete(
     mappingEvent["totalEntitiesUpdated"] = processingResults.totalEntitiesUpdated
     mappingEvent["totalEntitiesWithErrors"] = processingResults.totalEntitiesWithErrors
 
-    s.pushEvent(mappingEvent)
+    addRelationshipAttributes(mappingEvent, mapping.relationships)
+
+    s.pushMappingEvent(mapping, mappingEvent)
+  }
+
+  if !run.dryRun {
+    s.recordMappingMetrics(mapping, processingResults, err)
   }
 
   if err != nil {
     s.log.Warnf(
-      "mapping completed with an error; results may be incomplete; see output for details: %v",
+      "%s completed with an error; results may be incomplete; see output for details: %v",
+      mapping.displayName(),
       err,
     )
   }
 
   s.log.Debugf(
-    "read %d external entities, %d total New Relic entities, %d scanned, %d matched, %d skipped, %d updated, %d updates with errors",
+    "%s: read %d external entities, %d total New Relic entities, %d scanned, %d matched, %d skipped, %d updated, %d updates with errors",
+    mapping.displayName(),
     extEntityCount,
     processingResults.totalEntities,
     processingResults.totalEntitiesScanned,
@@ -297,41 +822,43 @@ func getMatchingEntity(
       match.Operator,
     )
 
-    switch match.Operator {
-    case "equal":
-      if extEntityKeyValue == entityKeyValue {
-        return &extEntity
-      }
-
-    case "equal-ignore-case":
-      if strings.EqualFold(extEntityKeyValue, entityKeyValue) {
-        return &extEntity
-      }
+    if matchKeyValues(match.Operator, extEntityKeyValue, entityKeyValue) {
+      return &extEntity
+    }
+  }
 
-    case "contains":
-      if strings.Contains(extEntityKeyValue, entityKeyValue) {
-        return &extEntity
-      }
+  return nil
+}
 
-    case "contains-ignore-case":
-      if strings.Contains(
-        strings.ToLower(extEntityKeyValue),
-        strings.ToLower(entityKeyValue),
-      ) {
-        return &extEntity
-      }
+func matchKeyValues(
+  operator          string,
+  extEntityKeyValue string,
+  entityKeyValue    string,
+) bool {
+  switch operator {
+  case "equal":
+    return extEntityKeyValue == entityKeyValue
+
+  case "equal-ignore-case":
+    return strings.EqualFold(extEntityKeyValue, entityKeyValue)
+
+  case "contains":
+    return strings.Contains(extEntityKeyValue, entityKeyValue)
+
+  case "contains-ignore-case":
+    return strings.Contains(
+      strings.ToLower(extEntityKeyValue),
+      strings.ToLower(entityKeyValue),
+    )
 
-    case "inverse-contains-ignore-case":
-      if strings.Contains(
-        strings.ToLower(entityKeyValue),
-        strings.ToLower(extEntityKeyValue),
-      ) {
-        return &extEntity
-      }
-    }
+  case "inverse-contains-ignore-case":
+    return strings.Contains(
+      strings.ToLower(entityKeyValue),
+      strings.ToLower(extEntityKeyValue),
+    )
   }
 
-  return nil
+  return false
 }
 
 func requireAccountID(events *eventsConfig) error {
@@ -1,22 +1,64 @@
 package sync
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/newrelic/go-agent/v3/newrelic"
+	nrClient "github.com/newrelic/newrelic-client-go/newrelic"
 	"github.com/newrelic/newrelic-client-go/pkg/entities"
 	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
 	"github.com/newrelic/nr-entity-tag-sync/pkg/interop"
 )
 
+// tagDiff holds the tag changes required to bring the tags of a New Relic
+// entity in line with an external entity.
+type tagDiff struct {
+  tagsToDelete      []string
+  tagsToAdd         []entities.TaggingTagInput
+}
+
+func (d *tagDiff) empty() bool {
+  return len(d.tagsToDelete) == 0 && len(d.tagsToAdd) == 0
+}
+
 func updateTags(
+  ctx               context.Context,
   i                 *interop.Interop,
+  client            *nrClient.NewRelic,
   mapping           Mapping,
   extEntity         *provider.Entity,
   entity            *EntityOutline,
 ) (entityProcessorResult, []error) {
-  tagChanges := 0
+  diff := diffTags(i, mapping, extEntity, entity)
+
+  if diff.empty() {
+    return ENTITY_UPDATE_NONE, nil
+  }
+
+  errors := applyUpdates(
+    ctx,
+    client,
+    entity,
+    diff.tagsToDelete,
+    diff.tagsToAdd,
+  )
+
+  if len(errors) > 0 {
+    return ENTITY_UPDATE_ERR, errors
+  }
+
+  return ENTITY_UPDATE_OK, errors
+}
+
+func diffTags(
+  i                 *interop.Interop,
+  mapping           Mapping,
+  extEntity         *provider.Entity,
+  entity            *EntityOutline,
+) *tagDiff {
   tagsToDelete := []string{}
   tagsToAdd := []entities.TaggingTagInput{}
 
@@ -43,7 +85,6 @@ func updateTags(
         // probably be assumed that the tags being synchronized are managed by
         // the entity tag sync application.
         tagsToDelete = append(tagsToDelete, entityTagName)
-        tagChanges += 1
       }
     } else if extEntityKeyExists {
       // ext entity key - yes
@@ -56,7 +97,6 @@ func updateTags(
             Values: []string {extEntityKeyValue},
           },
         )
-        tagChanges += 1
       } else if !stringSliceContains(entityTagValues, extEntityKeyValue){
         // entity key - yes, tag values contain ext entity value - no, update
         for _, tag := range entity.Tags {
@@ -84,44 +124,33 @@ func updateTags(
             continue
           }
         }
-        tagChanges += 1
       }
     }
   }
 
-  if tagChanges == 0 {
-    return ENTITY_UPDATE_NONE, nil
-  }
-
-  errors := applyUpdates(
-    i,
-    entity,
-    tagsToDelete,
-    tagsToAdd,
-  )
-
-  if len(errors) > 0 {
-    return ENTITY_UPDATE_ERR, errors
-  }
-
-  return ENTITY_UPDATE_OK, errors
+  return &tagDiff{ tagsToDelete, tagsToAdd }
 }
 
 func applyUpdates(
-  i                 *interop.Interop,
+  ctx               context.Context,
+  client            *nrClient.NewRelic,
   entity            *EntityOutline,
   tagsToDelete      []string,
   tagsToAdd         []entities.TaggingTagInput,
 ) []error {
-  nrClient := i.NrClient
+  txn := newrelic.FromContext(ctx)
   errors := []error{}
 
   if len(tagsToDelete) > 0 {
-    taggingMutationResult, err := nrClient.Entities.TaggingDeleteTagFromEntity(
+    segment := txn.StartSegment("Tagging/DeleteTagFromEntity")
+    taggingMutationResult, err := client.Entities.TaggingDeleteTagFromEntity(
       entity.Guid,
       tagsToDelete,
     )
+    segment.End()
+
     if err != nil {
+      recordNerdGraphError("tagging_delete")
       errors = append(
         errors,
         fmt.Errorf(
@@ -132,6 +161,7 @@ func applyUpdates(
         ),
       )
     } else if len(taggingMutationResult.Errors) > 0 {
+      recordNerdGraphError("tagging_delete")
       errors = append(
         errors,
         fmt.Errorf(
@@ -145,11 +175,15 @@ func applyUpdates(
   }
 
   if len(tagsToAdd) > 0 {
-    taggingMutationResult, err := nrClient.Entities.TaggingAddTagsToEntity(
+    segment := txn.StartSegment("Tagging/AddTagsToEntity")
+    taggingMutationResult, err := client.Entities.TaggingAddTagsToEntity(
       entity.Guid,
       tagsToAdd,
     )
+    segment.End()
+
     if err != nil {
+      recordNerdGraphError("tagging_add")
       errors = append(
         errors,
         fmt.Errorf(
@@ -160,6 +194,7 @@ func applyUpdates(
         ),
       )
     } else if len(taggingMutationResult.Errors) > 0 {
+      recordNerdGraphError("tagging_add")
       errors = append(
         errors,
         fmt.Errorf(
@@ -1,7 +1,14 @@
 package sync
 
-import "strings"
+import (
+	"strings"
 
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+)
+
+// getNestedHelper looks up each key of the path ignoring case if there is no
+// exact match since the keys of a mapping are lowercased when the
+// configuration is read.
 func getNestedHelper(
   path []string,
   m map[string]interface{},
@@ -11,7 +18,7 @@ func getNestedHelper(
     return "", false
   }
 
-  v, ok := m[path[index]]
+  _, v, ok := provider.FindField(m, path[index])
   if !ok {
     return "", false
   }
@@ -0,0 +1,75 @@
+package sync
+
+import (
+  "testing"
+
+  "github.com/newrelic/nr-entity-tag-sync/internal/provider"
+)
+
+// Mapping keys are lowercased when the configuration is read, so keys such as
+// the Owner tag of a Terraform resource must be found ignoring case.
+func TestGetNestedKeyValueMixedCase(t *testing.T) {
+  values := map[string]interface{}{
+    "id": "i-0123456789",
+    "tags": map[string]interface{}{
+      "Owner": "payments",
+      "CostCenter": "1234",
+    },
+    "terraform": map[string]interface{}{
+      "address": "aws_instance.web[0]",
+      "indexKey": "0",
+    },
+  }
+
+  keys := []string{ "id", "tags.owner", "terraform.indexkey" }
+
+  extEntity := &provider.Entity{
+    ID: "aws_instance.web[0]",
+    Tags: provider.SelectFields(values, keys),
+  }
+  compacted := compactExtEntity(extEntity, keys)
+
+  tests := []struct {
+    key             string
+    value           string
+    ok              bool
+  }{
+    { "id", "i-0123456789", true },
+    { "tags.owner", "payments", true },
+    { "tags.Owner", "payments", true },
+    { "TAGS.OWNER", "payments", true },
+    { "terraform.indexkey", "0", true },
+    { "tags.team", "", false },
+  }
+
+  for _, test := range tests {
+    for _, entity := range []*provider.Entity{ extEntity, &compacted } {
+      value, ok := getNestedKeyValue(test.key, entity.Tags)
+      if value != test.value || ok != test.ok {
+        t.Errorf(
+          "getNestedKeyValue(%q) = %q, %v; want %q, %v",
+          test.key,
+          value,
+          ok,
+          test.value,
+          test.ok,
+        )
+      }
+    }
+  }
+}
+
+func TestGetNestedKeyValuePrefersExactCase(t *testing.T) {
+  values := map[string]interface{}{
+    "Owner": "upper",
+    "owner": "lower",
+  }
+
+  if value, _ := getNestedKeyValue("owner", values); value != "lower" {
+    t.Errorf("getNestedKeyValue(\"owner\") = %q; want \"lower\"", value)
+  }
+
+  if value, _ := getNestedKeyValue("OWNER", values); value != "upper" {
+    t.Errorf("getNestedKeyValue(\"OWNER\") = %q; want \"upper\"", value)
+  }
+}
@@ -0,0 +1,381 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/nr-entity-tag-sync/internal/config"
+	"github.com/newrelic/nr-entity-tag-sync/internal/provider"
+	"github.com/spf13/cast"
+)
+
+var (
+  matchOperators = []string{
+    "equal",
+    "equal-ignore-case",
+    "contains",
+    "contains-ignore-case",
+    "inverse-contains-ignore-case",
+  }
+  relationshipDirections = []string{
+    RELATIONSHIP_DIRECTION_INBOUND,
+    RELATIONSHIP_DIRECTION_OUTBOUND,
+    RELATIONSHIP_DIRECTION_BOTH,
+  }
+  logLevels = []string{
+    "panic", "fatal", "error", "warn", "warning", "info", "debug", "trace",
+  }
+)
+
+// ValidateConfig strictly validates the given configuration file, reporting
+// unknown keys, keys with the wrong case, missing required keys and values of
+// the wrong type. All problems are reported together in a
+// config.ValidationError. Only YAML and JSON files are validated since other
+// formats do not preserve the case of keys.
+func ValidateConfig(configFile string) error {
+  if configFile == "" || !config.IsSupportedFile(configFile) {
+    return nil
+  }
+
+  tree, err := config.Load(configFile)
+  if err != nil {
+    return err
+  }
+
+  problems := validateValues(tree.Values)
+  if len(problems) > 0 {
+    addMappingSources(tree, problems)
+
+    return &config.ValidationError{ Problems: problems }
+  }
+
+  return nil
+}
+
+// ValidateSettings strictly validates configuration settings that were not
+// read from a file in the same way as ValidateConfig.
+func ValidateSettings(values map[string]interface{}) error {
+  problems := validateValues(values)
+  if len(problems) > 0 {
+    return &config.ValidationError{ Problems: problems }
+  }
+
+  return nil
+}
+
+func validateValues(values map[string]interface{}) []config.Problem {
+  problems := config.Validate(configSchema(values), values, "")
+  problems = append(problems, validateMappings(values)...)
+  problems = append(problems, validateMappingNames(values["mappings"])...)
+
+  return problems
+}
+
+// configSchema returns the schema for the given configuration tree. Each
+// provider is validated with the schema of its type. Mappings are validated
+// separately by validateMappings since each mapping may use a different
+// provider.
+func configSchema(tree map[string]interface{}) *config.Node {
+  providers := map[string]*config.Node{}
+
+  if m, ok := tree["providers"].(map[string]interface{}); ok {
+    for name, v := range m {
+      providers[name] = providerConfigSchema(getProviderType(v)).Require()
+    }
+  }
+
+  return config.Object(map[string]*config.Node{
+    "apiKey": config.String(),
+    "licenseKey": config.String(),
+    "region": config.String(),
+    "profiles": config.MapOf(config.Object(map[string]*config.Node{
+      "apiKey": config.String().Require(),
+      "region": config.String(),
+      "licenseKey": config.String(),
+      "eventsAccountId": config.Int(),
+    })),
+    "log": config.Object(map[string]*config.Node{
+      "level": config.String().OneOf(logLevels...),
+      "fileName": config.String(),
+    }),
+    "include": config.ListOf(config.String()),
+    "mappingsDir": config.String(),
+    "defaults": config.Object(nil).Open(),
+    "secrets": config.Object(map[string]*config.Node{
+      "execCommand": config.ListOf(config.String()),
+      "execTimeout": config.Duration(),
+    }),
+    "events": config.Object(map[string]*config.Node{
+      "enabled": config.Bool(),
+      "accountId": config.Int(),
+      "eventType": config.String(),
+    }),
+    "provider": providerConfigSchema(getProviderType(tree["provider"])),
+    "providers": config.Object(providers),
+    "mappings": config.ListOf(config.Any()).Require(),
+    "server": config.Object(map[string]*config.Node{
+      "listenAddress": config.String(),
+      "schedule": config.String(),
+      "interval": config.Duration(),
+      "runOnStart": config.Bool(),
+      "watchConfig": config.Bool(),
+      "webhook": config.Object(map[string]*config.Node{
+        "enabled": config.Bool(),
+        "path": config.String(),
+        "authType": config.String().OneOf("hmac", "bearer"),
+        "secret": config.String(),
+        "signatureHeader": config.String(),
+        "debounce": config.Duration(),
+      }),
+    }),
+  })
+}
+
+func providerConfigSchema(providerType string) *config.Node {
+  providerSchema, hasSchema := provider.GetSchema(providerType)
+
+  fields := map[string]*config.Node{
+    "type": config.String().OneOf(provider.RegisteredProviders()...).Require(),
+    "useLastUpdate": config.Bool(),
+  }
+
+  if !hasSchema {
+    return config.Object(fields).Open()
+  }
+
+  for k, v := range providerSchema.Provider {
+    fields[k] = v
+  }
+
+  return config.Object(fields)
+}
+
+func mappingSchema(providerType string) *config.Node {
+  providerSchema, hasSchema := provider.GetSchema(providerType)
+
+  extEntityQuery := config.Object(nil).Open()
+  if hasSchema && providerSchema.Query != nil {
+    extEntityQuery = config.Object(providerSchema.Query)
+  }
+
+  return config.Object(map[string]*config.Node{
+    "name": config.String(),
+    "enabled": config.Bool(),
+    "labels": config.ListOf(config.String()),
+    "provider": config.String(),
+    "schedule": config.String(),
+    "extEntityQuery": extEntityQuery.Require(),
+    "entityQuery": config.Object(map[string]*config.Node{
+      "type": config.ListOf(config.String()),
+      "domain": config.ListOf(config.String()),
+      "name": config.String(),
+      "accountId": config.Int(),
+      "tags": config.ListOf(config.Object(map[string]*config.Node{
+        "key": config.String().Require(),
+        "values": config.ListOf(config.String()).Require(),
+      })),
+      "query": config.String(),
+      "profile": config.String(),
+    }).Require().WithCheck(requireNonEmpty),
+    "match": config.Object(map[string]*config.Node{
+      "extEntityKey": config.String().Require(),
+      "operator": config.String().OneOf(matchOperators...).Require(),
+      "entityKey": config.String().Require(),
+    }).Require(),
+    "mapping": config.MapOf(config.String()).Require().WithCheck(requireNonEmpty),
+    "relationships": config.ListOf(config.Object(map[string]*config.Node{
+      "type": config.String().Require(),
+      "direction": config.String().OneOf(relationshipDirections...),
+      "depth": config.Int().WithCheck(checkRelationshipDepth),
+    })),
+  })
+}
+
+// validateMappings validates each mapping with the query schema of the
+// provider it uses and reports mappings that refer to unknown providers or
+// New Relic profiles.
+func validateMappings(tree map[string]interface{}) []config.Problem {
+  problems := []config.Problem{}
+  mappings, ok := tree["mappings"].([]interface{})
+  if !ok {
+    return problems
+  }
+
+  providerTypes := map[string]string{}
+  profiles := map[string]bool{}
+
+  if m, ok := tree["profiles"].(map[string]interface{}); ok {
+    for name := range m {
+      profiles[strings.ToLower(name)] = true
+    }
+  }
+
+  providers, _ := tree["providers"].(map[string]interface{})
+
+  if v, ok := tree["provider"]; ok {
+    providerTypes[DEFAULT_PROVIDER] = getProviderType(v)
+  }
+
+  for key, v := range providers {
+    name := strings.ToLower(key)
+
+    if _, ok := providerTypes[name]; ok {
+      problems = append(problems, config.Problem{
+        Path: "providers." + key,
+        Message: fmt.Sprintf(
+          "provider %q is already configured in the provider section",
+          name,
+        ),
+      })
+      continue
+    }
+
+    providerTypes[name] = getProviderType(v)
+  }
+
+  if len(providerTypes) == 0 {
+    problems = append(problems, config.Problem{
+      Path: "provider",
+      Message: "missing required key \"provider\" or \"providers\"",
+    })
+  }
+
+  for index, m := range mappings {
+    path := fmt.Sprintf("mappings[%d]", index)
+    name := DEFAULT_PROVIDER
+
+    if mapping, ok := m.(map[string]interface{}); ok {
+      if p, ok := mapping["provider"].(string); ok && p != "" {
+        name = strings.ToLower(p)
+      }
+    }
+
+    providerType, ok := providerTypes[name]
+    if !ok && len(providerTypes) > 0 {
+      problems = append(problems, config.Problem{
+        Path: path + ".provider",
+        Message: fmt.Sprintf("unknown provider %q", name),
+      })
+    }
+
+    problems = append(
+      problems,
+      config.Validate(mappingSchema(providerType), m, path)...,
+    )
+
+    if profile := getProfile(m); profile != "" && !profiles[strings.ToLower(profile)] {
+      problems = append(problems, config.Problem{
+        Path: path + ".entityQuery.profile",
+        Message: fmt.Sprintf("unknown New Relic profile %q", profile),
+      })
+    }
+  }
+
+  return problems
+}
+
+// addMappingSources adds the file that a mapping was loaded from to problems
+// with mappings that were not loaded from the main configuration file.
+func addMappingSources(tree *config.Tree, problems []config.Problem) {
+  mainFile := tree.Files[0]
+
+  for i := range problems {
+    var index int
+
+    if _, err := fmt.Sscanf(problems[i].Path, "mappings[%d]", &index); err != nil {
+      continue
+    }
+
+    if source := tree.MappingSource(index); source != "" && source != mainFile {
+      problems[i].Message = fmt.Sprintf("%s (in %s)", problems[i].Message, source)
+    }
+  }
+}
+
+// validateMappingNames reports mapping names that are used by more than one
+// mapping.
+func validateMappingNames(v interface{}) []config.Problem {
+  problems := []config.Problem{}
+  mappings, ok := v.([]interface{})
+  if !ok {
+    return problems
+  }
+
+  names := map[string]int{}
+
+  for index, m := range mappings {
+    mapping, ok := m.(map[string]interface{})
+    if !ok {
+      continue
+    }
+
+    name, ok := mapping["name"].(string)
+    if !ok || name == "" {
+      continue
+    }
+
+    if other, ok := names[name]; ok {
+      problems = append(problems, config.Problem{
+        Path: fmt.Sprintf("mappings[%d].name", index),
+        Message: fmt.Sprintf(
+          "duplicate mapping name %q; already used by mappings[%d]",
+          name,
+          other,
+        ),
+      })
+      continue
+    }
+
+    names[name] = index
+  }
+
+  return problems
+}
+
+func getProviderType(v interface{}) string {
+  m, ok := v.(map[string]interface{})
+  if !ok {
+    return ""
+  }
+
+  providerType, _ := m["type"].(string)
+
+  return providerType
+}
+
+func getProfile(mapping interface{}) string {
+  m, ok := mapping.(map[string]interface{})
+  if !ok {
+    return ""
+  }
+
+  entityQuery, ok := m["entityQuery"].(map[string]interface{})
+  if !ok {
+    return ""
+  }
+
+  profile, _ := entityQuery["profile"].(string)
+
+  return profile
+}
+
+func checkRelationshipDepth(value interface{}) error {
+  depth, err := cast.ToIntE(value)
+  if err != nil {
+    return nil
+  }
+
+  if depth < 1 || depth > MAX_RELATIONSHIP_DEPTH {
+    return fmt.Errorf("must be between 1 and %d", MAX_RELATIONSHIP_DEPTH)
+  }
+
+  return nil
+}
+
+func requireNonEmpty(value interface{}) error {
+  if m, ok := value.(map[string]interface{}); ok && len(m) == 0 {
+    return fmt.Errorf("must not be empty")
+  }
+
+  return nil
+}
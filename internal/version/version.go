@@ -0,0 +1,9 @@
+package version
+
+// These values are set at build time using -ldflags, e.g.
+// -X github.com/newrelic/nr-entity-tag-sync/internal/version.Version=1.2.3
+var (
+  Version           = "dev"
+  Commit            = "none"
+  Date              = "unknown"
+)
@@ -1,6 +1,7 @@
 package interop
 
 import (
+  "bytes"
   "context"
   "fmt"
   "os"
@@ -12,15 +13,26 @@ import (
   "github.com/newrelic/newrelic-client-go/pkg/config"
   "github.com/newrelic/newrelic-client-go/pkg/logging"
   "github.com/newrelic/newrelic-client-go/pkg/region"
+  nrConfig "github.com/newrelic/nr-entity-tag-sync/internal/config"
   log "github.com/sirupsen/logrus"
   "github.com/spf13/viper"
+  "gopkg.in/yaml.v3"
 )
 
 type Interop struct {
   App           *newrelic.Application
   Logger        *log.Logger
   NrClient      *nrClient.NewRelic
+  // Config holds the configuration. It is the global viper instance when the
+  // configuration is read from a file by NewInteroperability.
+  Config        *viper.Viper
+  events        EventSink
   eventsEnabled bool
+  eventsAccount int
+  licenseKey    string
+  profiles      map[string]*profile
+  secrets       secretResolvers
+  options       *interopOptions
 }
 
 func ConfigLicenseKey(licenseKey string) nrClient.ConfigOption {
@@ -30,40 +42,180 @@ func ConfigLicenseKey(licenseKey string) nrClient.ConfigOption {
   }
 }
 
-func NewInteroperability() (*Interop, error) {
+type InteropOption func(*interopOptions)
+
+type interopOptions struct {
+  configFile      string
+  secretResolvers map[string]SecretResolver
+  logger          *log.Logger
+  client          *nrClient.NewRelic
+  eventSink       EventSink
+  app             *newrelic.Application
+}
+
+// EventSink receives audit events. Each event is a map[string]interface{}
+// with the event type in the eventType key. The Events service of a New Relic
+// client in batch mode is the default event sink.
+type EventSink interface {
+  EnqueueEvent(ctx context.Context, event interface{}) error
+  Flush() error
+}
+
+// ConfigFile sets the path of the configuration file to load instead of
+// searching for a file named config in the configs directory and the current
+// directory.
+func ConfigFile(path string) InteropOption {
+  return func(o *interopOptions) {
+    o.configFile = path
+  }
+}
+
+// WithLogger sets the logger to use instead of creating one from the log
+// section of the configuration.
+func WithLogger(logger *log.Logger) InteropOption {
+  return func(o *interopOptions) {
+    o.logger = logger
+  }
+}
+
+// WithClient sets the New Relic client to use instead of creating one from the
+// apiKey, licenseKey and region settings. Connection profiles still use their
+// own clients.
+func WithClient(client *nrClient.NewRelic) InteropOption {
+  return func(o *interopOptions) {
+    o.client = client
+  }
+}
+
+// WithEventSink sets the sink that receives all audit events, including the
+// events of mappings that use a connection profile with an events account.
+func WithEventSink(sink EventSink) InteropOption {
+  return func(o *interopOptions) {
+    o.eventSink = sink
+  }
+}
+
+// WithApplication sets the New Relic APM application used to instrument
+// synchronization cycles. NewInteroperability creates an application by
+// default while New does not.
+func WithApplication(app *newrelic.Application) InteropOption {
+  return func(o *interopOptions) {
+    o.app = app
+  }
+}
+
+func newOptions(opts []InteropOption) *interopOptions {
+  options := &interopOptions{}
+  for _, opt := range opts {
+    opt(options)
+  }
+
+  return options
+}
+
+// NewInteroperability reads the configuration file into the global viper
+// instance and creates an Interop from it.
+func NewInteroperability(opts ...InteropOption) (*Interop, error) {
+  options := newOptions(opts)
+  v := viper.GetViper()
+
   // Load configuration with viper
-  viper.SetConfigName("config")
-  viper.AddConfigPath("configs")
-  viper.AddConfigPath(".")
-  err := viper.ReadInConfig()
+  if options.configFile != "" {
+    v.SetConfigFile(options.configFile)
+  } else {
+    v.SetConfigName("config")
+    v.AddConfigPath("configs")
+    v.AddConfigPath(".")
+  }
+
+  err := v.ReadInConfig()
   if err != nil {
     return nil, err
   }
 
-  // Look for our license key
-  licenseKey := viper.GetString("licenseKey")
-  if licenseKey == "" {
-    licenseKey = os.Getenv("NEW_RELIC_LICENSE_KEY")
+  secrets, err := loadMergedConfig(v, options)
+  if err != nil {
+    return nil, err
   }
 
-  // We don't care if this fails, the Agent is nil safe
-  app, _ := newrelic.NewApplication(
-    newrelic.ConfigAppName("New Relic Entity Tag Sync"),
-    newrelic.ConfigLicense(licenseKey),
-  )
+  if options.app == nil {
+    // Look for our license key
+    licenseKey := v.GetString("licenseKey")
+    if licenseKey == "" {
+      licenseKey, err = secrets.resolve(
+        context.Background(),
+        os.Getenv("NEW_RELIC_LICENSE_KEY"),
+      )
+      if err != nil {
+        return nil, err
+      }
+    }
 
-  logger := log.New()
-  logger.SetLevel(log.WarnLevel)
-  if app != nil {
-    logger.SetFormatter(nrlogrus.NewFormatter(app, &log.TextFormatter{}))
+    // We don't care if this fails, the Agent is nil safe
+    options.app, _ = newrelic.NewApplication(
+      newrelic.ConfigAppName("New Relic Entity Tag Sync"),
+      newrelic.ConfigLicense(licenseKey),
+    )
   }
 
+  return newInterop(v, secrets, options)
+}
+
+// New creates an Interop from the given configuration settings instead of a
+// configuration file. The settings use the same keys as the configuration
+// file. Secret references in the settings are resolved; the settings are
+// modified in place.
+func New(
+  settings          map[string]interface{},
+  opts              ...InteropOption,
+) (*Interop, error) {
+  options := newOptions(opts)
+
+  secretsConfig, _ := settings["secrets"].(map[string]interface{})
+
+  execConfig, err := newSecretExecConfig(secretsConfig)
+  if err != nil {
+    return nil, err
+  }
+
+  secrets := newSecretResolvers(options.secretResolvers, execConfig)
+
+  if _, err := secrets.resolveAll(context.Background(), settings); err != nil {
+    return nil, err
+  }
+
+  v := viper.New()
+  if err := v.MergeConfigMap(settings); err != nil {
+    return nil, fmt.Errorf("failed to load settings: %v", err)
+  }
+
+  return newInterop(v, secrets, options)
+}
+
+func newInterop(
+  v                 *viper.Viper,
+  secrets           secretResolvers,
+  options           *interopOptions,
+) (*Interop, error) {
   i := &Interop{}
-  i.App = app
+  i.App = options.app
+  i.Config = v
+  i.secrets = secrets
+  i.options = options
 
-  setupLogging(i, logger)
+  if options.logger != nil {
+    i.Logger = options.logger
+  } else {
+    logger := log.New()
+    logger.SetLevel(log.WarnLevel)
+    if i.App != nil {
+      logger.SetFormatter(nrlogrus.NewFormatter(i.App, &log.TextFormatter{}))
+    }
+
+    setupLogging(i, logger)
+  }
 
-  err = setupClient(i)
+  err := setupClient(i)
   if err != nil {
     return nil, err
   }
@@ -71,6 +223,12 @@ func NewInteroperability() (*Interop, error) {
   return i, nil
 }
 
+// ConfigFileUsed returns the configuration file that was read or an empty
+// string if the configuration was not read from a file.
+func (i *Interop) ConfigFileUsed() string {
+  return i.Config.ConfigFileUsed()
+}
+
 func (i *Interop) Shutdown() {
   if i.eventsEnabled {
     err := i.sendEventsAndWait()
@@ -83,6 +241,25 @@ func (i *Interop) Shutdown() {
 }
 
 func (i *Interop) EnableEvents(accountID int) error {
+  if i.eventsEnabled {
+    if accountID != i.eventsAccount {
+      return fmt.Errorf(
+        "events are already enabled for account %d; changing the events account requires a restart",
+        i.eventsAccount,
+      )
+    }
+
+    return nil
+  }
+
+  if i.options.eventSink != nil {
+    i.events = i.options.eventSink
+    i.eventsEnabled = true
+    i.eventsAccount = accountID
+
+    return nil
+  }
+
   // Start batch mode
   if err := i.NrClient.Events.BatchMode(
     context.Background(),
@@ -91,13 +268,137 @@ func (i *Interop) EnableEvents(accountID int) error {
     return fmt.Errorf("error starting batch events mode: %v", err)
   }
 
+  i.events = &i.NrClient.Events
   i.eventsEnabled = true
+  i.eventsAccount = accountID
 
   return nil
 }
 
+// ReloadConfig re-reads the configuration file and then calls apply to put the
+// new configuration into use. If the configuration can not be read or apply
+// returns an error, the previous configuration is restored. Only YAML and JSON
+// configuration files can be reloaded.
+func (i *Interop) ReloadConfig(apply func() error) error {
+  v := i.Config
+  if !nrConfig.IsSupportedFile(v.ConfigFileUsed()) {
+    return fmt.Errorf("only YAML and JSON configuration files can be reloaded")
+  }
+
+  previous := v.AllSettings()
+  previousSecrets := i.secrets
+  previousProfiles := i.profiles
+
+  err := v.ReadInConfig()
+  if err == nil {
+    var secrets secretResolvers
+
+    secrets, err = loadMergedConfig(v, i.options)
+    if err == nil {
+      i.secrets = secrets
+      err = i.reloadProfiles(apply)
+    }
+  }
+
+  if err != nil {
+    i.secrets = previousSecrets
+    i.profiles = previousProfiles
+
+    if restoreErr := restoreConfig(v, previous); restoreErr != nil {
+      i.Logger.Errorf("failed to restore previous config: %v", restoreErr)
+    }
+
+    return err
+  }
+
+  // Post the queued events of profiles that were removed or replaced
+  removed := map[string]*profile{}
+  for name, p := range previousProfiles {
+    if i.profiles[name] != p {
+      removed[name] = p
+    }
+  }
+
+  if err := i.flushProfileEvents(removed); err != nil {
+    i.Logger.Warnf("%v", err)
+  }
+
+  return nil
+}
+
+func (i *Interop) reloadProfiles(apply func() error) error {
+  profiles, err := i.loadProfiles(i.licenseKey, i.profiles)
+  if err != nil {
+    return err
+  }
+
+  i.profiles = profiles
+
+  return apply()
+}
+
+func restoreConfig(v *viper.Viper, settings map[string]interface{}) error {
+  data, err := yaml.Marshal(settings)
+  if err != nil {
+    return err
+  }
+
+  v.SetConfigType("yaml")
+
+  return v.ReadConfig(bytes.NewReader(data))
+}
+
+// loadMergedConfig replaces the configuration read by viper with the merged
+// configuration tree, which includes mappings from included files, mapping
+// defaults and environment variable references, and in which all secret
+// references have been resolved. The secret resolvers are returned so that
+// secret references in environment variables can also be resolved.
+func loadMergedConfig(
+  v                 *viper.Viper,
+  options           *interopOptions,
+) (secretResolvers, error) {
+  configFile := v.ConfigFileUsed()
+  if !nrConfig.IsSupportedFile(configFile) {
+    return newSecretResolvers(options.secretResolvers, &secretExecConfig{
+      timeout: DEFAULT_SECRET_EXEC_TIMEOUT,
+    }), nil
+  }
+
+  tree, err := nrConfig.Load(configFile)
+  if err != nil {
+    return nil, err
+  }
+
+  secretsConfig, _ := tree.Values["secrets"].(map[string]interface{})
+
+  execConfig, err := newSecretExecConfig(secretsConfig)
+  if err != nil {
+    return nil, err
+  }
+
+  secrets := newSecretResolvers(options.secretResolvers, execConfig)
+
+  if _, err := secrets.resolveAll(context.Background(), tree.Values); err != nil {
+    return nil, err
+  }
+
+  data, err := yaml.Marshal(tree.Values)
+  if err != nil {
+    return nil, fmt.Errorf("failed to load merged config: %v", err)
+  }
+
+  // JSON is a subset of YAML so the merged tree is always read as YAML
+  v.SetConfigType("yaml")
+
+  if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+    return nil, err
+  }
+
+  return secrets, nil
+}
+
 func setupLogging(i *Interop, logger *log.Logger) {
-  logLevel := viper.GetString("log.level")
+  logLevel := i.Config.GetString("log.level")
   if logLevel != "" {
     level, err := log.ParseLevel(logLevel)
     if err != nil {
@@ -107,9 +408,9 @@ func setupLogging(i *Interop, logger *log.Logger) {
     }
   }
 
-  if viper.IsSet("log.fileName") {
+  if i.Config.IsSet("log.fileName") {
     file, err := os.OpenFile(
-      viper.GetString("log.fileName"),
+      i.Config.GetString("log.fileName"),
       os.O_CREATE|os.O_WRONLY|os.O_APPEND,
       0666,
     )
@@ -124,23 +425,40 @@ func setupLogging(i *Interop, logger *log.Logger) {
 }
 
 func setupClient(i *Interop) error {
-  licenseKey := viper.GetString("licenseKey")
+  var err error
+
+  licenseKey := i.Config.GetString("licenseKey")
   if licenseKey == "" {
-    licenseKey = os.Getenv("NEW_RELIC_LICENSE_KEY")
-    if licenseKey == "" {
+    licenseKey, err = i.ResolveSecret(os.Getenv("NEW_RELIC_LICENSE_KEY"))
+    if err != nil {
+      return err
+    }
+
+    if licenseKey == "" && i.options.client == nil {
       return fmt.Errorf("missing New Relic license key")
     }
   }
 
-  apiKey := viper.GetString("apiKey")
+  i.licenseKey = licenseKey
+
+  if i.options.client != nil {
+    i.NrClient = i.options.client
+    return i.setupProfiles()
+  }
+
+  apiKey := i.Config.GetString("apiKey")
   if apiKey == "" {
-    apiKey = os.Getenv("NEW_RELIC_API_KEY")
+    apiKey, err = i.ResolveSecret(os.Getenv("NEW_RELIC_API_KEY"))
+    if err != nil {
+      return err
+    }
+
     if apiKey == "" {
       return fmt.Errorf("missing New Relic API key")
     }
   }
 
-  nrRegion := viper.GetString("region")
+  nrRegion := i.Config.GetString("region")
   if nrRegion == "" {
     nrRegion = os.Getenv("NEW_RELIC_REGION")
     if nrRegion == "" {
@@ -163,15 +481,34 @@ func setupClient(i *Interop) error {
 
   i.NrClient = client
 
+  return i.setupProfiles()
+}
+
+func (i *Interop) setupProfiles() error {
+  profiles, err := i.loadProfiles(i.licenseKey, nil)
+  if err != nil {
+    return err
+  }
+
+  i.profiles = profiles
+
   return nil
 }
 
 func (i *Interop) sendEventsAndWait() error {
-  err := i.NrClient.Events.Flush()
+  err := i.events.Flush()
   if err != nil {
     return err
   }
 
+  if err := i.flushProfileEvents(i.profiles); err != nil {
+    return err
+  }
+
+  if i.options.eventSink != nil {
+    return nil
+  }
+
   <-time.After(3 * time.Second)
 
   return nil
@@ -0,0 +1,200 @@
+package interop
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+  "sync"
+
+  nrClient "github.com/newrelic/newrelic-client-go/newrelic"
+  "github.com/newrelic/newrelic-client-go/pkg/logging"
+  "github.com/newrelic/newrelic-client-go/pkg/region"
+  "github.com/spf13/cast"
+)
+
+// profile is a named New Relic connection profile configured in the profiles
+// section. Each profile has its own client and may have its own events
+// account.
+type profile struct {
+  name              string
+  config            map[string]interface{}
+  client            *nrClient.NewRelic
+  eventsAccount     int
+  lock              sync.Mutex
+  eventsEnabled     bool
+}
+
+// Client returns the New Relic client for the named connection profile, or
+// the default client if the name is empty. Profile names are case-insensitive.
+func (i *Interop) Client(name string) (*nrClient.NewRelic, error) {
+  if name == "" {
+    return i.NrClient, nil
+  }
+
+  p, ok := i.profiles[strings.ToLower(name)]
+  if !ok {
+    return nil, fmt.Errorf("unknown New Relic profile %s", name)
+  }
+
+  return p.client, nil
+}
+
+// HasProfile returns true if a connection profile with the given name is
+// configured.
+func (i *Interop) HasProfile(name string) bool {
+  _, ok := i.profiles[strings.ToLower(name)]
+  return ok
+}
+
+// EnqueueEvent queues an event to be posted to the events account of the named
+// connection profile. Events for profiles without an events account, events
+// without a profile and all events when an event sink was set with
+// WithEventSink, are sent to the default event sink. Events must have been
+// enabled with EnableEvents.
+func (i *Interop) EnqueueEvent(profileName string, event interface{}) error {
+  p, ok := i.profiles[strings.ToLower(profileName)]
+  if !ok || p.eventsAccount == 0 || i.options.eventSink != nil {
+    return i.events.EnqueueEvent(context.Background(), event)
+  }
+
+  if err := p.enableEvents(); err != nil {
+    return err
+  }
+
+  return p.client.Events.EnqueueEvent(context.Background(), event)
+}
+
+// EventsAccount returns the client for and the ID of the account that events
+// queued with EnqueueEvent for the named connection profile are posted to.
+func (i *Interop) EventsAccount(profileName string) (*nrClient.NewRelic, int) {
+  p, ok := i.profiles[strings.ToLower(profileName)]
+  if !ok || p.eventsAccount == 0 || i.options.eventSink != nil {
+    return i.NrClient, i.eventsAccount
+  }
+
+  return p.client, p.eventsAccount
+}
+
+func (p *profile) enableEvents() error {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+
+  if p.eventsEnabled {
+    return nil
+  }
+
+  if err := p.client.Events.BatchMode(
+    context.Background(),
+    p.eventsAccount,
+  ); err != nil {
+    return fmt.Errorf(
+      "error starting batch events mode for profile %s: %v",
+      p.name,
+      err,
+    )
+  }
+
+  p.eventsEnabled = true
+
+  return nil
+}
+
+func (p *profile) flushEvents() error {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+
+  if !p.eventsEnabled {
+    return nil
+  }
+
+  return p.client.Events.Flush()
+}
+
+// loadProfiles creates a client for each connection profile in the profiles
+// section. Profiles in previous are reused if their configuration has not
+// changed so that queued events are not lost.
+func (i *Interop) loadProfiles(
+  licenseKey        string,
+  previous          map[string]*profile,
+) (map[string]*profile, error) {
+  profiles := map[string]*profile{}
+
+  // Viper lowercases keys so profile names are case-insensitive
+  for name := range i.Config.GetStringMap("profiles") {
+    config := i.Config.GetStringMap("profiles." + name)
+
+    if prev, ok := previous[name]; ok && reflect.DeepEqual(prev.config, config) {
+      profiles[name] = prev
+      continue
+    }
+
+    p, err := i.newProfile(name, config, licenseKey)
+    if err != nil {
+      return nil, err
+    }
+
+    profiles[name] = p
+  }
+
+  return profiles, nil
+}
+
+func (i *Interop) newProfile(
+  name              string,
+  config            map[string]interface{},
+  licenseKey        string,
+) (*profile, error) {
+  apiKey := cast.ToString(config["apikey"])
+  if apiKey == "" {
+    return nil, fmt.Errorf("missing New Relic API key for profile %s", name)
+  }
+
+  nrRegion := cast.ToString(config["region"])
+  if nrRegion == "" {
+    nrRegion = string(region.Default)
+  }
+
+  if key := cast.ToString(config["licensekey"]); key != "" {
+    licenseKey = key
+  }
+
+  eventsAccount, err := cast.ToIntE(config["eventsaccountid"])
+  if err != nil {
+    return nil, fmt.Errorf("invalid events account ID for profile %s: %v", name, err)
+  }
+
+  client, err := nrClient.New(
+    ConfigLicenseKey(licenseKey),
+    nrClient.ConfigPersonalAPIKey(apiKey),
+    nrClient.ConfigRegion(nrRegion),
+    nrClient.ConfigLogger(
+      logging.NewLogrusLogger(logging.ConfigLoggerInstance(i.Logger)),
+    ),
+  )
+  if err != nil {
+    return nil, fmt.Errorf(
+      "error creating New Relic client for profile %s: %v",
+      name,
+      err,
+    )
+  }
+
+  return &profile{
+    name: name,
+    config: config,
+    client: client,
+    eventsAccount: eventsAccount,
+  }, nil
+}
+
+// flushProfileEvents posts the queued events of the given profiles.
+func (i *Interop) flushProfileEvents(profiles map[string]*profile) error {
+  for _, p := range profiles {
+    if err := p.flushEvents(); err != nil {
+      return fmt.Errorf("flush events for profile %s failed: %v", p.name, err)
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,227 @@
+package interop
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "os"
+  "os/exec"
+  "strings"
+  "time"
+
+  "github.com/spf13/cast"
+)
+
+const (
+  SECRET_REF_PREFIX           = "secret://"
+  SECRET_SCHEME_FILE          = "file"
+  SECRET_SCHEME_ENV           = "env"
+  SECRET_SCHEME_EXEC          = "exec"
+  DEFAULT_SECRET_EXEC_TIMEOUT = 10 * time.Second
+)
+
+// SecretResolver resolves the secret identified by ref. For a secret reference
+// of the form secret://<scheme>/<ref>, the resolver registered for the scheme
+// is called with the part of the reference after the scheme.
+type SecretResolver interface {
+  Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc is an adapter to allow the use of ordinary functions as
+// secret resolvers.
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+  return f(ctx, ref)
+}
+
+// WithSecretResolver registers a secret resolver for the given scheme,
+// replacing the built-in resolver for the scheme if there is one.
+func WithSecretResolver(scheme string, resolver SecretResolver) InteropOption {
+  return func(o *interopOptions) {
+    if o.secretResolvers == nil {
+      o.secretResolvers = map[string]SecretResolver{}
+    }
+
+    o.secretResolvers[scheme] = resolver
+  }
+}
+
+// IsSecretRef returns true if the given value is a secret reference.
+func IsSecretRef(value string) bool {
+  return strings.HasPrefix(value, SECRET_REF_PREFIX)
+}
+
+// ResolveSecret resolves the given value if it is a secret reference and
+// returns it unchanged otherwise. Errors never include the resolved value.
+func (i *Interop) ResolveSecret(value string) (string, error) {
+  return i.secrets.resolve(context.Background(), value)
+}
+
+type secretResolvers map[string]SecretResolver
+
+func newSecretResolvers(
+  custom            map[string]SecretResolver,
+  execConfig        *secretExecConfig,
+) secretResolvers {
+  resolvers := secretResolvers{
+    SECRET_SCHEME_FILE: SecretResolverFunc(resolveFileSecret),
+    SECRET_SCHEME_ENV: SecretResolverFunc(resolveEnvSecret),
+    SECRET_SCHEME_EXEC: execConfig,
+  }
+
+  for scheme, resolver := range custom {
+    resolvers[scheme] = resolver
+  }
+
+  return resolvers
+}
+
+func (r secretResolvers) resolve(ctx context.Context, value string) (string, error) {
+  ref, ok := strings.CutPrefix(value, SECRET_REF_PREFIX)
+  if !ok {
+    return value, nil
+  }
+
+  scheme, path, ok := strings.Cut(ref, "/")
+  if !ok || path == "" {
+    return "", fmt.Errorf("invalid secret reference %s", value)
+  }
+
+  resolver, ok := r[scheme]
+  if !ok {
+    return "", fmt.Errorf("unsupported secret reference scheme %s", scheme)
+  }
+
+  secret, err := resolver.Resolve(ctx, path)
+  if err != nil {
+    return "", fmt.Errorf("failed to resolve secret reference %s: %v", value, err)
+  }
+
+  return secret, nil
+}
+
+// resolveAll replaces every string value in the given configuration tree that
+// is a secret reference with the resolved secret.
+func (r secretResolvers) resolveAll(
+  ctx               context.Context,
+  value             interface{},
+) (interface{}, error) {
+  switch u := value.(type) {
+  case string:
+    return r.resolve(ctx, u)
+
+  case map[string]interface{}:
+    for k, v := range u {
+      resolved, err := r.resolveAll(ctx, v)
+      if err != nil {
+        return nil, err
+      }
+
+      u[k] = resolved
+    }
+
+  case []interface{}:
+    for i, v := range u {
+      resolved, err := r.resolveAll(ctx, v)
+      if err != nil {
+        return nil, err
+      }
+
+      u[i] = resolved
+    }
+  }
+
+  return value, nil
+}
+
+// resolveFileSecret reads the secret from a file, such as a mounted Kubernetes
+// or Docker secret. Trailing newlines are removed.
+func resolveFileSecret(ctx context.Context, ref string) (string, error) {
+  // secret://file/run/secrets/x refers to the absolute path /run/secrets/x
+  // while secret://file/./secrets/x refers to a relative path
+  path := ref
+  if !strings.HasPrefix(ref, ".") {
+    path = "/" + strings.TrimPrefix(ref, "/")
+  }
+
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return "", err
+  }
+
+  return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func resolveEnvSecret(ctx context.Context, ref string) (string, error) {
+  value, ok := os.LookupEnv(ref)
+  if !ok {
+    return "", fmt.Errorf("environment variable %s is not set", ref)
+  }
+
+  return value, nil
+}
+
+// secretExecConfig resolves secrets by running a helper command with the
+// reference as the last argument and reading the secret from its standard
+// output.
+type secretExecConfig struct {
+  command           []string
+  timeout           time.Duration
+}
+
+func newSecretExecConfig(secrets map[string]interface{}) (*secretExecConfig, error) {
+  execConfig := &secretExecConfig{ timeout: DEFAULT_SECRET_EXEC_TIMEOUT }
+
+  if secrets == nil {
+    return execConfig, nil
+  }
+
+  if value, ok := secrets["execCommand"]; ok && value != nil {
+    command, err := cast.ToStringSliceE(value)
+    if err != nil {
+      return nil, fmt.Errorf("invalid secrets.execCommand: %v", err)
+    }
+
+    execConfig.command = command
+  }
+
+  if timeout, ok := secrets["execTimeout"]; ok {
+    d, err := cast.ToDurationE(timeout)
+    if err != nil {
+      return nil, fmt.Errorf("invalid secrets.execTimeout: %v", err)
+    }
+
+    execConfig.timeout = d
+  }
+
+  return execConfig, nil
+}
+
+func (c *secretExecConfig) Resolve(ctx context.Context, ref string) (string, error) {
+  if len(c.command) == 0 {
+    return "", fmt.Errorf("missing secrets.execCommand")
+  }
+
+  ctx, cancel := context.WithTimeout(ctx, c.timeout)
+  defer cancel()
+
+  args := append(append([]string{}, c.command[1:]...), ref)
+  cmd := exec.CommandContext(ctx, c.command[0], args...)
+
+  var stdout, stderr bytes.Buffer
+  cmd.Stdout = &stdout
+  cmd.Stderr = &stderr
+
+  if err := cmd.Run(); err != nil {
+    // Only the helper's standard error is reported since its standard output
+    // may contain the secret
+    return "", fmt.Errorf(
+      "secret helper failed: %v: %s",
+      err,
+      strings.TrimSpace(stderr.String()),
+    )
+  }
+
+  return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,114 @@
+package tagsync
+
+import (
+  "fmt"
+
+  "gopkg.in/yaml.v3"
+)
+
+// Config is the typed equivalent of the configuration file. Fields that are
+// not set use the same defaults as the configuration file, including the
+// NEW_RELIC_* environment variables for the New Relic credentials. String
+// values may be secret references.
+type Config struct {
+  ApiKey            string                    `yaml:"apiKey,omitempty"`
+  LicenseKey        string                    `yaml:"licenseKey,omitempty"`
+  Region            string                    `yaml:"region,omitempty"`
+  Profiles          map[string]ProfileConfig  `yaml:"profiles,omitempty"`
+  Log               *LogConfig                `yaml:"log,omitempty"`
+  Secrets           *SecretsConfig            `yaml:"secrets,omitempty"`
+  Events            *EventsConfig             `yaml:"events,omitempty"`
+  Provider          *ProviderConfig           `yaml:"provider,omitempty"`
+  Providers         map[string]ProviderConfig `yaml:"providers,omitempty"`
+  Mappings          []MappingConfig           `yaml:"mappings"`
+}
+
+// ProfileConfig is a named New Relic connection profile.
+type ProfileConfig struct {
+  ApiKey            string                    `yaml:"apiKey"`
+  Region            string                    `yaml:"region,omitempty"`
+  LicenseKey        string                    `yaml:"licenseKey,omitempty"`
+  EventsAccountId   int                       `yaml:"eventsAccountId,omitempty"`
+}
+
+type LogConfig struct {
+  Level             string                    `yaml:"level,omitempty"`
+  FileName          string                    `yaml:"fileName,omitempty"`
+}
+
+type SecretsConfig struct {
+  ExecCommand       []string                  `yaml:"execCommand,omitempty"`
+  // ExecTimeout is a duration such as 30s
+  ExecTimeout       string                    `yaml:"execTimeout,omitempty"`
+}
+
+type EventsConfig struct {
+  Enabled           bool                      `yaml:"enabled"`
+  AccountId         int                       `yaml:"accountId,omitempty"`
+  EventType         string                    `yaml:"eventType,omitempty"`
+}
+
+// ProviderConfig configures a provider. Settings holds the parameters that are
+// specific to the provider type, keyed by the names used in the configuration
+// file.
+type ProviderConfig struct {
+  Type              string                    `yaml:"type"`
+  UseLastUpdate     bool                      `yaml:"useLastUpdate,omitempty"`
+  Settings          map[string]interface{}    `yaml:",inline"`
+}
+
+type MappingConfig struct {
+  Name              string                    `yaml:"name,omitempty"`
+  Enabled           *bool                     `yaml:"enabled,omitempty"`
+  Labels            []string                  `yaml:"labels,omitempty"`
+  Provider          string                    `yaml:"provider,omitempty"`
+  Schedule          string                    `yaml:"schedule,omitempty"`
+  ExtEntityQuery    map[string]interface{}    `yaml:"extEntityQuery"`
+  EntityQuery       EntityQuery               `yaml:"entityQuery"`
+  Match             Match                     `yaml:"match"`
+  Mapping           map[string]string         `yaml:"mapping"`
+  Relationships     []Relationship            `yaml:"relationships,omitempty"`
+}
+
+// Relationship declares the New Relic entity relationships that are traversed
+// from each matched entity so that related entities inherit its tags.
+// Direction and Depth use the same defaults as the configuration file when
+// they are not set.
+type Relationship struct {
+  Type              string                    `yaml:"type"`
+  Direction         string                    `yaml:"direction,omitempty"`
+  Depth             int                       `yaml:"depth,omitempty"`
+}
+
+type EntityQuery struct {
+  Type              []string                  `yaml:"type,omitempty"`
+  Domain            []string                  `yaml:"domain,omitempty"`
+  Name              string                    `yaml:"name,omitempty"`
+  AccountId         int                       `yaml:"accountId,omitempty"`
+  Tags              []Tag                     `yaml:"tags,omitempty"`
+  Query             string                    `yaml:"query,omitempty"`
+  Profile           string                    `yaml:"profile,omitempty"`
+}
+
+type Match struct {
+  ExtEntityKey      string                    `yaml:"extEntityKey"`
+  Operator          string                    `yaml:"operator"`
+  EntityKey         string                    `yaml:"entityKey"`
+}
+
+// settings converts the configuration to the generic configuration tree used
+// by the configuration file.
+func (c *Config) settings() (map[string]interface{}, error) {
+  data, err := yaml.Marshal(c)
+  if err != nil {
+    return nil, fmt.Errorf("failed to convert config: %v", err)
+  }
+
+  settings := map[string]interface{}{}
+
+  if err := yaml.Unmarshal(data, &settings); err != nil {
+    return nil, fmt.Errorf("failed to convert config: %v", err)
+  }
+
+  return settings, nil
+}
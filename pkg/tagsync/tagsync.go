@@ -0,0 +1,220 @@
+// Package tagsync is the public API for embedding the entity tag sync engine.
+// A Syncer is created from a Config with New or from a configuration file with
+// Load, and runs or plans the mappings of the configuration.
+package tagsync
+
+import (
+  "context"
+  "fmt"
+
+  "github.com/newrelic/go-agent/v3/newrelic"
+  nrClient "github.com/newrelic/newrelic-client-go/newrelic"
+  "github.com/newrelic/nr-entity-tag-sync/internal/server"
+  "github.com/newrelic/nr-entity-tag-sync/internal/sync"
+  "github.com/newrelic/nr-entity-tag-sync/pkg/interop"
+  log "github.com/sirupsen/logrus"
+
+  // Built-in providers
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/backstage"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/exec"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/file"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/http"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/kubernetes"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/newrelic"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/servicenow"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/sql"
+  _ "github.com/newrelic/nr-entity-tag-sync/internal/provider/terraform"
+)
+
+const (
+  SETUP_INTEROP = "interop"
+  SETUP_SYNCER  = "syncer"
+)
+
+// SetupError is returned by New and Load when the syncer can not be created.
+// Step is SETUP_INTEROP if the configuration could not be read or the New
+// Relic client could not be created and SETUP_SYNCER if the configuration is
+// invalid or the syncer could not be created.
+type SetupError struct {
+  Step              string
+  Err               error
+}
+
+func (e *SetupError) Error() string {
+  return fmt.Sprintf("failed to create %s: %s", e.Step, e.Err)
+}
+
+func (e *SetupError) Unwrap() error {
+  return e.Err
+}
+
+// EventSink receives audit events.
+type EventSink = interop.EventSink
+
+// SecretResolver resolves secret references for a scheme.
+type SecretResolver = interop.SecretResolver
+
+// Option configures a Syncer.
+type Option = interop.InteropOption
+
+// WithLogger sets the logger used by the syncer and its providers instead of
+// creating one from the log section of the configuration.
+func WithLogger(logger *log.Logger) Option {
+  return interop.WithLogger(logger)
+}
+
+// WithClient sets the New Relic client used to search for and tag entities
+// and to post audit events instead of creating one from the configuration.
+func WithClient(client *nrClient.NewRelic) Option {
+  return interop.WithClient(client)
+}
+
+// WithEventSink sets the sink that receives all audit events instead of
+// posting them to New Relic. Events must still be enabled in the
+// configuration.
+func WithEventSink(sink EventSink) Option {
+  return interop.WithEventSink(sink)
+}
+
+// WithApplication sets the New Relic APM application used to instrument
+// synchronization cycles.
+func WithApplication(app *newrelic.Application) Option {
+  return interop.WithApplication(app)
+}
+
+// WithSecretResolver registers a resolver for secret references with the
+// given scheme.
+func WithSecretResolver(scheme string, resolver SecretResolver) Option {
+  return interop.WithSecretResolver(scheme, resolver)
+}
+
+// Syncer runs the mappings of a configuration.
+type Syncer struct {
+  i                 *interop.Interop
+  syncer            *sync.Syncer
+}
+
+// New creates a Syncer from the given configuration. The configuration is
+// validated in the same way as a configuration file.
+func New(config *Config, opts ...Option) (*Syncer, error) {
+  settings, err := config.settings()
+  if err != nil {
+    return nil, &SetupError{ SETUP_SYNCER, err }
+  }
+
+  if err := sync.ValidateSettings(settings); err != nil {
+    return nil, &SetupError{ SETUP_SYNCER, err }
+  }
+
+  i, err := interop.New(settings, opts...)
+  if err != nil {
+    return nil, &SetupError{ SETUP_INTEROP, err }
+  }
+
+  return newSyncer(i)
+}
+
+// Load creates a Syncer from the given configuration file. If configFile is
+// empty, a file named config is searched for in the configs directory and the
+// current directory. The file is read into the global viper instance.
+func Load(configFile string, opts ...Option) (*Syncer, error) {
+  i, err := interop.NewInteroperability(
+    append(opts, interop.ConfigFile(configFile))...,
+  )
+  if err != nil {
+    return nil, &SetupError{ SETUP_INTEROP, err }
+  }
+
+  return newSyncer(i)
+}
+
+func newSyncer(i *interop.Interop) (*Syncer, error) {
+  syncer, err := sync.New(i)
+  if err != nil {
+    i.Shutdown()
+    return nil, &SetupError{ SETUP_SYNCER, err }
+  }
+
+  return &Syncer{ i, syncer }, nil
+}
+
+// Close closes the providers, posts any queued audit events and releases the
+// resources of the syncer.
+func (s *Syncer) Close() {
+  s.syncer.Close()
+  s.i.Shutdown()
+}
+
+// SetRequestId sets an identifier for the invocation that triggered the sync,
+// such as an AWS Lambda request ID, which is added to all audit events.
+func (s *Syncer) SetRequestId(requestId string) {
+  s.syncer.SetRequestId(requestId)
+}
+
+// SelectMappings returns the indices of the mappings identified by the given
+// mapping names or indices and labels. Names and indices select mappings even
+// if they are disabled while labels select enabled mappings. If neither are
+// given, the indices of all enabled mappings are returned.
+func (s *Syncer) SelectMappings(mappings []string, labels []string) ([]int, error) {
+  return s.syncer.SelectMappings(mappings, labels)
+}
+
+// Sync runs the mappings at the given indices, or all enabled mappings if
+// indices is nil, and applies the tag changes. The returned error is non-nil if
+// the run failed or if any mapping completed with errors; the result is
+// returned in both cases.
+func (s *Syncer) Sync(ctx context.Context, indices []int) (*SyncResult, error) {
+  indices, err := s.defaultIndices(indices)
+  if err != nil {
+    return nil, err
+  }
+
+  return s.syncer.RunContext(ctx, indices)
+}
+
+// Plan is like Sync but does not apply any tag changes. The changes that would
+// be applied are returned in the result for each mapping.
+func (s *Syncer) Plan(ctx context.Context, indices []int) (*SyncResult, error) {
+  indices, err := s.defaultIndices(indices)
+  if err != nil {
+    return nil, err
+  }
+
+  return s.syncer.PlanContext(ctx, indices)
+}
+
+// Explain reports how each of the mappings at the given indices, or all
+// enabled mappings if indices is nil, applies to the New Relic entity with the
+// given GUID or name.
+func (s *Syncer) Explain(
+  ctx               context.Context,
+  indices           []int,
+  entity            string,
+) (*ExplainResult, error) {
+  indices, err := s.defaultIndices(indices)
+  if err != nil {
+    return nil, err
+  }
+
+  return s.syncer.ExplainContext(ctx, indices, entity)
+}
+
+// Serve runs the mappings on their schedules and serves the status, metrics
+// and webhook endpoints until the context is cancelled, using the server
+// section of the configuration.
+func (s *Syncer) Serve(ctx context.Context) error {
+  srv, err := server.New(s.i, s.syncer)
+  if err != nil {
+    return fmt.Errorf("failed to create server: %s", err)
+  }
+
+  return srv.Run(ctx)
+}
+
+func (s *Syncer) defaultIndices(indices []int) ([]int, error) {
+  if indices != nil {
+    return indices, nil
+  }
+
+  return s.syncer.SelectMappings(nil, nil)
+}
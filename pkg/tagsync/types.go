@@ -0,0 +1,60 @@
+package tagsync
+
+import (
+  "github.com/newrelic/nr-entity-tag-sync/internal/config"
+  "github.com/newrelic/nr-entity-tag-sync/internal/provider"
+  "github.com/newrelic/nr-entity-tag-sync/internal/sync"
+)
+
+// Results of runs, plans and explanations.
+type (
+  SyncResult            = sync.SyncResult
+  MappingResult         = sync.MappingResult
+  EntityChange          = sync.EntityChange
+  ExplainResult         = sync.ExplainResult
+  ExplainMappingResult  = sync.ExplainMappingResult
+  ExplainCandidate      = sync.ExplainCandidate
+  RelationshipResult    = sync.RelationshipResult
+  Tag                   = sync.Tag
+)
+
+// ValidationError is returned, wrapped in a SetupError, when the configuration
+// is invalid. It lists every problem found.
+type (
+  ValidationError       = config.ValidationError
+  Problem               = config.Problem
+)
+
+// Provider types used to implement and register providers.
+type (
+  Provider              = provider.Provider
+  ContextProvider       = provider.ContextProvider
+  EntityGetter          = provider.EntityGetter
+  PageCounter           = provider.PageCounter
+  Entity                = provider.Entity
+  ProviderInitFn        = provider.InitFn
+  ProviderV2            = provider.ProviderV2
+  ProviderInitFnV2      = provider.InitFnV2
+  Capabilities          = provider.Capabilities
+  Query                 = provider.Query
+  EntityIterator        = provider.EntityIterator
+  EntityWriter          = provider.EntityWriter
+)
+
+// RegisterProvider registers a v1 provider for the given provider type. The
+// provider is adapted to ProviderV2 and its provider specific parameters are
+// not validated.
+func RegisterProvider(providerType string, initFn ProviderInitFn) {
+  provider.RegisterProvider(providerType, initFn)
+}
+
+// RegisterProviderV2 registers a provider for the given provider type. The
+// provider specific parameters of its configuration are not validated.
+func RegisterProviderV2(providerType string, initFn ProviderInitFnV2) {
+  provider.RegisterProviderV2(providerType, initFn)
+}
+
+// RegisteredProviders returns the sorted list of registered provider types.
+func RegisteredProviders() []string {
+  return provider.RegisteredProviders()
+}